@@ -0,0 +1,122 @@
+// Package cache provides a size-bounded LRU cache with a time-to-live.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache holds values up to a total size in bytes, evicting the least recently
+// used entries first. Entries older than the TTL are treated as missing.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+	bytes    int64
+	hits     uint64
+	misses   uint64
+}
+
+type entry struct {
+	key    string
+	value  interface{}
+	size   int64
+	stored time.Time
+}
+
+// Stats is a snapshot of cache usage.
+type Stats struct {
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"maxBytes"`
+	TTLMs    int64  `json:"ttlMs"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// New returns a cache limited to maxBytes. A maxBytes of zero disables
+// caching; a ttl of zero means entries never expire.
+func New(maxBytes int64, ttl time.Duration) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Enabled reports whether the cache stores anything at all.
+func (c *Cache) Enabled() bool {
+	return c.maxBytes > 0
+}
+
+// Get returns the value for key and when it was stored.
+func (c *Cache) Get(key string) (interface{}, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, time.Time{}, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && time.Since(e.stored) > c.ttl {
+		c.remove(el)
+		c.misses++
+		return nil, time.Time{}, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits++
+	return e.value, e.stored, true
+}
+
+// Put stores value under key. Values larger than the whole cache are dropped.
+func (c *Cache) Put(key string, value interface{}, size int64) {
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, size: size, stored: time.Now()})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Purge removes every entry.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	c.bytes = 0
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Entries:  c.ll.Len(),
+		Bytes:    c.bytes,
+		MaxBytes: c.maxBytes,
+		TTLMs:    c.ttl.Milliseconds(),
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func keys(c *Cache) []string {
+	var out []string
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		out = append(out, el.Value.(*entry).key)
+	}
+	return out
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(30, 0)
+	c.Put("a", 1, 10)
+	c.Put("b", 2, 10)
+	c.Put("c", 3, 10)
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	c.Put("d", 4, 10) // evicts b, the least recently used
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, _, ok := c.Get(k); !ok {
+			t.Errorf("%s should be cached", k)
+		}
+	}
+
+	c.Put("big", 5, 25) // evicts as many entries as it needs room for
+	if got := keys(c); len(got) != 1 || got[0] != "big" {
+		t.Errorf("after a large put: %v", got)
+	}
+	if s := c.Stats(); s.Bytes != 25 || s.Entries != 1 {
+		t.Errorf("stats after a large put: %+v", s)
+	}
+}
+
+func TestPut(t *testing.T) {
+	c := New(20, 0)
+	c.Put("a", 1, 10)
+	c.Put("a", 2, 15) // replacing an entry updates its value and size
+	if v, _, _ := c.Get("a"); v != 2 {
+		t.Errorf("got %v, want 2", v)
+	}
+	if s := c.Stats(); s.Bytes != 15 || s.Entries != 1 {
+		t.Errorf("stats after replace: %+v", s)
+	}
+
+	c.Put("huge", 3, 21) // larger than the whole cache: dropped
+	if _, _, ok := c.Get("huge"); ok {
+		t.Error("an oversized value should not be stored")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Error("an oversized value should not evict anything")
+	}
+
+	disabled := New(0, 0)
+	disabled.Put("a", 1, 0)
+	if disabled.Enabled() {
+		t.Error("a zero-size cache should be disabled")
+	}
+}
+
+func TestTTLAndPurge(t *testing.T) {
+	c := New(100, 20*time.Millisecond)
+	c.Put("a", 1, 10)
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, _, ok := c.Get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 || s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("stats after expiry: %+v", s)
+	}
+
+	c.Put("b", 2, 10)
+	c.Purge()
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("stats after purge: %+v", s)
+	}
+}
@@ -0,0 +1,221 @@
+// Package cron parses cron expressions and computes when they next fire.
+//
+// Expressions have the standard five fields (minute, hour, day of month,
+// month, day of week). Each field accepts *, numbers, ranges (1-5), steps
+// (*/15, 10-50/10) and comma-separated lists; months and weekdays may also be
+// given as names (JAN, MON). Day of week runs 0-7 with both 0 and 7 meaning
+// Sunday. As in classic cron, when both day fields are restricted (neither
+// starts with *) a day matches if either does.
+//
+// The macros @yearly, @monthly, @weekly, @daily, @hourly and "@every
+// <duration>" are also understood.
+//
+// Around daylight saving changes, schedules with a fixed hour behave as in
+// classic cron: a time skipped when the clocks go forward fires right after
+// the change, and a time repeated when they go back fires only once.
+// Schedules with * in the hour field follow elapsed time.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny, hourAny       bool
+	every                         time.Duration
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid interval %q: want a duration of at least 1m", rest)
+		}
+		return &Schedule{every: d}, nil
+	}
+	if m, ok := macros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+	s := &Schedule{
+		hourAny: strings.HasPrefix(fields[1], "*"),
+		domAny:  strings.HasPrefix(fields[2], "*") || fields[2] == "?",
+		dowAny:  strings.HasPrefix(fields[4], "*") || fields[4] == "?",
+	}
+	var err error
+	for i, dst := range []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow} {
+		f := []field{minuteField, hourField, domField, monthField, dowField}[i]
+		if *dst, err = f.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, f.name)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" && rng != "?" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loText); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiText); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field (want %d-%d)", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that the schedule fires, in t's
+// location, or the zero time if it never does (such as "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(time.Second).Add(s.every)
+	}
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		case !s.hourAny && repeatedWallTime(t):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// A daylight saving change can map the wall time we asked for back
+		// to an earlier instant; step forward instead of looping
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		if !s.hourAny && s.firesInGap(t, next) {
+			return next
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// repeatedWallTime reports whether t's wall clock time already happened
+// earlier, in the hour repeated when daylight saving time ends.
+func repeatedWallTime(t time.Time) bool {
+	_, off := t.Zone()
+	_, before := t.Add(-2 * time.Hour).Zone()
+	if before <= off {
+		return false
+	}
+	u := t.Add(-time.Duration(before-off) * time.Second)
+	return u.Hour() == t.Hour() && u.Minute() == t.Minute()
+}
+
+// firesInGap reports whether the schedule would have fired at a wall clock
+// time skipped between t and next, when daylight saving time starts.
+func (s *Schedule) firesInGap(t, next time.Time) bool {
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+	for w := wall(t).Add(next.Sub(t)); w.Before(wall(next)); w = w.Add(time.Minute) {
+		if s.month&(1<<uint(w.Month())) != 0 && s.dayMatches(w) &&
+			s.hour&(1<<uint(w.Hour())) != 0 && s.minute&(1<<uint(w.Minute())) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
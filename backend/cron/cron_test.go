@@ -0,0 +1,119 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * FOO *",
+		"@every 30s", "@every soon",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		expr, from string
+		want       []string
+	}{
+		{"*/15 * * * *", "2024-05-01 10:07", []string{"2024-05-01 10:15", "2024-05-01 10:30"}},
+		{"0 9 * * MON-FRI", "2024-05-03 09:00", []string{"2024-05-06 09:00", "2024-05-07 09:00"}},
+		{"10-50/20 8 * * *", "2024-05-01 08:30", []string{"2024-05-01 08:50", "2024-05-02 08:10"}},
+		{"0 0 1,15 * *", "2024-05-02 00:00", []string{"2024-05-15 00:00", "2024-06-01 00:00"}},
+		{"0 0 * FEB *", "2024-05-01 00:00", []string{"2025-02-01 00:00"}},
+		{"0 0 29 2 *", "2024-03-01 00:00", []string{"2028-02-29 00:00"}},
+		{"@weekly", "2024-05-01 12:00", []string{"2024-05-05 00:00", "2024-05-12 00:00"}},
+		{"@hourly", "2024-05-01 12:00", []string{"2024-05-01 13:00"}},
+		// 7 is Sunday, like 0
+		{"0 12 * * 7", "2024-05-01 00:00", []string{"2024-05-05 12:00"}},
+		// Both day fields restricted: the 13th or any Friday
+		{"0 0 13 * FRI", "2024-09-01 00:00", []string{"2024-09-06 00:00", "2024-09-13 00:00", "2024-09-20 00:00"}},
+		// A day field starting with * makes both match: every tenth day from
+		// the 1st that is a Friday
+		{"0 0 */10 * FRI", "2024-01-01 00:00", []string{"2024-03-01 00:00", "2024-05-31 00:00"}},
+		{"0 0 * * FRI", "2024-09-12 00:00", []string{"2024-09-13 00:00"}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		tm := utc(tt.from)
+		for _, want := range tt.want {
+			tm = s.Next(tm)
+			if !tm.Equal(utc(want)) {
+				t.Errorf("%q from %s: got %s, want %s", tt.expr, tt.from, tm.Format("2006-01-02 15:04"), want)
+				break
+			}
+		}
+	}
+
+	never, _ := Parse("0 0 30 2 *")
+	if got := never.Next(utc("2024-01-01 00:00")); !got.IsZero() {
+		t.Errorf("Feb 30: got %s, want the zero time", got)
+	}
+}
+
+func TestNextEvery(t *testing.T) {
+	s, err := Parse("@every 90m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 5, 1, 10, 0, 30, 500, time.UTC)
+	if got, want := s.Next(from), time.Date(2024, 5, 1, 11, 30, 30, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(month, day, hour, min int, zone string) string {
+		return time.Date(2024, time.Month(month), day, hour, min, 0, 0, ny).Format("01-02 15:04 ") + zone
+	}
+	tests := []struct {
+		name, expr string
+		from       time.Time
+		want       []string
+	}{
+		// Clocks go from 02:00 EST to 03:00 EDT on March 10
+		{"skipped time fires after the change", "30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, ny),
+			[]string{at(3, 10, 3, 0, "EDT"), at(3, 11, 2, 30, "EDT")}},
+		{"hourly follows elapsed time", "0 * * * *", time.Date(2024, 3, 10, 1, 10, 0, 0, ny),
+			[]string{at(3, 10, 3, 0, "EDT"), at(3, 10, 4, 0, "EDT")}},
+		// Clocks go from 02:00 EDT back to 01:00 EST on November 3
+		{"repeated time fires once", "30 1 * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, ny),
+			[]string{"11-03 01:30 EDT", "11-04 01:30 EST"}},
+		{"every 30 minutes follows elapsed time", "*/30 * * * *", time.Date(2024, 11, 3, 1, 10, 0, 0, ny),
+			[]string{"11-03 01:30 EDT", "11-03 01:00 EST", "11-03 01:30 EST", "11-03 02:00 EST"}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm := tt.from
+		for _, want := range tt.want {
+			tm = s.Next(tm)
+			if got := tm.Format("01-02 15:04 MST"); got != want {
+				t.Errorf("%s: got %s, want %s", tt.name, got, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Admission classes, highest priority first. When an execution slot frees
+// up it goes to the oldest waiting query of the highest class that is still
+// under its own limit.
+const (
+	ClassInteractive = "interactive"
+	ClassChat        = "chat"
+	ClassStats       = "stats"
+	ClassBackground  = "background"
+)
+
+var classOrder = []string{ClassInteractive, ClassChat, ClassStats, ClassBackground}
+
+var classLimitEnv = map[string]string{
+	ClassInteractive: "QUERY_LIMIT_INTERACTIVE",
+	ClassChat:        "QUERY_LIMIT_CHAT",
+	ClassStats:       "QUERY_LIMIT_STATS",
+	ClassBackground:  "QUERY_LIMIT_BACKGROUND",
+}
+
+// ClassFor returns the admission class for a query source.
+func ClassFor(source string) string {
+	switch source {
+	case "editor", "saved", "browse", "pgwire", "flight", "metrics":
+		return ClassInteractive
+	case "chat":
+		return ClassChat
+	case "stats":
+		return ClassStats
+	}
+	return ClassBackground
+}
+
+type waiter struct {
+	id    string
+	class string
+	ready chan struct{}
+}
+
+var (
+	admitMu     sync.Mutex
+	maxRunning  = 4
+	classLimits = map[string]int{}
+	active      = map[string]int{}
+	activeTotal int
+	waiters     []*waiter // in admission order
+)
+
+// loadAdmission reads the concurrency limits from the environment:
+//
+//	MAX_CONCURRENT_QUERIES    total DuckDB executions at once (default 4)
+//	QUERY_LIMIT_INTERACTIVE   per-class limits; interactive and chat default
+//	QUERY_LIMIT_CHAT          to the total, stats to half of it and
+//	QUERY_LIMIT_STATS         background jobs to 1, so slow work can't take
+//	QUERY_LIMIT_BACKGROUND    every slot from the editor
+func loadAdmission() error {
+	if v := os.Getenv("MAX_CONCURRENT_QUERIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MAX_CONCURRENT_QUERIES %q", v)
+		}
+		maxRunning = n
+	}
+	defaults := map[string]int{
+		ClassInteractive: maxRunning,
+		ClassChat:        maxRunning,
+		ClassStats:       max(1, maxRunning/2),
+		ClassBackground:  1,
+	}
+	for _, class := range classOrder {
+		classLimits[class] = defaults[class]
+		env := classLimitEnv[class]
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid %s %q", env, v)
+			}
+			classLimits[class] = min(n, maxRunning)
+		}
+	}
+	return nil
+}
+
+// Admit waits until a query of the given class may execute and returns a
+// func that gives the slot back. It fails with ctx's cause if ctx ends while
+// the query is still queued. id identifies the query in queue listings and
+// may be empty.
+func Admit(ctx context.Context, id, class string) (func(), error) {
+	w := &waiter{id: id, class: class, ready: make(chan struct{})}
+
+	admitMu.Lock()
+	// Keep classes in priority order, first come first served within one
+	i := len(waiters)
+	for i > 0 && priority(waiters[i-1].class) > priority(class) {
+		i--
+	}
+	waiters = append(waiters, nil)
+	copy(waiters[i+1:], waiters[i:])
+	waiters[i] = w
+	dispatch()
+	admitMu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			admitMu.Lock()
+			active[class]--
+			activeTotal--
+			dispatch()
+			admitMu.Unlock()
+		})
+	}
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	admitMu.Lock()
+	for i, other := range waiters {
+		if other == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			admitMu.Unlock()
+			return nil, context.Cause(ctx)
+		}
+	}
+	admitMu.Unlock()
+	// Admitted just as ctx ended
+	release()
+	return nil, context.Cause(ctx)
+}
+
+func priority(class string) int {
+	for i, c := range classOrder {
+		if c == class {
+			return i
+		}
+	}
+	return len(classOrder)
+}
+
+// dispatch admits waiting queries while there is room; admitMu must be held.
+func dispatch() {
+	for i := 0; i < len(waiters) && activeTotal < maxRunning; {
+		w := waiters[i]
+		if active[w.class] >= classLimits[w.class] {
+			i++
+			continue
+		}
+		active[w.class]++
+		activeTotal++
+		waiters = append(waiters[:i], waiters[i+1:]...)
+		close(w.ready)
+	}
+}
+
+// queuePositions maps queued query IDs to their 1-based place in line.
+func queuePositions() map[string]int {
+	admitMu.Lock()
+	defer admitMu.Unlock()
+	positions := make(map[string]int, len(waiters))
+	for i, w := range waiters {
+		if w.id != "" {
+			positions[w.id] = i + 1
+		}
+	}
+	return positions
+}
+
+// AdmissionClass reports the limit and current load of one class.
+type AdmissionClass struct {
+	Class   string `json:"class"`
+	Limit   int    `json:"limit"`
+	Running int    `json:"running"`
+	Queued  int    `json:"queued"`
+}
+
+// AdmissionStats is a snapshot of the admission queue.
+type AdmissionStats struct {
+	MaxConcurrent int              `json:"maxConcurrent"`
+	Running       int              `json:"running"`
+	Queued        int              `json:"queued"`
+	Classes       []AdmissionClass `json:"classes"`
+}
+
+// Admission returns the current limits and load.
+func Admission() AdmissionStats {
+	admitMu.Lock()
+	defer admitMu.Unlock()
+	stats := AdmissionStats{MaxConcurrent: maxRunning, Running: activeTotal, Queued: len(waiters)}
+	for _, class := range classOrder {
+		c := AdmissionClass{Class: class, Limit: classLimits[class], Running: active[class]}
+		for _, w := range waiters {
+			if w.class == class {
+				c.Queued++
+			}
+		}
+		stats.Classes = append(stats.Classes, c)
+	}
+	return stats
+}
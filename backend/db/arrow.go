@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// arrowTables numbers the temporary tables QueryArrow stages results in.
+var arrowTables atomic.Int64
+
+// QueryArrow runs a query and returns its result as Arrow record batches,
+// without converting individual values. query must be a single statement
+// that produces rows; arguments bind by position only.
+//
+// DuckDB's Arrow interface can't be interrupted and collects the whole
+// result before returning, so the query first runs through the driver's
+// cancellable path into a temporary table, which is then read as Arrow. A
+// canceled ctx stops the query; the result is held in memory in full, so
+// callers should limit its size.
+func QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, error) {
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	table := fmt.Sprintf("_arrow_%d", arrowTables.Add(1))
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE "+table+" AS "+query, args...); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+table)
+
+	var reader array.RecordReader
+	err = conn.Raw(func(driverConn interface{}) error {
+		ar, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		reader, err = ar.QueryContext(ctx, "SELECT * FROM "+table)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// paramTypeNames names the parameter types ParamTypes reports. Types not
+// listed come back as "".
+var paramTypeNames = map[duckdb.Type]string{
+	duckdb.TYPE_BOOLEAN:      "BOOLEAN",
+	duckdb.TYPE_TINYINT:      "BIGINT",
+	duckdb.TYPE_SMALLINT:     "BIGINT",
+	duckdb.TYPE_INTEGER:      "BIGINT",
+	duckdb.TYPE_BIGINT:       "BIGINT",
+	duckdb.TYPE_UTINYINT:     "BIGINT",
+	duckdb.TYPE_USMALLINT:    "BIGINT",
+	duckdb.TYPE_UINTEGER:     "BIGINT",
+	duckdb.TYPE_HUGEINT:      "HUGEINT",
+	duckdb.TYPE_UBIGINT:      "HUGEINT",
+	duckdb.TYPE_FLOAT:        "DOUBLE",
+	duckdb.TYPE_DOUBLE:       "DOUBLE",
+	duckdb.TYPE_DECIMAL:      "DECIMAL",
+	duckdb.TYPE_DATE:         "DATE",
+	duckdb.TYPE_TIME:         "TIME",
+	duckdb.TYPE_TIMESTAMP:    "TIMESTAMP",
+	duckdb.TYPE_TIMESTAMP_S:  "TIMESTAMP",
+	duckdb.TYPE_TIMESTAMP_MS: "TIMESTAMP",
+	duckdb.TYPE_TIMESTAMP_NS: "TIMESTAMP",
+	duckdb.TYPE_TIMESTAMP_TZ: "TIMESTAMPTZ",
+	duckdb.TYPE_UUID:         "UUID",
+	duckdb.TYPE_VARCHAR:      "VARCHAR",
+}
+
+// ParamTypes prepares a single statement and returns the type DuckDB expects
+// for each of its positional parameters, "" where it can't tell.
+func ParamTypes(ctx context.Context, query string) ([]string, error) {
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var types []string
+	err = conn.Raw(func(driverConn interface{}) error {
+		stmt, err := driverConn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		ds := stmt.(*duckdb.Stmt)
+		types = make([]string, ds.NumInput())
+		for i := range types {
+			t, err := ds.ParamType(i + 1)
+			if err != nil {
+				return err
+			}
+			types[i] = paramTypeNames[t]
+		}
+		return nil
+	})
+	return types, err
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueryArrow(t *testing.T) {
+	openTestDB(t)
+	reader, err := QueryArrow(context.Background(), "SELECT range AS i, $1 AS s FROM range(5000)", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	var rows int64
+	for reader.Next() {
+		rows += reader.Record().NumRows()
+	}
+	if rows != 5000 || reader.Schema().NumFields() != 2 {
+		t.Errorf("got %d rows of %s", rows, reader.Schema())
+	}
+}
+
+func TestQueryArrowCanceled(t *testing.T) {
+	openTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := QueryArrow(ctx, "SELECT count(*) FROM range(100000000000)"); err == nil {
+		t.Fatal("want an error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("canceling took %s", d)
+	}
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"artemisgo/sqltext"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogEntry describes a table or view the application manages: the
+// uploaded table, or one derived from a query.
+type CatalogEntry struct {
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"` // upload, table or view
+	SQL            string    `json:"sql,omitempty"`
+	Parents        []string  `json:"parents"`
+	Created        time.Time `json:"created"`
+	DatasetVersion int64     `json:"datasetVersion,omitempty"`
+	Schedule       string    `json:"schedule,omitempty"` // ID of the schedule that created it
+}
+
+// Column is a column of a catalog table as DuckDB describes it.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var (
+	ErrTableExists   = errors.New("a table or view with that name already exists")
+	ErrTableNotFound = errors.New("table not found")
+)
+
+var tableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var (
+	catalogMu sync.Mutex
+	catalog   = map[string]CatalogEntry{} // keyed by lower-case name
+)
+
+func resetCatalog() {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog = map[string]CatalogEntry{}
+}
+
+func registerTable(e CatalogEntry) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog[strings.ToLower(e.Name)] = e
+}
+
+// Tables lists the catalog, the uploaded table first and the rest by name.
+func Tables() []CatalogEntry {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	list := make([]CatalogEntry, 0, len(catalog))
+	for _, e := range catalog {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Kind == "upload") != (list[j].Kind == "upload") {
+			return list[i].Kind == "upload"
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// LookupTable finds a catalog entry by name, ignoring case as DuckDB does.
+func LookupTable(name string) (CatalogEntry, bool) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	e, ok := catalog[strings.ToLower(name)]
+	return e, ok
+}
+
+// DescribeTable returns the columns of a table or view.
+func DescribeTable(ctx context.Context, name string) ([]Column, error) {
+	rows, err := DB.QueryContext(ctx, "DESCRIBE "+QuoteIdent(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var col Column
+		var isNull, key, defaultVal, extra sql.NullString
+		if err := rows.Scan(&col.Name, &col.Type, &isNull, &key, &defaultVal, &extra); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// CreateDerived saves the result of a SELECT as a new table, or defines it
+// as a view, and records it in the catalog. The caller is responsible for
+// checking the SELECT against a policy. With replace, an existing derived
+// object of the same name is dropped first; the uploaded table can never be
+// replaced.
+func CreateDerived(ctx context.Context, name, kind, selectSQL string, replace bool) (CatalogEntry, error) {
+	if !tableNameRe.MatchString(name) {
+		return CatalogEntry{}, fmt.Errorf("invalid table name %q: use letters, digits and underscores", name)
+	}
+	if kind != "table" && kind != "view" {
+		return CatalogEntry{}, fmt.Errorf("kind must be table or view")
+	}
+	parents, err := ReferencedTables(ctx, selectSQL)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+
+	existing, exists := LookupTable(name)
+	switch {
+	case exists && existing.Kind == "upload":
+		return CatalogEntry{}, fmt.Errorf("%s is the uploaded table and can't be replaced", existing.Name)
+	case exists && !replace:
+		return CatalogEntry{}, ErrTableExists
+	}
+	for _, p := range parents {
+		if strings.EqualFold(p, name) {
+			return CatalogEntry{}, fmt.Errorf("%s can't be derived from itself", name)
+		}
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+	defer tx.Rollback()
+	if exists {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP %s %s", strings.ToUpper(existing.Kind), QuoteIdent(existing.Name))); err != nil {
+			return CatalogEntry{}, err
+		}
+	}
+	stmt := fmt.Sprintf("CREATE %s %s AS %s", strings.ToUpper(kind), QuoteIdent(name), strings.TrimRight(strings.TrimSpace(selectSQL), ";"))
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CatalogEntry{}, err
+	}
+
+	e := CatalogEntry{
+		Name:           name,
+		Kind:           kind,
+		SQL:            selectSQL,
+		Parents:        parents,
+		Created:        time.Now(),
+		DatasetVersion: BumpTableVersion(name),
+	}
+	registerTable(e)
+	return e, nil
+}
+
+// DropDerived drops a derived table or view. Objects that other catalog
+// entries are built on must be dropped after their dependents.
+func DropDerived(ctx context.Context, name string) error {
+	e, ok := LookupTable(name)
+	if !ok {
+		return ErrTableNotFound
+	}
+	if e.Kind == "upload" {
+		return fmt.Errorf("%s is the uploaded table and can't be dropped", e.Name)
+	}
+	for _, other := range Tables() {
+		for _, p := range other.Parents {
+			if strings.EqualFold(p, e.Name) {
+				return fmt.Errorf("%s is used by %s %s", e.Name, other.Kind, other.Name)
+			}
+		}
+	}
+
+	if _, err := DB.ExecContext(ctx, fmt.Sprintf("DROP %s IF EXISTS %s", strings.ToUpper(e.Kind), QuoteIdent(e.Name))); err != nil {
+		return err
+	}
+	catalogMu.Lock()
+	delete(catalog, strings.ToLower(e.Name))
+	catalogMu.Unlock()
+	BumpTableVersion(e.Name)
+	return nil
+}
+
+// ReferencedTables lists the catalog tables a SELECT reads from. CTE names
+// and anything outside the catalog are ignored.
+func ReferencedTables(ctx context.Context, selectSQL string) ([]string, error) {
+	parsed, err := serializeSQL(ctx, selectSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	parents := []string{}
+	add := func(name string) {
+		e, ok := LookupTable(name)
+		if ok && !seen[e.Name] {
+			seen[e.Name] = true
+			parents = append(parents, e.Name)
+		}
+	}
+
+	if parsed.Error {
+		if parsed.ErrorType != "not implemented" {
+			return nil, fmt.Errorf("%s", parsed.ErrorMessage)
+		}
+		// PIVOT and friends can't be serialized; fall back to the tokens
+		for _, tok := range sqltext.Code(selectSQL) {
+			if tok.Kind == sqltext.Ident || tok.Kind == sqltext.QuotedIdent {
+				add(tok.Name())
+			}
+		}
+	} else {
+		var visit func(node interface{})
+		visit = func(node interface{}) {
+			switch n := node.(type) {
+			case map[string]interface{}:
+				if n["type"] == "BASE_TABLE" {
+					if name, ok := n["table_name"].(string); ok {
+						add(name)
+					}
+				}
+				for _, v := range n {
+					visit(v)
+				}
+			case []interface{}:
+				for _, v := range n {
+					visit(v)
+				}
+			}
+		}
+		visit(parsed.Statements)
+	}
+	sort.Strings(parents)
+	return parents, nil
+}
+
+// ValidTableName reports whether name can be used for a derived table.
+func ValidTableName(name string) bool {
+	return tableNameRe.MatchString(name)
+}
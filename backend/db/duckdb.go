@@ -1,10 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/marcboeker/go-duckdb"
@@ -14,12 +18,185 @@ var DB *sql.DB
 
 const TableName = "tablename"
 
+// version counts changes to the loaded data. Anything derived from query
+// results (history, caches, precomputed stats) records it to detect staleness.
+var version atomic.Int64
+
+var (
+	listenersMu sync.Mutex
+	listeners   []func(int64)
+)
+
+// Per-table changes, as the dataset versions they happened at. Writes that
+// can't be traced to a table, such as arbitrary SQL, change them all.
+var (
+	changesMu      sync.Mutex
+	allChangedAt   int64
+	tableChangedAt = map[string]int64{} // by lower-case name
+)
+
+// DatasetVersion returns the current data version.
+func DatasetVersion() int64 {
+	return version.Load()
+}
+
+// BumpVersion marks the data as changed, notifies OnVersionChange listeners
+// and returns the new version. Every table counts as changed.
+func BumpVersion() int64 {
+	return bump("")
+}
+
+// BumpTableVersion is BumpVersion for a change to one table; the versions
+// of other tables, except views built on it, stay the same.
+func BumpTableVersion(table string) int64 {
+	return bump(table)
+}
+
+func bump(table string) int64 {
+	changesMu.Lock()
+	v := version.Add(1)
+	if table == "" {
+		allChangedAt = v
+	} else {
+		tableChangedAt[strings.ToLower(table)] = v
+	}
+	changesMu.Unlock()
+
+	listenersMu.Lock()
+	fns := append([]func(int64){}, listeners...)
+	listenersMu.Unlock()
+	for _, fn := range fns {
+		fn(v)
+	}
+	return v
+}
+
+// TableVersion returns the dataset version at which a table's data last
+// changed. A view changes with the tables it reads from.
+func TableVersion(table string) int64 {
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	return tableVersion(table, map[string]bool{})
+}
+
+func tableVersion(table string, seen map[string]bool) int64 {
+	key := strings.ToLower(table)
+	if seen[key] {
+		return 0
+	}
+	seen[key] = true
+	v := max(allChangedAt, tableChangedAt[key])
+	if e, ok := LookupTable(table); ok && e.Kind == "view" {
+		for _, p := range e.Parents {
+			v = max(v, tableVersion(p, seen))
+		}
+	}
+	return v
+}
+
+// QueryVersion returns the version of the data a SELECT reads: the newest
+// TableVersion of the tables it references. A query whose reads can't be
+// told from its syntax tree gets DatasetVersion: one that isn't a SELECT,
+// calls a table function or a macro, or reads a view outside the catalog.
+func QueryVersion(ctx context.Context, sqlText string) int64 {
+	current := DatasetVersion()
+	parsed, err := serializeSQL(ctx, sqlText)
+	if err != nil || parsed.Error {
+		return current
+	}
+	tables, funcs := map[string]bool{}, map[string]bool{}
+	opaque := false
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if name, ok := n["function_name"].(string); ok {
+				funcs[strings.ToLower(name)] = true
+			}
+			switch n["type"] {
+			case "BASE_TABLE":
+				name, _ := n["table_name"].(string)
+				tables[name] = true
+			case "TABLE_FUNCTION":
+				opaque = true
+			}
+			for _, v := range n {
+				walk(v)
+			}
+		case []interface{}:
+			for _, v := range n {
+				walk(v)
+			}
+		}
+	}
+	walk(parsed.Statements)
+	if opaque {
+		return current
+	}
+
+	for name := range funcs {
+		var macros int
+		err := DB.QueryRowContext(ctx, `SELECT count(*) FROM duckdb_functions()
+			WHERE function_type IN ('macro', 'table_macro') AND NOT internal AND lower(function_name) = ?`, name).Scan(&macros)
+		if err != nil || macros > 0 {
+			return current
+		}
+	}
+	// Catalog views know their parents; other views could read anything
+	for name := range tables {
+		if _, ok := LookupTable(name); ok {
+			continue
+		}
+		var views int
+		err := DB.QueryRowContext(ctx, `SELECT count(*) FROM duckdb_views()
+			WHERE NOT internal AND lower(view_name) = lower(?)`, name).Scan(&views)
+		if err != nil || views > 0 {
+			return current
+		}
+	}
+
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	v := allChangedAt
+	for name := range tables {
+		v = max(v, tableVersion(name, map[string]bool{}))
+	}
+	return v
+}
+
+// OnVersionChange registers fn to run whenever the data changes.
+func OnVersionChange(fn func(version int64)) {
+	listenersMu.Lock()
+	listeners = append(listeners, fn)
+	listenersMu.Unlock()
+}
+
+// QueryTimeout is the longest a single query may run. Override with the
+// QUERY_TIMEOUT environment variable (a Go duration such as "90s").
+var QueryTimeout = 5 * time.Minute
+
 func Init() error {
+	if v := os.Getenv("QUERY_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid QUERY_TIMEOUT %q", v)
+		}
+		QueryTimeout = d
+	}
+	if err := loadPolicies(); err != nil {
+		return err
+	}
+	if err := loadAdmission(); err != nil {
+		return err
+	}
+
 	var err error
 	DB, err = sql.Open("duckdb", "")
 	if err != nil {
 		return fmt.Errorf("failed to open duckdb: %w", err)
 	}
+	// The database is in memory, so it starts out empty
+	resetCatalog()
 	return DB.Ping()
 }
 
@@ -29,6 +206,7 @@ func LoadCSV(csvPath string) (int, []string, []string, error) {
 	start := time.Now()
 
 	_, _ = DB.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", TableName))
+	defer BumpTableVersion(TableName)
 
 	// DuckDB reads the CSV natively — handles parsing, type inference, everything
 	createSQL := fmt.Sprintf(
@@ -40,6 +218,7 @@ func LoadCSV(csvPath string) (int, []string, []string, error) {
 	}
 
 	log.Printf("  CSV loaded in %.1fs", time.Since(start).Seconds())
+	registerTable(CatalogEntry{Name: TableName, Kind: "upload", Parents: []string{}, Created: time.Now()})
 
 	// Get row count
 	var rowCount int
@@ -63,17 +242,37 @@ func LoadCSV(csvPath string) (int, []string, []string, error) {
 			return 0, nil, nil, fmt.Errorf("failed to scan column info: %w", err)
 		}
 		columns = append(columns, name)
-		colTypes = append(colTypes, mapDuckDBType(colType))
+		colTypes = append(colTypes, SimpleType(colType))
 	}
 
 	log.Printf("  done: %d rows, %d columns in %.1fs", rowCount, len(columns), time.Since(start).Seconds())
 	return rowCount, columns, colTypes, nil
 }
 
-// mapDuckDBType maps DuckDB types to simple types for the frontend
-func mapDuckDBType(t string) string {
+// QuoteIdent quotes a column or table name for use in SQL.
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// SimpleType maps a DuckDB type to the class the frontend and the stats
+// profiler work with: INTEGER, REAL, DATE, TIMESTAMP, TIME, INTERVAL, BOOLEAN
+// or TEXT.
+func SimpleType(t string) string {
 	upper := strings.ToUpper(t)
 	switch {
+	case strings.HasSuffix(upper, "]"), strings.HasPrefix(upper, "STRUCT"),
+		strings.HasPrefix(upper, "MAP"), strings.HasPrefix(upper, "UNION"):
+		return "TEXT" // nested types
+	case upper == "BOOLEAN":
+		return "BOOLEAN"
+	case upper == "DATE":
+		return "DATE"
+	case strings.HasPrefix(upper, "TIMESTAMP"):
+		return "TIMESTAMP"
+	case strings.HasPrefix(upper, "TIME"):
+		return "TIME"
+	case upper == "INTERVAL":
+		return "INTERVAL"
 	case strings.Contains(upper, "INT"):
 		return "INTEGER"
 	case strings.Contains(upper, "FLOAT"), strings.Contains(upper, "DOUBLE"),
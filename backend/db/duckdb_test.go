@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTableVersion(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	for _, d := range []struct{ name, kind, sql string }{
+		{"base", "table", "SELECT range AS x FROM range(3)"},
+		{"other", "table", "SELECT 1 AS y"},
+		{"copy", "table", "SELECT * FROM base"},
+		{"v", "view", "SELECT * FROM base"},
+		{"vv", "view", "SELECT * FROM v"},
+	} {
+		if _, err := CreateDerived(ctx, d.name, d.kind, d.sql, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := map[string]int64{}
+	for _, name := range []string{"base", "other", "copy", "v", "vv"} {
+		before[name] = TableVersion(name)
+	}
+
+	v := BumpTableVersion("BASE")
+	for name, changed := range map[string]bool{"base": true, "other": false, "copy": false, "v": true, "vv": true} {
+		switch got := TableVersion(name); {
+		case changed && got != v:
+			t.Errorf("%s: version %d, want %d", name, got, v)
+		case !changed && got != before[name]:
+			t.Errorf("%s: version changed to %d", name, got)
+		}
+	}
+
+	v = BumpVersion()
+	for _, name := range []string{"base", "other", "copy", "v", "vv"} {
+		if got := TableVersion(name); got != v {
+			t.Errorf("%s: version %d after a change to every table, want %d", name, got, v)
+		}
+	}
+}
+
+func TestQueryVersion(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	for _, d := range []struct{ name, kind, sql string }{
+		{"a", "table", "SELECT range AS x FROM range(3)"},
+		{"b", "table", "SELECT 1 AS y"},
+		{"va", "view", "SELECT * FROM a"},
+	} {
+		if _, err := CreateDerived(ctx, d.name, d.kind, d.sql, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := DB.Exec(`CREATE VIEW loose AS SELECT * FROM b;
+		CREATE MACRO b_rows() AS TABLE SELECT * FROM b`); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string]string{
+		"a":        "SELECT * FROM a",
+		"view":     "SELECT * FROM va WHERE x > (SELECT count(*) FROM a)",
+		"cte":      "WITH c AS (SELECT * FROM a) SELECT * FROM c",
+		"loose":    "SELECT * FROM loose",
+		"macro":    "SELECT * FROM b_rows()",
+		"function": "SELECT * FROM range(3)",
+		"pivot":    "PIVOT a ON x",
+	}
+	before := map[string]int64{}
+	for name, q := range queries {
+		before[name] = QueryVersion(ctx, q)
+	}
+
+	// Changing b leaves queries that only read a alone
+	v := BumpTableVersion("b")
+	for name, q := range queries {
+		got := QueryVersion(ctx, q)
+		switch name {
+		case "a", "view", "cte":
+			if got != before[name] {
+				t.Errorf("%s: version changed to %d after a change to b", name, got)
+			}
+		default:
+			if got != v {
+				t.Errorf("%s: version %d, want the dataset version %d", name, got, v)
+			}
+		}
+	}
+
+	v = BumpTableVersion("a")
+	for _, name := range []string{"a", "view", "cte"} {
+		if got := QueryVersion(ctx, queries[name]); got != v {
+			t.Errorf("%s: version %d after a change to a, want %d", name, got, v)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package db
+
+import (
+	"artemisgo/sqltext"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy decides which statements a query source may run. Statements are
+// parsed with DuckDB's own parser (json_serialize_sql) so the check sees the
+// real syntax tree rather than matching substrings.
+//
+// Table functions are how a query reaches files, the network or dynamic SQL,
+// so they are allowed by name rather than denied: one DuckDB adds in a new
+// version or extension is rejected until it is listed. Scalar functions are
+// checked against DeniedFunctions.
+type Policy struct {
+	Name                  string
+	AllowWrites           bool
+	DeniedFunctions       map[string]bool
+	AllowedTableFunctions map[string]bool
+	// AllowTemp lets CREATE TEMP TABLE and CREATE TEMP VIEW through, for
+	// scripts whose temp objects go away with their connection.
+	AllowTemp bool
+}
+
+// PolicyError is returned when a statement is rejected by a Policy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "query not allowed: " + e.Reason
+}
+
+// defaultDeniedFunctions reach outside the in-memory database: the server
+// filesystem, the network, environment variables, or dynamic SQL that would
+// bypass the check.
+var defaultDeniedFunctions = []string{
+	"read_csv", "read_csv_auto", "sniff_csv",
+	"read_parquet", "parquet_scan", "parquet_metadata", "parquet_schema",
+	"parquet_file_metadata", "parquet_kv_metadata",
+	"read_json", "read_json_auto", "read_json_objects", "read_json_objects_auto",
+	"read_ndjson", "read_ndjson_auto", "read_ndjson_objects",
+	"read_text", "read_blob", "glob",
+	"sqlite_scan", "sqlite_attach", "postgres_scan", "postgres_attach", "mysql_scan",
+	"iceberg_scan", "iceberg_metadata", "delta_scan", "st_read",
+	"getenv", "query", "query_table", "json_execute_serialized_sql", "json_deserialize_sql",
+	"duckdb_secrets", "which_secret", "load_aws_credentials",
+}
+
+// defaultTableFunctions are the table functions a query may call: generators
+// and catalog listings that only read the in-memory database. User table
+// macros are allowed too, since their bodies pass the policy when created.
+var defaultTableFunctions = []string{
+	"range", "generate_series", "unnest", "repeat", "repeat_row", "summary",
+	"histogram_values",
+	"duckdb_columns", "duckdb_constraints", "duckdb_databases", "duckdb_dependencies",
+	"duckdb_functions", "duckdb_indexes", "duckdb_keywords", "duckdb_schemas",
+	"duckdb_sequences", "duckdb_tables", "duckdb_types", "duckdb_views",
+	"pragma_table_info", "pragma_show", "pragma_version",
+}
+
+// writeStatements may run under a policy that allows writes. Anything not
+// listed here (ATTACH, COPY, INSTALL, LOAD, PRAGMA, SET, ...) is always denied.
+var writeStatements = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "TRUNCATE": true,
+	"CREATE": true, "DROP": true, "ALTER": true, "WITH": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "END": true, "ROLLBACK": true, "ABORT": true,
+}
+
+// readStatements are read-only but cannot be serialized to a syntax tree, so
+// they are checked by their parts (see checkParts).
+var readStatements = map[string]bool{
+	"PIVOT": true, "UNPIVOT": true,
+}
+
+var (
+	policies       = map[string]*Policy{}
+	readOnlyPolicy = &Policy{Name: "readonly"}
+)
+
+// loadPolicies builds the per-source policies from the environment:
+//
+//	QUERY_POLICY       readonly (default) or readwrite, for the SQL editor
+//	CHAT_QUERY_POLICY  readonly (default) or readwrite, for chat auto-execute
+//	SQL_DENY_FUNCTIONS  extra functions to deny, comma-separated
+//	SQL_ALLOW_FUNCTIONS functions to remove from the default deny list, or
+//	                    table functions to allow
+func loadPolicies() error {
+	denied := map[string]bool{}
+	for _, f := range defaultDeniedFunctions {
+		denied[f] = true
+	}
+	tableFuncs := map[string]bool{}
+	for _, f := range defaultTableFunctions {
+		tableFuncs[f] = true
+	}
+	for _, f := range splitList(os.Getenv("SQL_DENY_FUNCTIONS")) {
+		denied[f] = true
+		delete(tableFuncs, f)
+	}
+	for _, f := range splitList(os.Getenv("SQL_ALLOW_FUNCTIONS")) {
+		delete(denied, f)
+		tableFuncs[f] = true
+	}
+	readOnlyPolicy.DeniedFunctions = denied
+	readOnlyPolicy.AllowedTableFunctions = tableFuncs
+
+	for source, env := range map[string]string{"editor": "QUERY_POLICY", "chat": "CHAT_QUERY_POLICY"} {
+		p := &Policy{Name: "readonly", DeniedFunctions: denied, AllowedTableFunctions: tableFuncs}
+		switch mode := strings.ToLower(os.Getenv(env)); mode {
+		case "", "readonly":
+		case "readwrite":
+			p.Name = mode
+			p.AllowWrites = true
+		default:
+			return fmt.Errorf("invalid %s %q (want readonly or readwrite)", env, mode)
+		}
+		policies[source] = p
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.ToLower(strings.TrimSpace(part)); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// PolicyFor returns the policy for a query source. Sources without their own
+// configuration are read-only.
+func PolicyFor(source string) *Policy {
+	if p, ok := policies[source]; ok {
+		return p
+	}
+	return readOnlyPolicy
+}
+
+// ForScripts returns a copy of the policy that also allows creating temp
+// tables and views.
+func (p *Policy) ForScripts() *Policy {
+	scripts := *p
+	scripts.AllowTemp = true
+	return &scripts
+}
+
+// Check validates every statement in sqlText against the policy and reports
+// whether any of them may modify data.
+func (p *Policy) Check(ctx context.Context, sqlText string) (writes bool, err error) {
+	for _, stmt := range sqltext.Split(sqlText) {
+		w, err := p.checkStatement(ctx, stmt.SQL)
+		if err != nil {
+			return false, err
+		}
+		writes = writes || w
+	}
+	return writes, nil
+}
+
+func (p *Policy) checkStatement(ctx context.Context, stmt string) (bool, error) {
+	parsed, err := serializeSQL(ctx, stmt)
+	if err != nil {
+		return false, err
+	}
+	if !parsed.Error {
+		tableFuncs := map[string]bool{}
+		for _, s := range parsed.Statements {
+			if err := p.walk(s, tableFuncs); err != nil {
+				return false, err
+			}
+		}
+		return false, p.checkTableFunctions(ctx, tableFuncs)
+	}
+	if parsed.ErrorType != "not implemented" {
+		// A syntax error; report it exactly as DuckDB would
+		return false, fmt.Errorf("%s", parsed.ErrorMessage)
+	}
+
+	// Not a SELECT. Classify by leading keyword.
+	kw := sqltext.FirstKeyword(stmt)
+	switch {
+	case kw == "EXPLAIN":
+		return p.checkStatement(ctx, stripExplain(stmt))
+	case readStatements[kw], kw == "CREATE" && p.AllowTemp && isTempCreate(stmt):
+		return false, p.checkParts(ctx, stmt)
+	case p.AllowWrites && writeStatements[kw]:
+		return true, p.checkParts(ctx, stmt)
+	case kw == "":
+		return false, &PolicyError{Reason: "unrecognized statement"}
+	case writeStatements[kw]:
+		return false, &PolicyError{Reason: fmt.Sprintf("%s statements are not allowed in read-only mode", kw)}
+	default:
+		return false, &PolicyError{Reason: fmt.Sprintf("%s statements are not allowed", kw)}
+	}
+}
+
+// stripExplain removes a leading EXPLAIN [ANALYZE] or EXPLAIN (options) so
+// the explained query can be checked on its own.
+func stripExplain(stmt string) string {
+	code := sqltext.Code(stmt)
+	i := 1
+	if i < len(code) && code[i].IsKeyword("ANALYZE") {
+		i++
+	} else if i < len(code) && code[i].Text == "(" {
+		for depth := 0; i < len(code); i++ {
+			if code[i].Text == "(" {
+				depth++
+			} else if code[i].Text == ")" {
+				if depth--; depth == 0 {
+					i++
+					break
+				}
+			}
+		}
+	}
+	if i >= len(code) {
+		return ""
+	}
+	return stmt[code[i].Pos:]
+}
+
+// isTempCreate reports whether stmt is CREATE [OR REPLACE] TEMP[ORARY]
+// TABLE or VIEW. DuckDB itself rejects a temp object outside the temp catalog.
+func isTempCreate(stmt string) bool {
+	code := sqltext.Code(stmt)
+	i := 1
+	if i+1 < len(code) && code[i].IsKeyword("OR") && code[i+1].IsKeyword("REPLACE") {
+		i += 2
+	}
+	return i+1 < len(code) && (code[i].IsKeyword("TEMP") || code[i].IsKeyword("TEMPORARY")) &&
+		(code[i+1].IsKeyword("TABLE") || code[i+1].IsKeyword("VIEW"))
+}
+
+type serializedSQL struct {
+	Error        bool          `json:"error"`
+	ErrorType    string        `json:"error_type"`
+	ErrorMessage string        `json:"error_message"`
+	Statements   []interface{} `json:"statements"`
+}
+
+func serializeSQL(ctx context.Context, stmt string) (*serializedSQL, error) {
+	var raw string
+	if err := DB.QueryRowContext(ctx, "SELECT json_serialize_sql(?::VARCHAR)::VARCHAR", stmt).Scan(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse SQL: %w", err)
+	}
+	var parsed serializedSQL
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse SQL: %w", err)
+	}
+	return &parsed, nil
+}
+
+// walk visits every node of a serialized statement, rejecting denied function
+// calls and table references that are really file paths, which DuckDB would
+// otherwise resolve through a replacement scan. Table functions that aren't
+// on the allowlist are collected in tableFuncs, keyed by lower-case name.
+func (p *Policy) walk(node interface{}, tableFuncs map[string]bool) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if name, ok := n["function_name"].(string); ok && p.DeniedFunctions[strings.ToLower(name)] {
+			return &PolicyError{Reason: fmt.Sprintf("function %s is not allowed", name)}
+		}
+		switch n["type"] {
+		case "BASE_TABLE":
+			if name, _ := n["table_name"].(string); looksLikePath(name) {
+				return &PolicyError{Reason: fmt.Sprintf("reading files (%q) is not allowed", name)}
+			}
+		case "TABLE_FUNCTION":
+			fn, _ := n["function"].(map[string]interface{})
+			name, _ := fn["function_name"].(string)
+			if !p.AllowedTableFunctions[strings.ToLower(name)] {
+				tableFuncs[strings.ToLower(name)] = true
+			}
+		}
+		for _, v := range n {
+			if err := p.walk(v, tableFuncs); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			if err := p.walk(v, tableFuncs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTableFunctions rejects table functions off the allowlist unless they
+// are user table macros.
+func (p *Policy) checkTableFunctions(ctx context.Context, tableFuncs map[string]bool) error {
+	for name := range tableFuncs {
+		var macros int
+		err := DB.QueryRowContext(ctx, `SELECT count(*) FROM duckdb_functions()
+			WHERE function_type = 'table_macro' AND NOT internal AND lower(function_name) = ?`, name).Scan(&macros)
+		if err != nil {
+			return err
+		}
+		if macros == 0 {
+			return &PolicyError{Reason: fmt.Sprintf("table function %s is not allowed", name)}
+		}
+	}
+	return nil
+}
+
+func looksLikePath(name string) bool {
+	return strings.ContainsAny(name, `./\:`)
+}
+
+// queryKeywords start a query: a subquery, the source of an INSERT or the
+// body of CREATE ... AS.
+var queryKeywords = map[string]bool{
+	"SELECT": true, "WITH": true, "VALUES": true, "FROM": true, "PIVOT": true, "UNPIVOT": true,
+}
+
+// sqlPart is a range of statement tokens that becomes a checkable statement
+// when prefixed.
+type sqlPart struct {
+	from, to int
+	prefix   string
+}
+
+// checkParts checks a statement DuckDB cannot serialize through the parts it
+// can: the query an INSERT, CREATE ... AS or PIVOT reads from, a macro body
+// and every subquery get the full check. The tokens left are names, column
+// lists and expressions; calls there are checked by name, and a table they
+// read must be a plain table name. Statements whose parts can't be told
+// apart, such as ones with unbalanced parentheses, are rejected.
+func (p *Policy) checkParts(ctx context.Context, stmt string) error {
+	code := sqltext.Code(stmt)
+	if len(code) == 0 {
+		return &PolicyError{Reason: "unrecognized statement"}
+	}
+	depths := make([]int, len(code)) // a parenthesis has the depth outside it
+	depth := 0
+	for i, tok := range code {
+		if tok.Text == ")" {
+			if depth--; depth < 0 {
+				break
+			}
+		}
+		depths[i] = depth
+		if tok.Text == "(" {
+			depth++
+		}
+	}
+	if depth != 0 {
+		return &PolicyError{Reason: "unbalanced parentheses"}
+	}
+	// next returns the first token from i at depth d that is one of words
+	next := func(i, d int, words ...string) int {
+		for ; i < len(code); i++ {
+			if depths[i] == d {
+				for _, w := range words {
+					if code[i].IsKeyword(w) {
+						return i
+					}
+				}
+			}
+		}
+		return len(code)
+	}
+	closing := func(open int) int {
+		for i := open + 1; i < len(code); i++ {
+			if code[i].Text == ")" && depths[i] == depths[open] {
+				return i
+			}
+		}
+		return len(code)
+	}
+
+	// A leading WITH defines CTEs, which are subqueries, for the statement
+	// that follows them
+	m := 0
+	if code[0].IsKeyword("WITH") {
+		m = next(1, 0, "INSERT", "UPDATE", "DELETE", "PIVOT", "UNPIVOT")
+		if m == len(code) {
+			return &PolicyError{Reason: "unrecognized statement"}
+		}
+	}
+	kind := code[m].Upper()
+
+	var parts []sqlPart
+	switch kind {
+	case "INSERT":
+		from := m + 1
+		for ; from < len(code); from++ {
+			if depths[from] == 0 && queryKeywords[code[from].Upper()] && code[from].Kind == sqltext.Ident &&
+				!(code[from].IsKeyword("VALUES") && code[from-1].IsKeyword("DEFAULT")) {
+				break
+			}
+		}
+		to := next(from, 0, "RETURNING", "ON")
+		for to < len(code) && code[to].IsKeyword("ON") && !(to+1 < len(code) && code[to+1].IsKeyword("CONFLICT")) {
+			to = next(to+1, 0, "RETURNING", "ON")
+		}
+		if from < len(code) {
+			parts = append(parts, sqlPart{from, to, ""})
+		}
+	case "CREATE":
+		i := m + 1
+		for i < len(code) && (code[i].IsKeyword("OR") || code[i].IsKeyword("REPLACE") ||
+			code[i].IsKeyword("TEMP") || code[i].IsKeyword("TEMPORARY") || code[i].IsKeyword("PERSISTENT")) {
+			i++
+		}
+		as := next(i, 0, "AS")
+		if i >= len(code) || as+1 >= len(code) {
+			break
+		}
+		switch code[i].Upper() {
+		case "TABLE", "VIEW":
+			parts = append(parts, sqlPart{as + 1, len(code), ""})
+		case "MACRO", "FUNCTION":
+			if code[as+1].IsKeyword("TABLE") {
+				parts = append(parts, sqlPart{as + 2, len(code), ""})
+			} else {
+				parts = append(parts, sqlPart{as + 1, len(code), "SELECT "})
+			}
+		}
+	case "PIVOT", "UNPIVOT":
+		// The source is a table reference; a subquery is found below
+		to := next(m+1, 0, "ON", "USING", "GROUP", "ORDER", "LIMIT")
+		if to < m+3 || !(code[m+1].Text == "(" && closing(m+1) == to-1 && queryKeywords[code[m+2].Upper()]) {
+			parts = append(parts, sqlPart{m + 1, to, "SELECT * FROM "})
+		}
+	}
+
+	covered := make([]bool, len(code))
+	for _, part := range parts {
+		for i := part.from; i < part.to; i++ {
+			covered[i] = true
+		}
+	}
+	for i := 0; i+1 < len(code); i++ {
+		if !covered[i] && code[i].Text == "(" && code[i+1].Kind == sqltext.Ident && queryKeywords[code[i+1].Upper()] {
+			end := closing(i)
+			parts = append(parts, sqlPart{i + 1, end, ""})
+			for ; i < end; i++ {
+				covered[i] = true
+			}
+		}
+	}
+	for _, part := range parts {
+		if part.from >= part.to {
+			return &PolicyError{Reason: "unrecognized statement"}
+		}
+		end := len(stmt)
+		if part.to < len(code) {
+			end = code[part.to].Pos
+		}
+		if _, err := p.checkStatement(ctx, part.prefix+stmt[code[part.from].Pos:end]); err != nil {
+			return err
+		}
+	}
+
+	calls := map[string]bool{}
+	for i, tok := range code {
+		if covered[i] {
+			continue
+		}
+		if (tok.Kind == sqltext.Ident || tok.Kind == sqltext.QuotedIdent) && i+1 < len(code) && code[i+1].Text == "(" {
+			name := strings.ToLower(tok.Name())
+			if p.DeniedFunctions[name] {
+				return &PolicyError{Reason: fmt.Sprintf("function %s is not allowed", tok.Name())}
+			}
+			if !p.AllowedTableFunctions[name] {
+				calls[name] = true
+			}
+		}
+		if tok.IsKeyword("FROM") || tok.IsKeyword("JOIN") || (kind == "DELETE" && tok.IsKeyword("USING")) {
+			j := i + 1
+			for j < len(code) && code[j].Text == "(" {
+				j++
+			}
+			if j < len(code) && !covered[j] && (code[j].Kind == sqltext.String ||
+				code[j].Kind == sqltext.QuotedIdent && looksLikePath(code[j].Name())) {
+				return &PolicyError{Reason: fmt.Sprintf("reading files (%s) is not allowed", code[j].Text)}
+			}
+		}
+	}
+	return p.checkCalls(ctx, calls)
+}
+
+// checkCalls rejects the calls, by lower-case name, that are table functions
+// off the allowlist. The rest are scalar functions, checked by name already,
+// or not functions at all (a column list after a table name, VALUES (...)).
+func (p *Policy) checkCalls(ctx context.Context, calls map[string]bool) error {
+	for name := range calls {
+		var n int
+		err := DB.QueryRowContext(ctx, `SELECT count(*) FROM duckdb_functions()
+			WHERE function_type = 'table' AND lower(function_name) = ?`, name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return &PolicyError{Reason: fmt.Sprintf("table function %s is not allowed", name)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	if DB, err = sql.Open("duckdb", ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	resetCatalog()
+	if err := loadPolicies(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnlyPolicy(t *testing.T) {
+	openTestDB(t)
+	if _, err := DB.Exec(`CREATE TABLE t AS SELECT range AS x FROM range(3);
+		CREATE MACRO first_rows(n) AS TABLE SELECT * FROM t LIMIT n`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"SELECT * FROM t", true},
+		{"SELECT * FROM range(10)", true},
+		{"SELECT * FROM generate_series(1, 3), unnest([1, 2])", true},
+		{"SELECT * FROM duckdb_tables()", true},
+		{"SELECT * FROM first_rows(2)", true},
+		{"WITH c AS (SELECT 1) SELECT * FROM c", true},
+		{"SELECT * FROM read_csv('/etc/passwd')", false},
+		{"SELECT * FROM '/etc/passwd'", false},
+		{"SELECT getenv('HOME')", false},
+		{"SELECT * FROM query('SELECT 1')", false},
+		// Dynamic SQL hides the real query in a string literal
+		{`SELECT * FROM json_execute_serialized_sql(json_serialize_sql('SELECT * FROM read_csv(''/etc/passwd'', header=false, sep=''|'')'))`, false},
+		{`SELECT json_deserialize_sql(json_serialize_sql('SELECT 1'))`, false},
+		// Table functions off the allowlist are rejected by default
+		{"SELECT * FROM test_all_types()", false},
+		{"SELECT * FROM (SELECT * FROM read_text('/etc/hostname'))", false},
+		{"INSERT INTO t VALUES (4)", false},
+		{"COPY t TO '/tmp/t.csv'", false},
+		// PIVOT can't be serialized; its source and subqueries are checked
+		{"PIVOT t ON x", true},
+		{"PIVOT (SELECT * FROM t) ON x USING count(*)", true},
+		{"UNPIVOT t ON x INTO NAME k VALUE v", true},
+		{`PIVOT (SELECT * FROM "read_text"('/etc/hostname')) ON filename`, false},
+		{`PIVOT "read_text"('/etc/hostname') ON filename`, false},
+		{`PIVOT "/etc/passwd" ON column0`, false},
+		{"PIVOT t ON x IN (SELECT content FROM read_text('/etc/hostname'))", false},
+	}
+	p := PolicyFor("editor")
+	for _, tt := range tests {
+		_, err := p.Check(context.Background(), tt.sql)
+		var perr *PolicyError
+		switch {
+		case tt.allowed && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		case !tt.allowed && !errors.As(err, &perr):
+			t.Errorf("%s: want a policy error, got %v", tt.sql, err)
+		}
+	}
+}
+
+func TestScriptPolicyAllowsTempObjects(t *testing.T) {
+	openTestDB(t)
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"CREATE TEMP TABLE x AS SELECT * FROM range(3)", true},
+		{"CREATE OR REPLACE TEMPORARY VIEW v AS (SELECT 1 AS a)", true},
+		{"CREATE TEMP TABLE x (a INTEGER, b VARCHAR)", true},
+		{"CREATE TEMP TABLE x AS SELECT * FROM read_csv('/etc/passwd')", false},
+		{"CREATE TEMP TABLE x AS SELECT getenv('HOME')", false},
+		{"CREATE TABLE x AS SELECT 1", false},
+		{"CREATE TEMP SEQUENCE s", false},
+		{"INSERT INTO x VALUES (1)", false},
+	}
+	p := PolicyFor("editor").ForScripts()
+	for _, tt := range tests {
+		writes, err := p.Check(context.Background(), tt.sql)
+		switch {
+		case tt.allowed && (err != nil || writes):
+			t.Errorf("%s: got writes=%v, err=%v", tt.sql, writes, err)
+		case !tt.allowed && err == nil:
+			t.Errorf("%s: want an error", tt.sql)
+		}
+	}
+	if _, err := PolicyFor("editor").Check(context.Background(), "CREATE TEMP TABLE x AS SELECT 1"); err == nil {
+		t.Error("temp tables are only allowed in scripts")
+	}
+}
+
+func TestReadWritePolicy(t *testing.T) {
+	openTestDB(t)
+	if _, err := DB.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"INSERT INTO t VALUES (4)", true},
+		{"INSERT INTO t (x) SELECT range FROM range(3) RETURNING x", true},
+		{"WITH c AS (SELECT 1) INSERT INTO t SELECT * FROM c", true},
+		{"CREATE TABLE u AS SELECT * FROM t", true},
+		{"CREATE TABLE u (a INTEGER, b VARCHAR DEFAULT 'x')", true},
+		{"CREATE TYPE mood AS ENUM ('a', 'b')", true},
+		{"CREATE MACRO twice(a) AS a * 2", true},
+		{"CREATE MACRO evens() AS TABLE SELECT * FROM t WHERE x % 2 = 0", true},
+		{"UPDATE t SET x = x + 1 WHERE x IN (SELECT 1)", true},
+		{"DELETE FROM t WHERE x > 1", true},
+		{"ALTER TABLE t ADD COLUMN y INTEGER", true},
+		{"DROP TABLE t", true},
+		{`INSERT INTO t SELECT * FROM "read_csv"('/etc/passwd')`, false},
+		{`INSERT INTO t SELECT * FROM "/etc/passwd"`, false},
+		{"INSERT INTO t SELECT count(*) FROM test_all_types()", false},
+		{`INSERT INTO t SELECT count(*) FROM "test_all_types"()`, false},
+		{"WITH c AS (SELECT * FROM read_text('/etc/hostname')) INSERT INTO t SELECT 1 FROM c", false},
+		{`CREATE TABLE u AS SELECT * FROM "read_text"('/etc/hostname')`, false},
+		{`CREATE MACRO m() AS TABLE SELECT * FROM "read_text"('/etc/hostname')`, false},
+		{`CREATE MACRO m() AS TABLE PIVOT "read_text"('/etc/hostname') ON filename IN ('x')`, false},
+		{"CREATE MACRO m(a) AS getenv(a)", false},
+		{`UPDATE t SET x = 1 FROM "/etc/passwd"`, false},
+		{"UPDATE t SET x = (SELECT count(*) FROM read_blob('/etc/hostname'))", false},
+		{"DELETE FROM t WHERE x IN (FROM '/etc/passwd')", false},
+		{"DELETE FROM t USING '/etc/passwd'", false},
+		{"COPY t TO '/tmp/t.csv'", false},
+	}
+	p := *PolicyFor("editor")
+	p.AllowWrites = true
+	for _, tt := range tests {
+		_, err := p.Check(context.Background(), tt.sql)
+		var perr *PolicyError
+		switch {
+		case tt.allowed && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		case !tt.allowed && !errors.As(err, &perr):
+			t.Errorf("%s: want a policy error, got %v", tt.sql, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueryCanceled    = errors.New("query canceled")
+	ErrQueryTimeout     = errors.New("query timed out")
+	ErrClientGone       = errors.New("client disconnected, query canceled")
+	ErrDuplicateQueryID = errors.New("a query with this ID is already running")
+)
+
+// RunningQuery describes a query that is executing or waiting for an
+// execution slot.
+type RunningQuery struct {
+	ID            string    `json:"id"`
+	SQL           string    `json:"sql"`
+	Source        string    `json:"source"`
+	Class         string    `json:"class"`
+	State         string    `json:"state"` // queued or running
+	QueuePosition int       `json:"queuePosition,omitempty"`
+	Started       time.Time `json:"started"`
+	Deadline      time.Time `json:"deadline"`
+
+	cancel context.CancelCauseFunc
+}
+
+var (
+	runningMu sync.Mutex
+	running   = map[string]*RunningQuery{}
+)
+
+// Track registers a query under id and returns a context that is canceled when
+// the timeout elapses, CancelQuery is called, or the returned done func runs.
+// done must always be called once the query has finished.
+func Track(parent context.Context, id, sqlText, source string, timeout time.Duration) (context.Context, func(), error) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if _, ok := running[id]; ok {
+		return nil, nil, ErrDuplicateQueryID
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	ctx, cancelTimeout := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrQueryTimeout, timeout))
+
+	q := &RunningQuery{
+		ID:       id,
+		SQL:      sqlText,
+		Source:   source,
+		Class:    ClassFor(source),
+		Started:  time.Now(),
+		Deadline: time.Now().Add(timeout),
+		cancel:   cancel,
+	}
+	running[id] = q
+
+	done := func() {
+		runningMu.Lock()
+		delete(running, id)
+		runningMu.Unlock()
+		cancelTimeout()
+		cancel(nil)
+	}
+	return ctx, done, nil
+}
+
+// Interrupt cancels the running query with the given id, recording cause as
+// the reason. It reports whether a query was found.
+func Interrupt(id string, cause error) bool {
+	runningMu.Lock()
+	q, ok := running[id]
+	runningMu.Unlock()
+	if !ok {
+		return false
+	}
+	q.cancel(cause)
+	return true
+}
+
+// CancelQuery cancels the running query with the given id.
+func CancelQuery(id string) bool {
+	return Interrupt(id, ErrQueryCanceled)
+}
+
+// RunningQueries returns a snapshot of all tracked queries, oldest first,
+// with the queue position of those still waiting to run.
+func RunningQueries() []RunningQuery {
+	positions := queuePositions()
+	runningMu.Lock()
+	list := make([]RunningQuery, 0, len(running))
+	for _, q := range running {
+		rq := *q
+		rq.State = "running"
+		if pos, ok := positions[rq.ID]; ok {
+			rq.State, rq.QueuePosition = "queued", pos
+		}
+		list = append(list, rq)
+	}
+	runningMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
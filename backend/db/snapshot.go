@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SnapshotTable writes the result of a SELECT into a catalog table for a
+// schedule. When appending, each row is stamped with runAt in a leading run_at
+// column and rows are matched to existing columns by name; otherwise the table
+// is replaced. The uploaded table and views can't be used as targets. A table
+// SnapshotTable creates records the schedule that created it.
+func SnapshotTable(ctx context.Context, table, schedule, selectSQL string, args []interface{}, appendRows bool, runAt time.Time) (int64, error) {
+	if !tableNameRe.MatchString(table) {
+		return 0, fmt.Errorf("invalid table name %q: use letters, digits and underscores", table)
+	}
+	existing, exists := LookupTable(table)
+	if exists && existing.Kind != "table" {
+		return 0, fmt.Errorf("%s is not a derived table and can't be written to", existing.Name)
+	}
+	parents, err := ReferencedTables(ctx, selectSQL)
+	if err != nil {
+		return 0, err
+	}
+
+	query := strings.TrimRight(strings.TrimSpace(selectSQL), ";")
+	stamp := fmt.Sprintf("TIMESTAMPTZ '%s'", runAt.UTC().Format("2006-01-02 15:04:05.999999Z07:00"))
+	var stmt string
+	switch {
+	case appendRows && exists:
+		stmt = fmt.Sprintf("INSERT INTO %s BY NAME SELECT %s AS run_at, * FROM (%s) AS _q", QuoteIdent(existing.Name), stamp, query)
+	case appendRows:
+		stmt = fmt.Sprintf("CREATE TABLE %s AS SELECT %s AS run_at, * FROM (%s) AS _q", QuoteIdent(table), stamp, query)
+	default:
+		stmt = fmt.Sprintf("CREATE OR REPLACE TABLE %s AS %s", QuoteIdent(table), query)
+	}
+
+	res, err := DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+
+	e := CatalogEntry{
+		Name:           table,
+		Kind:           "table",
+		SQL:            selectSQL,
+		Parents:        parents,
+		Created:        time.Now(),
+		DatasetVersion: BumpTableVersion(table),
+		Schedule:       schedule,
+	}
+	if exists {
+		e.Name, e.Created, e.Schedule = existing.Name, existing.Created, existing.Schedule
+	}
+	registerTable(e)
+	return n, nil
+}
+
+// exportFormats maps export formats to COPY options.
+var exportFormats = map[string]string{
+	"csv":     "FORMAT CSV, HEADER",
+	"parquet": "FORMAT PARQUET",
+	"json":    "FORMAT JSON",
+}
+
+// ExportQuery writes the result of a SELECT to a file with COPY.
+func ExportQuery(ctx context.Context, path, format, selectSQL string, args []interface{}) (int64, error) {
+	options, ok := exportFormats[format]
+	if !ok {
+		return 0, fmt.Errorf("unsupported export format %q", format)
+	}
+	stmt := fmt.Sprintf("COPY (%s) TO '%s' (%s)",
+		strings.TrimRight(strings.TrimSpace(selectSQL), ";"), strings.ReplaceAll(path, "'", "''"), options)
+	res, err := DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ValidExportFormat reports whether ExportQuery supports format.
+func ValidExportFormat(format string) bool {
+	_, ok := exportFormats[format]
+	return ok
+}
@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type chatMessage struct {
@@ -24,12 +25,16 @@ type chatMessage struct {
 type chatRequest struct {
 	Messages    []chatMessage `json:"messages"`
 	AutoExecute bool          `json:"autoExecute"`
+	Explain     bool          `json:"explain"`
 }
 
 type chatResponse struct {
-	Reply       string      `json:"reply"`
-	SQL         string      `json:"sql,omitempty"`
-	QueryResult interface{} `json:"queryResult,omitempty"`
+	Reply       string        `json:"reply"`
+	SQL         string        `json:"sql,omitempty"`
+	QueryResult interface{}   `json:"queryResult,omitempty"`
+	QueryError  string        `json:"queryError,omitempty"`
+	Plan        *queryPlan    `json:"plan,omitempty"`
+	Lint        []lintWarning `json:"lint,omitempty"`
 }
 
 var sqlFenceRe = regexp.MustCompile("(?s)```sql\\s*\n?(.*?)```")
@@ -50,11 +55,20 @@ func Chat(c *fiber.Ctx) error {
 
 	// Build schema context
 	schemaCtx := buildSchemaContext()
+	if metricsCtx := buildMetricsContext(); metricsCtx != "" {
+		schemaCtx += "\n\nCanonical metric definitions. When a question asks for one of these measures, " +
+			"compute it with exactly this expression, group by the dimension expressions, and apply a named " +
+			"filter whenever the question refers to it:" + metricsCtx
+	}
+	if macrosCtx := buildMacrosContext(); macrosCtx != "" {
+		schemaCtx += "\n\nUser-defined macros, called like functions. Use them instead of writing the same logic out:" + macrosCtx
+	}
 
 	fence := "```"
 	systemPrompt := fmt.Sprintf("You are a DuckDB SQL assistant for the ArtemisGO application.\n"+
-		"The user has uploaded a CSV file into a DuckDB table called \"tablename\".\n\n"+
-		"Here is the table schema and sample data:\n%s\n\n"+
+		"The user has uploaded a CSV file into a DuckDB table called \"tablename\".\n"+
+		"They may also have saved query results as further tables or views, which you can query the same way.\n\n"+
+		"Here are the table schemas and sample data:\n%s\n\n"+
 		"Rules:\n"+
 		"- When the user asks a data question, generate a SQL query to answer it.\n"+
 		"- Always wrap SQL in a single %ssql code fence.\n"+
@@ -82,11 +96,36 @@ func Chat(c *fiber.Ctx) error {
 		extractedSQL := strings.TrimSpace(matches[1])
 		resp.SQL = extractedSQL
 
-		// Auto-execute if requested and SQL is safe (SELECT only)
-		if req.AutoExecute && isSafeSQL(extractedSQL) {
-			result, err := executeSQL(extractedSQL)
+		// Generated SQL goes through the same checks as the editor's
+		if warnings, err := lintSQL(c.Context(), extractedSQL, "chat"); err == nil {
+			resp.Lint = warnings
+		}
+
+		// Auto-execute if requested; the chat policy rejects anything unsafe
+		if req.AutoExecute {
+			result, err := runQuery(c, execSpec{
+				ID:      uuid.NewString(),
+				SQL:     extractedSQL,
+				Source:  "chat",
+				Timeout: db.QueryTimeout,
+			})
 			if err == nil {
 				resp.QueryResult = result
+			} else {
+				resp.QueryError = err.Error()
+			}
+		}
+
+		// Attach the estimated plan so the UI can show how the query will run
+		if req.Explain {
+			plan, err := explainQuery(c, execSpec{
+				ID:      uuid.NewString(),
+				SQL:     extractedSQL,
+				Source:  "chat",
+				Timeout: db.QueryTimeout,
+			}, false)
+			if err == nil {
+				resp.Plan = plan
 			}
 		}
 	}
@@ -94,13 +133,39 @@ func Chat(c *fiber.Ctx) error {
 	return c.JSON(resp)
 }
 
+// buildSchemaContext describes the uploaded table and any tables or views
+// saved from queries.
 func buildSchemaContext() string {
+	tables := db.Tables()
+	if len(tables) == 0 {
+		return "No table loaded."
+	}
+
+	var sb strings.Builder
+	for i, e := range tables {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		switch e.Kind {
+		case "upload":
+			sb.WriteString(fmt.Sprintf("Table \"%s\" (uploaded CSV)\n", e.Name))
+		case "view":
+			sb.WriteString(fmt.Sprintf("View \"%s\" (defined as: %s)\n", e.Name, e.SQL))
+		default:
+			sb.WriteString(fmt.Sprintf("Table \"%s\" (saved from: %s)\n", e.Name, e.SQL))
+		}
+		sb.WriteString(describeForChat(db.QuoteIdent(e.Name)))
+	}
+	return sb.String()
+}
+
+func describeForChat(table string) string {
 	var sb strings.Builder
 
 	// Get column info
-	rows, err := db.DB.Query(fmt.Sprintf("DESCRIBE %s", db.TableName))
+	rows, err := db.DB.Query(fmt.Sprintf("DESCRIBE %s", table))
 	if err != nil {
-		return "No table loaded."
+		return "Unavailable: " + err.Error() + "\n"
 	}
 	defer rows.Close()
 
@@ -115,7 +180,7 @@ func buildSchemaContext() string {
 	}
 
 	// Get sample rows
-	sampleRows, err := db.DB.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 3", db.TableName))
+	sampleRows, err := db.DB.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 3", table))
 	if err != nil {
 		return sb.String()
 	}
@@ -148,7 +213,7 @@ func buildSchemaContext() string {
 
 	// Get row count
 	var rowCount int
-	if err := db.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", db.TableName)).Scan(&rowCount); err == nil {
+	if err := db.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&rowCount); err == nil {
 		sb.WriteString(fmt.Sprintf("\nTotal rows: %d\n", rowCount))
 	}
 
@@ -248,60 +313,3 @@ func callGemini(apiKey string, req geminiRequest) (string, error) {
 
 	return gemResp.Candidates[0].Content.Parts[0].Text, nil
 }
-
-func isSafeSQL(query string) bool {
-	upper := strings.ToUpper(strings.TrimSpace(query))
-	if !strings.HasPrefix(upper, "SELECT") {
-		return false
-	}
-	dangerous := []string{"INSERT", "UPDATE", "DELETE", "DROP", "ALTER", "CREATE", "TRUNCATE", "EXEC", "EXECUTE"}
-	for _, kw := range dangerous {
-		if strings.Contains(upper, kw) {
-			return false
-		}
-	}
-	return true
-}
-
-func executeSQL(query string) (fiber.Map, error) {
-	rows, err := db.DB.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, err
-	}
-
-	var results [][]interface{}
-	for rows.Next() {
-		vals := make([]interface{}, len(columns))
-		ptrs := make([]interface{}, len(columns))
-		for i := range vals {
-			ptrs[i] = &vals[i]
-		}
-		if err := rows.Scan(ptrs...); err != nil {
-			return nil, err
-		}
-		row := make([]interface{}, len(vals))
-		for i, v := range vals {
-			if b, ok := v.([]byte); ok {
-				row[i] = string(b)
-			} else {
-				row[i] = v
-			}
-		}
-		results = append(results, row)
-	}
-
-	if results == nil {
-		results = [][]interface{}{}
-	}
-
-	return fiber.Map{
-		"columns": columns,
-		"rows":    results,
-	}, nil
-}
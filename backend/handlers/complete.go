@@ -0,0 +1,623 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"artemisgo/store"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxCompletions = 200
+
+var plainIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Clause keywords that decide what is expected at the cursor.
+var (
+	tableClauses  = map[string]bool{"FROM": true, "JOIN": true, "INTO": true, "UPDATE": true, "TABLE": true, "DESCRIBE": true, "SUMMARIZE": true}
+	columnClauses = map[string]bool{
+		"SELECT": true, "WHERE": true, "ON": true, "BY": true, "HAVING": true, "SET": true,
+		"AND": true, "OR": true, "NOT": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true,
+		"USING": true, "QUALIFY": true, "RETURNING": true, "DISTINCT": true, "IN": true, "BETWEEN": true,
+		"IS": true, "LIKE": true, "ILIKE": true, "VALUES": true,
+	}
+)
+
+type completeRequest struct {
+	SQL string `json:"sql"`
+	// Cursor is an offset into SQL in UTF-16 code units, as JavaScript
+	// string indexes count them. It defaults to the end of the text.
+	Cursor *int `json:"cursor"`
+}
+
+// completion is one suggestion. InsertText replaces the text between the
+// response's from and to offsets.
+type completion struct {
+	Label         string   `json:"label"`
+	Kind          string   `json:"kind"` // table, view, column, function, aggregate, table_function, macro or keyword
+	Detail        string   `json:"detail,omitempty"`
+	InsertText    string   `json:"insertText"`
+	Documentation string   `json:"documentation,omitempty"`
+	Signatures    []string `json:"signatures,omitempty"`
+}
+
+type completionTable struct {
+	Name    string
+	Kind    string
+	Columns []db.Column
+	Rows    int64 // estimated; 0 for views
+}
+
+type completionFunction struct {
+	Name        string
+	Kind        string
+	Description string
+	Signatures  []string
+}
+
+// completionCatalog is a snapshot of the schema and DuckDB's functions and
+// keywords, reloaded whenever the dataset version changes. Lint checks
+// against it too.
+type completionCatalog struct {
+	version   int64
+	tables    []completionTable
+	functions []completionFunction
+	keywords  []string
+	reserved  map[string]bool
+}
+
+var (
+	completionMu    sync.Mutex
+	completionCache *completionCatalog
+)
+
+// Complete returns context-aware completions for the SQL text at the cursor:
+// tables and views after FROM or JOIN, columns of the tables in scope (or of
+// an alias before a dot), functions with their signatures, and keywords.
+func Complete(c *fiber.Ctx) error {
+	var req completeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	cursor := len(req.SQL)
+	if req.Cursor != nil {
+		if *req.Cursor < 0 || *req.Cursor > utf16Len(req.SQL) {
+			return c.Status(400).JSON(fiber.Map{"error": "cursor is outside the SQL text"})
+		}
+		cursor = utf16ToByte(req.SQL, *req.Cursor)
+	}
+
+	cat, err := loadCompletionCatalog(c.Context())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to read schema: %v", err)})
+	}
+	ctx := analyzeCursor(req.SQL, cursor)
+	items := cat.complete(ctx)
+	return c.JSON(fiber.Map{
+		"from":    byteToUTF16(req.SQL, ctx.from),
+		"to":      byteToUTF16(req.SQL, ctx.to),
+		"context": ctx.kind,
+		"items":   items,
+	})
+}
+
+func loadCompletionCatalog(ctx context.Context) (*completionCatalog, error) {
+	completionMu.Lock()
+	defer completionMu.Unlock()
+	version := db.DatasetVersion()
+	if completionCache != nil && completionCache.version == version {
+		return completionCache, nil
+	}
+
+	cat := &completionCatalog{version: version, reserved: map[string]bool{}}
+	if err := cat.loadTables(ctx); err != nil {
+		return nil, err
+	}
+	if err := cat.loadFunctions(ctx); err != nil {
+		return nil, err
+	}
+	if err := cat.loadKeywords(ctx); err != nil {
+		return nil, err
+	}
+	completionCache = cat
+	return cat, nil
+}
+
+// loadTables reads every table and view in the main schema, including ones
+// created outside the catalog by a script.
+func (cat *completionCatalog) loadTables(ctx context.Context) error {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT c.table_name, t.table_type, c.column_name, c.data_type
+		FROM information_schema.columns c
+		JOIN information_schema.tables t USING (table_catalog, table_schema, table_name)
+		WHERE c.table_schema = 'main' AND c.table_catalog = current_database()
+		ORDER BY c.table_name, c.ordinal_position`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, tableType string
+		var col db.Column
+		if err := rows.Scan(&table, &tableType, &col.Name, &col.Type); err != nil {
+			return err
+		}
+		if n := len(cat.tables); n == 0 || cat.tables[n-1].Name != table {
+			kind := "table"
+			if tableType == "VIEW" {
+				kind = "view"
+			}
+			cat.tables = append(cat.tables, completionTable{Name: table, Kind: kind})
+		}
+		t := &cat.tables[len(cat.tables)-1]
+		t.Columns = append(t.Columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sizes, err := db.DB.QueryContext(ctx, "SELECT table_name, estimated_size FROM duckdb_tables() WHERE schema_name = 'main' AND database_name = current_database()")
+	if err != nil {
+		return err
+	}
+	defer sizes.Close()
+	for sizes.Next() {
+		var name string
+		var size int64
+		if err := sizes.Scan(&name, &size); err != nil {
+			return err
+		}
+		if t := cat.table(name); t != nil {
+			t.Rows = size
+		}
+	}
+	return sizes.Err()
+}
+
+func (cat *completionCatalog) loadFunctions(ctx context.Context) error {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT function_name, function_type, coalesce(description, ''),
+			coalesce(array_to_string(list_transform(list_zip(parameters, parameter_types),
+				p -> CASE WHEN p[2] IS NULL OR p[2] = 'ANY' THEN p[1] ELSE p[1] || ' ' || p[2] END), ', '), ''),
+			coalesce(varargs, ''), coalesce(return_type, '')
+		FROM duckdb_functions()
+		WHERE function_type <> 'pragma'
+		ORDER BY function_name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	kinds := map[string]string{
+		"scalar":      "function",
+		"aggregate":   "aggregate",
+		"table":       "table_function",
+		"macro":       "macro",
+		"table_macro": "table_function",
+	}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var name, funcType, description, params, varargs, returnType string
+		if err := rows.Scan(&name, &funcType, &description, &params, &varargs, &returnType); err != nil {
+			return err
+		}
+		// Operators are listed as functions too
+		if !plainIdentRe.MatchString(name) {
+			continue
+		}
+		if varargs != "" {
+			if params != "" {
+				params += ", "
+			}
+			params += varargs + "..."
+		}
+		sig := name + "(" + params + ")"
+		if returnType != "" {
+			sig += " → " + returnType
+		}
+
+		n := len(cat.functions)
+		if n == 0 || cat.functions[n-1].Name != name {
+			cat.functions = append(cat.functions, completionFunction{Name: name, Kind: kinds[funcType], Description: description})
+			n++
+		}
+		f := &cat.functions[n-1]
+		if key := name + "\x00" + sig; !seen[key] {
+			seen[key] = true
+			f.Signatures = append(f.Signatures, sig)
+		}
+		if f.Description == "" {
+			f.Description = description
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// DuckDB keeps no description for user macros; the registry does
+	for _, m := range store.ListMacros() {
+		for i := range cat.functions {
+			if f := &cat.functions[i]; strings.EqualFold(f.Name, m.Name) && f.Description == "" {
+				f.Description = m.Description
+			}
+		}
+	}
+	// Shortest signature first, as the one to show inline
+	for _, f := range cat.functions {
+		sort.SliceStable(f.Signatures, func(i, j int) bool { return len(f.Signatures[i]) < len(f.Signatures[j]) })
+	}
+	return nil
+}
+
+func (cat *completionCatalog) loadKeywords(ctx context.Context) error {
+	rows, err := db.DB.QueryContext(ctx, "SELECT keyword_name, keyword_category FROM duckdb_keywords() ORDER BY keyword_name")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, category string
+		if err := rows.Scan(&name, &category); err != nil {
+			return err
+		}
+		cat.keywords = append(cat.keywords, strings.ToUpper(name))
+		if category == "reserved" {
+			cat.reserved[strings.ToLower(name)] = true
+		}
+	}
+	return rows.Err()
+}
+
+// cursorContext is what the text around the cursor says is expected there.
+type cursorContext struct {
+	kind      string            // table, column, keyword or none
+	from, to  int               // byte range the completion replaces
+	prefix    string            // identifier text typed so far, unquoted
+	qualifier string            // name before a dot, such as an alias
+	afterName bool              // the previous token is a table name, so only keywords fit
+	scope     map[string]string // lower-case alias or table name -> table name
+}
+
+// analyzeCursor works out the completion context from the tokens of the
+// statement around the cursor.
+func analyzeCursor(text string, cursor int) cursorContext {
+	ctx := cursorContext{kind: "keyword", from: cursor, to: cursor}
+
+	// The statement under the cursor runs between the neighbouring semicolons
+	start, end := 0, len(text)
+	for _, tok := range sqltext.Tokenize(text) {
+		if tok.Kind != sqltext.Punct || tok.Text != ";" {
+			continue
+		}
+		if tok.Pos < cursor {
+			start = tok.Pos + 1
+		} else {
+			end = tok.Pos
+			break
+		}
+	}
+	ctx.scope = tableScope(sqltext.Code(text[start:end]))
+
+	// Tokenize only up to the cursor so an unfinished quote ends there
+	before := sqltext.Tokenize(text[start:cursor])
+	// Nothing to complete inside a comment or an unfinished string
+	if n := len(before); n > 0 {
+		last := before[n-1]
+		atEnd := start+last.Pos+len(last.Text) == cursor
+		open := last.Kind == sqltext.Comment && !strings.HasSuffix(last.Text, "*/") ||
+			last.Kind == sqltext.String && !stringClosed(last.Text)
+		if atEnd && open {
+			ctx.kind = "none"
+			return ctx
+		}
+	}
+	code := before[:0]
+	for _, tok := range before {
+		if tok.Kind != sqltext.Comment {
+			code = append(code, tok)
+		}
+	}
+
+	// The identifier being typed, which the completion replaces whole
+	if n := len(code); n > 0 {
+		last := code[n-1]
+		if (last.Kind == sqltext.Ident || last.Kind == sqltext.QuotedIdent) && start+last.Pos+len(last.Text) == cursor {
+			ctx.from = start + last.Pos
+			ctx.prefix = last.Name()
+			if last.Kind == sqltext.QuotedIdent && !identClosed(last.Text) {
+				ctx.prefix = last.Text[1:]
+			}
+			code = code[:n-1]
+		}
+	}
+	for ctx.to < len(text) {
+		r, size := utf8.DecodeRuneInString(text[ctx.to:])
+		if r != '_' && !isLetterOrDigit(r) {
+			break
+		}
+		ctx.to += size
+	}
+
+	n := len(code)
+	if n >= 2 && code[n-1].Kind == sqltext.Punct && code[n-1].Text == "." &&
+		(code[n-2].Kind == sqltext.Ident || code[n-2].Kind == sqltext.QuotedIdent) {
+		ctx.qualifier = code[n-2].Name()
+		ctx.kind = "column"
+		return ctx
+	}
+
+	// Walk back to the clause keyword, skipping parenthesized groups that
+	// are already closed
+	depth := 0
+	for i := n - 1; i >= 0; i-- {
+		tok := code[i]
+		switch {
+		case tok.Kind == sqltext.Punct && tok.Text == ")":
+			depth++
+		case tok.Kind == sqltext.Punct && tok.Text == "(":
+			if depth == 0 {
+				// Inside an open call or subquery: arguments are expressions
+				// unless a clause keyword follows the parenthesis
+				ctx.kind = "column"
+				return ctx
+			}
+			depth--
+		case depth > 0 || tok.Kind != sqltext.Ident:
+		case tableClauses[tok.Upper()]:
+			ctx.kind = "table"
+			prev := code[n-1]
+			ctx.afterName = i < n-1 && !(prev.Kind == sqltext.Punct && prev.Text == ",")
+			return ctx
+		case columnClauses[tok.Upper()]:
+			ctx.kind = "column"
+			return ctx
+		}
+	}
+	return ctx
+}
+
+// tableScope maps the tables a statement reads, and their aliases, to table
+// names.
+func tableScope(code []sqltext.Token) map[string]string {
+	scope := map[string]string{}
+	isName := func(t sqltext.Token) bool {
+		return t.Kind == sqltext.QuotedIdent || t.Kind == sqltext.Ident && !clauseWord(t.Upper())
+	}
+	for i := 0; i < len(code); i++ {
+		if !code[i].IsKeyword("FROM") && !code[i].IsKeyword("JOIN") && !code[i].IsKeyword("UPDATE") && !code[i].IsKeyword("INTO") {
+			continue
+		}
+		// A comma-separated list after FROM: name [AS] alias, ...
+		for j := i + 1; j < len(code) && isName(code[j]); {
+			table := code[j].Name()
+			scope[strings.ToLower(table)] = table
+			j++
+			if j < len(code) && code[j].IsKeyword("AS") {
+				j++
+			}
+			if j < len(code) && isName(code[j]) {
+				scope[strings.ToLower(code[j].Name())] = table
+				j++
+			}
+			if j >= len(code) || code[j].Kind != sqltext.Punct || code[j].Text != "," {
+				break
+			}
+			j++
+		}
+	}
+	return scope
+}
+
+// clauseWord reports whether an upper-case word ends a FROM list rather than
+// naming a table or alias.
+func clauseWord(word string) bool {
+	switch word {
+	case "WHERE", "GROUP", "ORDER", "HAVING", "LIMIT", "OFFSET", "JOIN", "INNER", "LEFT", "RIGHT",
+		"FULL", "CROSS", "NATURAL", "ON", "USING", "UNION", "EXCEPT", "INTERSECT", "WINDOW",
+		"QUALIFY", "SET", "VALUES", "SELECT", "RETURNING", "AS", "POSITIONAL", "ASOF", "ANTI", "SEMI":
+		return true
+	}
+	return false
+}
+
+func (cat *completionCatalog) complete(ctx cursorContext) []completion {
+	var items []completion
+	add := func(label string, item completion) {
+		if len(items) < maxCompletions && hasPrefixFold(label, ctx.prefix) {
+			items = append(items, item)
+		}
+	}
+
+	if ctx.qualifier != "" {
+		table, ok := ctx.scope[strings.ToLower(ctx.qualifier)]
+		if !ok {
+			table = ctx.qualifier
+		}
+		if t := cat.table(table); t != nil {
+			for _, col := range t.Columns {
+				add(col.Name, cat.columnItem(t, col))
+			}
+		}
+		return nonNil(items)
+	}
+
+	switch ctx.kind {
+	case "none":
+	case "table":
+		if ctx.afterName {
+			cat.addKeywords(add)
+			break
+		}
+		for i := range cat.tables {
+			t := &cat.tables[i]
+			add(t.Name, completion{
+				Label:      t.Name,
+				Kind:       t.Kind,
+				Detail:     fmt.Sprintf("%s, %d columns", t.Kind, len(t.Columns)),
+				InsertText: cat.quote(t.Name),
+			})
+		}
+		for _, f := range cat.functions {
+			if f.Kind == "table_function" {
+				add(f.Name, functionItem(f))
+			}
+		}
+	case "column":
+		// Columns of the tables in scope, or of every table before FROM is
+		// written
+		inScope := map[string]bool{}
+		for _, table := range ctx.scope {
+			inScope[strings.ToLower(table)] = true
+		}
+		for i := range cat.tables {
+			t := &cat.tables[i]
+			if len(inScope) > 0 && !inScope[strings.ToLower(t.Name)] {
+				continue
+			}
+			for _, col := range t.Columns {
+				add(col.Name, cat.columnItem(t, col))
+			}
+		}
+		for _, f := range cat.functions {
+			if f.Kind != "table_function" {
+				add(f.Name, functionItem(f))
+			}
+		}
+		cat.addKeywords(add)
+	default:
+		cat.addKeywords(add)
+	}
+	return nonNil(items)
+}
+
+func (cat *completionCatalog) addKeywords(add func(string, completion)) {
+	for _, kw := range cat.keywords {
+		add(kw, completion{Label: kw, Kind: "keyword", InsertText: kw})
+	}
+}
+
+func (cat *completionCatalog) table(name string) *completionTable {
+	for i := range cat.tables {
+		if strings.EqualFold(cat.tables[i].Name, name) {
+			return &cat.tables[i]
+		}
+	}
+	return nil
+}
+
+func (cat *completionCatalog) columnItem(t *completionTable, col db.Column) completion {
+	return completion{
+		Label:      col.Name,
+		Kind:       "column",
+		Detail:     col.Type + " · " + t.Name,
+		InsertText: cat.quote(col.Name),
+	}
+}
+
+func functionItem(f completionFunction) completion {
+	return completion{
+		Label:         f.Name,
+		Kind:          f.Kind,
+		Detail:        f.Signatures[0],
+		InsertText:    f.Name + "(",
+		Documentation: f.Description,
+		Signatures:    f.Signatures,
+	}
+}
+
+// quote returns name as it must be written in SQL, double-quoted when it has
+// spaces or special characters or is a reserved word.
+func (cat *completionCatalog) quote(name string) string {
+	if plainIdentRe.MatchString(name) && !cat.reserved[strings.ToLower(name)] {
+		return name
+	}
+	return db.QuoteIdent(name)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func nonNil(items []completion) []completion {
+	if items == nil {
+		return []completion{}
+	}
+	return items
+}
+
+func isLetterOrDigit(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r >= utf8.RuneSelf
+}
+
+// stringClosed reports whether a string token has its closing quote.
+func stringClosed(text string) bool {
+	if strings.HasPrefix(text, "$") {
+		tagEnd := strings.Index(text[1:], "$") + 2
+		return tagEnd > 1 && len(text) >= 2*tagEnd && strings.HasSuffix(text, text[:tagEnd])
+	}
+	i := strings.IndexByte(text, '\'')
+	// E'...' strings also escape with a backslash
+	return i >= 0 && quoteClosed(text[i:], '\'', i > 0)
+}
+
+func identClosed(text string) bool {
+	return quoteClosed(text, '"', false)
+}
+
+// quoteClosed reports whether text, starting at an opening quote q, ends with
+// its closing quote. Doubled quotes inside are escapes, as the lexer reads
+// them, so a string ending in a doubled quote is still open.
+func quoteClosed(text string, q byte, backslash bool) bool {
+	for i := 1; i < len(text); {
+		switch {
+		case backslash && text[i] == '\\':
+			i += 2
+		case text[i] == q:
+			if i+1 < len(text) && text[i+1] == q {
+				i += 2
+				continue
+			}
+			return i == len(text)-1
+		default:
+			i++
+		}
+	}
+	return false
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Width(r)
+	}
+	return n
+}
+
+// utf16ToByte converts an offset in UTF-16 code units to a byte offset.
+func utf16ToByte(s string, units int) int {
+	for i, r := range s {
+		if units <= 0 {
+			return i
+		}
+		units -= utf16Width(r)
+	}
+	return len(s)
+}
+
+func byteToUTF16(s string, offset int) int {
+	return utf16Len(s[:offset])
+}
+
+func utf16Width(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
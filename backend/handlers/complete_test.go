@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"strings"
+	"testing"
+)
+
+func TestStringClosed(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{`'ab'`, true},
+		{`''`, true},
+		{`'`, false},
+		{`'ab`, false},
+		{`'ab''`, false},
+		{`'ab'''`, true},
+		{`'it''s'`, true},
+		{`E'it\'s'`, true},
+		{`E'it\'`, false},
+		{`E'a\\'`, true},
+		{`$$x$$`, true},
+		{`$tag$x$t`, false},
+	}
+	for _, tt := range tests {
+		if got := stringClosed(tt.text); got != tt.want {
+			t.Errorf("stringClosed(%s) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+	for text, want := range map[string]bool{`""`: true, `"a"`: true, `"a""`: false, `"a"""`: true, `""""`: true, `"a`: false} {
+		if got := identClosed(text); got != want {
+			t.Errorf("identClosed(%s) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestAnalyzeCursor(t *testing.T) {
+	// | marks the cursor
+	tests := []struct {
+		sql       string
+		kind      string
+		prefix    string
+		qualifier string
+		afterName bool
+	}{
+		{"SELECT * FROM |", "table", "", "", false},
+		{"SELECT * FROM sa|", "table", "sa", "", false},
+		{"SELECT * FROM sales, cu|", "table", "cu", "", false},
+		{"SELECT * FROM sales JOIN |", "table", "", "", false},
+		{"SELECT * FROM sales |", "table", "", "", true},
+		{"SELECT * FROM sales s WHERE s.|", "column", "", "s", false},
+		{"SELECT s.am| FROM sales s", "column", "am", "s", false},
+		{`SELECT "s".| FROM sales "s"`, "column", "", "s", false},
+		{"SELECT | FROM sales", "column", "", "", false},
+		{"SELECT count(| FROM sales", "column", "", "", false},
+		{"SELECT * FROM sales WHERE day > (SELECT max(day) FROM |)", "table", "", "", false},
+		{"SELECT * FROM sales; SELECT |", "column", "", "", false},
+		{"|", "keyword", "", "", false},
+		{"SELECT 'FROM |", "none", "", "", false},
+		{"SELECT 'it''s |", "none", "", "", false},
+		{"SELECT 'ab''|", "none", "", "", false},
+		{"SELECT E'a\\'|", "none", "", "", false},
+		{"SELECT $$ FROM |", "none", "", "", false},
+		{"SELECT * -- FROM |", "none", "", "", false},
+		{"SELECT * /* FROM |", "none", "", "", false},
+		{"SELECT 'ab'|", "column", "", "", false},
+		{"SELECT * /* x */ FROM |", "table", "", "", false},
+		{"SELECT * -- x\nFROM |", "table", "", "", false},
+	}
+	for _, tt := range tests {
+		cursor := strings.Index(tt.sql, "|")
+		text := tt.sql[:cursor] + tt.sql[cursor+1:]
+		ctx := analyzeCursor(text, cursor)
+		if ctx.kind != tt.kind || ctx.prefix != tt.prefix || ctx.qualifier != tt.qualifier || ctx.afterName != tt.afterName {
+			t.Errorf("%q: got kind %s, prefix %q, qualifier %q, afterName %v; want %s, %q, %q, %v",
+				tt.sql, ctx.kind, ctx.prefix, ctx.qualifier, ctx.afterName, tt.kind, tt.prefix, tt.qualifier, tt.afterName)
+		}
+	}
+}
+
+func TestCompleteContext(t *testing.T) {
+	cat := &completionCatalog{
+		tables: []completionTable{
+			{Name: "sales", Kind: "table", Columns: []db.Column{{Name: "day", Type: "DATE"}, {Name: "amount", Type: "DECIMAL(10,2)"}}},
+			{Name: "customers", Kind: "table", Columns: []db.Column{{Name: "id", Type: "INTEGER"}, {Name: "name", Type: "VARCHAR"}}},
+			{Name: "recent", Kind: "view", Columns: []db.Column{{Name: "day", Type: "DATE"}}},
+		},
+		functions: []completionFunction{
+			{Name: "abs", Kind: "function", Signatures: []string{"abs(x)"}},
+			{Name: "read_csv", Kind: "table_function", Signatures: []string{"read_csv(path)"}},
+		},
+		keywords: []string{"FROM", "WHERE"},
+		reserved: map[string]bool{},
+	}
+	labels := func(sql string) []string {
+		cursor := strings.Index(sql, "|")
+		text := sql[:cursor] + sql[cursor+1:]
+		var out []string
+		for _, item := range cat.complete(analyzeCursor(text, cursor)) {
+			out = append(out, item.Kind+":"+item.Label)
+		}
+		return out
+	}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM |", "table:sales table:customers view:recent table_function:read_csv"},
+		{"SELECT * FROM cu|", "table:customers"},
+		{"SELECT * FROM sales |", "keyword:FROM keyword:WHERE"},
+		{"SELECT c.| FROM sales s JOIN customers AS c ON true", "column:id column:name"},
+		{"SELECT s.a| FROM sales s", "column:amount"},
+		{"SELECT customers.| FROM customers", "column:id column:name"},
+		{"SELECT x.| FROM sales s", ""},
+		{"SELECT a| FROM sales", "column:amount function:abs"},
+		{"SELECT * FROM sales WHERE '|", ""},
+		{"SELECT * FROM sales -- s.|", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(labels(tt.sql), " "); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package handlers
+
+import "net"
+
+// connClosed is not supported on this platform; queries still stop at their
+// deadline or when canceled explicitly.
+func connClosed(conn net.Conn) bool {
+	return false
+}
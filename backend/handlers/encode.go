@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// Values are encoded for JSON consumers, which are mostly JavaScript:
+//
+//   - integers outside ±(2^53-1) and HUGEINTs that don't fit become decimal strings
+//   - DECIMAL becomes an exact decimal string
+//   - NaN and ±Inf become "NaN", "Infinity" and "-Infinity"
+//   - INTERVAL becomes an ISO 8601 duration such as "P1M3DT2H"
+//   - DATE, TIME and TIMESTAMP become ISO 8601 strings without a zone;
+//     TIMESTAMPTZ and TIMETZ are rendered in UTC with a Z suffix
+//   - UUID becomes its canonical string, BLOB becomes base64
+//   - LIST/ARRAY become arrays, STRUCT an object, MAP an array of
+//     {"key", "value"} pairs since keys need not be strings
+//
+// The raw DuckDB type of each column is returned alongside the rows so clients
+// can tell a DECIMAL string from a VARCHAR.
+
+const maxSafeInteger = 1<<53 - 1
+
+// duckType is a parsed DuckDB type name, detailed enough to encode nested
+// values.
+type duckType struct {
+	Name   string // upper-case base name: INTEGER, DECIMAL, LIST, STRUCT, MAP, ...
+	Elem   *duckType
+	Key    *duckType
+	Value  *duckType
+	Fields map[string]*duckType
+}
+
+// parseType parses names as reported by the driver, such as
+// "DECIMAL(18,3)", "INTEGER[]", "VARCHAR[3]", "MAP(VARCHAR, INTEGER[])" or
+// `STRUCT("a" INTEGER, "b x" DATE)`.
+func parseType(s string) *duckType {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "]") {
+		if i := strings.LastIndex(s, "["); i > 0 {
+			return &duckType{Name: "LIST", Elem: parseType(s[:i])}
+		}
+	}
+
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return &duckType{Name: strings.ToUpper(s)}
+	}
+	t := &duckType{Name: strings.ToUpper(strings.TrimSpace(s[:open]))}
+	args := splitTopLevel(s[open+1 : len(s)-1])
+	switch t.Name {
+	case "MAP":
+		if len(args) == 2 {
+			t.Key, t.Value = parseType(args[0]), parseType(args[1])
+		}
+	case "STRUCT", "UNION":
+		t.Fields = map[string]*duckType{}
+		for _, field := range args {
+			name, typ := splitFieldName(strings.TrimSpace(field))
+			t.Fields[name] = parseType(typ)
+		}
+	}
+	return t
+}
+
+// splitTopLevel splits on commas outside parentheses, brackets and quotes.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitFieldName separates `"name" TYPE` or `name TYPE`.
+func splitFieldName(field string) (string, string) {
+	if strings.HasPrefix(field, `"`) {
+		for i := 1; i < len(field); i++ {
+			if field[i] != '"' {
+				continue
+			}
+			if i+1 < len(field) && field[i+1] == '"' {
+				i++
+				continue
+			}
+			return strings.ReplaceAll(field[1:i], `""`, `"`), field[i+1:]
+		}
+	}
+	name, typ, _ := strings.Cut(field, " ")
+	return name, typ
+}
+
+// encodeValue converts a scanned value into a JSON-safe representation
+// according to its DuckDB type.
+func encodeValue(v interface{}, t *duckType) interface{} {
+	if t == nil {
+		t = &duckType{}
+	}
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case int64:
+		return encodeInt(x)
+	case int:
+		return encodeInt(int64(x))
+	case uint64:
+		if x > maxSafeInteger {
+			return strconv.FormatUint(x, 10)
+		}
+		return x
+	case float32:
+		return encodeFloat(float64(x))
+	case float64:
+		return encodeFloat(x)
+	case *big.Int:
+		if x.IsInt64() {
+			return encodeInt(x.Int64())
+		}
+		return x.String()
+	case duckdb.Decimal:
+		return formatDecimal(x)
+	case duckdb.Interval:
+		return formatInterval(x)
+	case []byte:
+		if t.Name == "UUID" && len(x) == 16 {
+			if u, err := uuid.FromBytes(x); err == nil {
+				return u.String()
+			}
+		}
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		return formatTime(x, t.Name)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = encodeValue(e, t.Elem)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = encodeValue(e, t.Fields[k])
+		}
+		return out
+	case duckdb.Map:
+		out := make([]fiber.Map, 0, len(x))
+		for k, e := range x {
+			out = append(out, fiber.Map{"key": encodeValue(k, t.Key), "value": encodeValue(e, t.Value)})
+		}
+		return out
+	}
+	return v
+}
+
+func encodeInt(i int64) interface{} {
+	if i > maxSafeInteger || i < -maxSafeInteger {
+		return strconv.FormatInt(i, 10)
+	}
+	return i
+}
+
+func encodeFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+func formatDecimal(d duckdb.Decimal) string {
+	if d.Value == nil {
+		return "0"
+	}
+	digits := new(big.Int).Abs(d.Value).String()
+	scale := int(d.Scale)
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if d.Value.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// formatInterval renders an ISO 8601 duration. DuckDB intervals keep months,
+// days and microseconds separately, and each may be negative independently,
+// so each component carries its own sign.
+func formatInterval(iv duckdb.Interval) string {
+	var sb strings.Builder
+	sb.WriteString("P")
+	if y := iv.Months / 12; y != 0 {
+		fmt.Fprintf(&sb, "%dY", y)
+	}
+	if m := iv.Months % 12; m != 0 {
+		fmt.Fprintf(&sb, "%dM", m)
+	}
+	if iv.Days != 0 {
+		fmt.Fprintf(&sb, "%dD", iv.Days)
+	}
+	if micros := iv.Micros; micros != 0 {
+		sb.WriteString("T")
+		if h := micros / 3_600_000_000; h != 0 {
+			fmt.Fprintf(&sb, "%dH", h)
+		}
+		micros %= 3_600_000_000
+		if m := micros / 60_000_000; m != 0 {
+			fmt.Fprintf(&sb, "%dM", m)
+		}
+		micros %= 60_000_000
+		if micros != 0 {
+			sign := ""
+			if micros < 0 {
+				sign, micros = "-", -micros
+			}
+			secs := strconv.FormatInt(micros/1_000_000, 10)
+			if frac := micros % 1_000_000; frac != 0 {
+				secs += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+			}
+			fmt.Fprintf(&sb, "%s%sS", sign, secs)
+		}
+	}
+	if sb.Len() == 1 {
+		return "PT0S"
+	}
+	return sb.String()
+}
+
+func formatTime(t time.Time, typeName string) string {
+	switch typeName {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "TIME":
+		return t.Format("15:04:05.999999")
+	case "TIMETZ", "TIME WITH TIME ZONE":
+		return t.UTC().Format("15:04:05.999999Z07:00")
+	case "TIMESTAMP", "TIMESTAMP_S", "TIMESTAMP_MS", "DATETIME":
+		return t.Format("2006-01-02T15:04:05.999999")
+	case "TIMESTAMP_NS":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"artemisgo/store"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// execSpec describes one query execution.
+type execSpec struct {
+	ID          string
+	SQL         string
+	Params      *queryParams
+	Source      string
+	Timeout     time.Duration
+	RerunOf     string // history entry this execution repeats, if any
+	NoCache     bool
+	Script      bool // may create temp tables and views
+	Transaction bool // runs a script in one transaction, rolled back on failure
+	Columnar    bool // scan the result into Data rather than Rows
+}
+
+type queryResult struct {
+	Columns []string        `json:"columns"`
+	Types   []string        `json:"columnTypes"`
+	Rows    [][]interface{} `json:"rows"`
+	Data    [][]interface{} `json:"-"` // one slice per column, for columnar output
+	Cache   *cacheInfo      `json:"cache,omitempty"`
+}
+
+func (r *queryResult) rowCount() int {
+	if r.Data != nil {
+		if len(r.Data) == 0 {
+			return 0
+		}
+		return len(r.Data[0])
+	}
+	return len(r.Rows)
+}
+
+// requestTimeout returns the deadline for a query, letting callers shorten
+// (but never extend) the server-wide limit.
+func requestTimeout(timeoutMs int) time.Duration {
+	if timeoutMs > 0 {
+		if d := time.Duration(timeoutMs) * time.Millisecond; d < db.QueryTimeout {
+			return d
+		}
+	}
+	return db.QueryTimeout
+}
+
+// execution is a query that has passed binding and the policy check and is
+// registered as running.
+type execution struct {
+	ctx     context.Context
+	sql     string // spec.SQL with typed placeholders cast (see bind)
+	args    []interface{}
+	writes  bool
+	class   string
+	id      string
+	release func()
+	untrack func()
+}
+
+// admit waits for an execution slot in the query's admission class. Work
+// that doesn't touch DuckDB, such as serving a cached result, should happen
+// before this.
+func (ex *execution) admit() error {
+	release, err := db.Admit(ex.ctx, ex.id, ex.class)
+	if err != nil {
+		return err
+	}
+	ex.release = release
+	return nil
+}
+
+// done frees the execution slot, if one was taken, and unregisters the query.
+func (ex *execution) done() {
+	if ex.release != nil {
+		ex.release()
+	}
+	ex.untrack()
+}
+
+// startQuery binds the parameters and checks the SQL against the source's
+// policy, then registers the query under a tracked context so it can be
+// listed and canceled by ID. Callers must call admit before executing and
+// done when finished.
+func startQuery(spec execSpec) (*execution, error) {
+	sqlText, args, err := spec.Params.bind(spec.SQL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := db.Track(context.Background(), spec.ID, spec.SQL, spec.Source, spec.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := db.PolicyFor(spec.Source)
+	if spec.Script {
+		policy = policy.ForScripts()
+	}
+	writes, err := policy.Check(ctx, spec.SQL)
+	if err != nil {
+		err = queryError(ctx, err)
+		done()
+		return nil, err
+	}
+	return &execution{ctx: ctx, sql: sqlText, args: args, writes: writes, class: db.ClassFor(spec.Source), id: spec.ID, untrack: done}, nil
+}
+
+// runQuery executes a query and scans its rows. The query is interrupted if
+// the timeout elapses or the client goes away before it completes. Every
+// execution is recorded in the query history.
+func runQuery(c *fiber.Ctx, spec execSpec) (*queryResult, error) {
+	return runQueryConn(c.Context().Conn(), spec)
+}
+
+// runQueryConn is runQuery for a client on any connection.
+func runQueryConn(conn net.Conn, spec execSpec) (result *queryResult, err error) {
+	start := time.Now()
+	version := db.DatasetVersion()
+	defer func() {
+		rowCount, cached := 0, false
+		if result != nil {
+			rowCount, cached = result.rowCount(), result.Cache != nil && result.Cache.Hit
+		}
+		recordHistory(spec, start, version, rowCount, cached, err)
+	}()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer ex.done()
+	ctx, args, writes := ex.ctx, ex.args, ex.writes
+
+	var key string
+	if resultCache.Enabled() && !writes && !spec.NoCache {
+		key = cacheKey(ex.sql, args, db.QueryVersion(ctx, ex.sql))
+		if key != "" && spec.Columnar {
+			key = "columnar|" + key
+		}
+	}
+	if key != "" {
+		if v, stored, ok := resultCache.Get(key); ok {
+			hit := *v.(*queryResult)
+			hit.Cache = &cacheInfo{Hit: true, AgeMs: float64(time.Since(stored).Milliseconds())}
+			return &hit, nil
+		}
+	}
+	if writes {
+		// Even a failed script may have changed something before it stopped
+		defer db.BumpVersion()
+	}
+
+	stop := watchConn(conn, func() { db.Interrupt(spec.ID, db.ErrClientGone) })
+	defer stop()
+	if err := ex.admit(); err != nil {
+		return nil, err
+	}
+
+	result, err = scanQuery(ctx, db.DB, ex.sql, args, spec.Columnar)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		resultCache.Put(key, result, resultSize(result))
+	}
+	if resultCache.Enabled() {
+		miss := *result
+		miss.Cache = &cacheInfo{Hit: false}
+		result = &miss
+	}
+	return result, nil
+}
+
+func recordHistory(spec execSpec, start time.Time, version int64, rowCount int, cached bool, err error) {
+	entry := store.HistoryEntry{
+		ID:             uuid.NewString(),
+		QueryID:        spec.ID,
+		SQL:            spec.SQL,
+		Source:         spec.Source,
+		Started:        start,
+		DurationMs:     float64(time.Since(start).Microseconds()) / 1000,
+		DatasetVersion: version,
+		RerunOf:        spec.RerunOf,
+		RowCount:       rowCount,
+		Cached:         cached,
+		Script:         spec.Script,
+		Transaction:    spec.Transaction,
+	}
+	if !spec.Params.empty() {
+		entry.Params, _ = json.Marshal(spec.Params)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	store.RecordQuery(entry)
+}
+
+// queryError prefers the cancellation cause over the driver's error so
+// callers see "query timed out" rather than a generic interrupt message.
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// queryer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRows runs a query on q and scans all of its rows.
+func queryRows(ctx context.Context, q queryer, sqlText string, args []interface{}) (*queryResult, error) {
+	return scanQuery(ctx, q, sqlText, args, false)
+}
+
+// scanQuery is queryRows with a choice of layout: columnar results are
+// scanned straight into one slice per column.
+func scanQuery(ctx context.Context, q queryer, sqlText string, args []interface{}, columnar bool) (*queryResult, error) {
+	// The driver rejects a few DuckDB types outright. Probe a single query's
+	// result types first, so such columns are cast to text in the one real
+	// execution rather than failing the whole result.
+	if probe, ok := describeQuery(sqlText); ok {
+		wrapped, err := probeUnscannable(ctx, q, sqlText, probe, args)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		if wrapped != "" {
+			sqlText = wrapped
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, sqlText, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	result, err := scanRows(rows, columnar)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return result, nil
+}
+
+// probeUnscannable runs probe, the LIMIT 0 form of sqlText, and returns
+// sqlText with unscannable columns cast, or "" if none need it. A probe that
+// fails is ignored; running the query reports the error.
+func probeUnscannable(ctx context.Context, q queryer, sqlText, probe string, args []interface{}) (string, error) {
+	rows, err := q.QueryContext(ctx, probe, args...)
+	if err != nil {
+		return "", nil
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	typeNames, _ := columnTypeNames(rows)
+	return castUnscannable(sqlText, columns, typeNames)
+}
+
+func scanRows(rows *sql.Rows, columnar bool) (*queryResult, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	typeNames, err := columnTypeNames(rows)
+	if err != nil {
+		return nil, err
+	}
+	if casts := unscannableColumns(columns, typeNames); len(casts) > 0 {
+		// Only a statement describeQuery can wrap is cast before it runs
+		return nil, fmt.Errorf("result has columns of unsupported types (%s); cast them to VARCHAR", strings.Join(casts, ", "))
+	}
+	types := make([]*duckType, len(typeNames))
+	for i, name := range typeNames {
+		types[i] = parseType(name)
+	}
+
+	result := &queryResult{Columns: columns, Types: typeNames}
+	if columnar {
+		result.Data = make([][]interface{}, len(columns))
+		for i := range result.Data {
+			result.Data[i] = []interface{}{}
+		}
+	} else {
+		result.Rows = [][]interface{}{}
+	}
+	// Scan copies each value out, so one buffer serves every row
+	vals := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		if columnar {
+			for i, v := range vals {
+				result.Data[i] = append(result.Data[i], encodeValue(v, types[i]))
+			}
+			continue
+		}
+		row := make([]interface{}, len(vals))
+		for i, v := range vals {
+			row[i] = encodeValue(v, types[i])
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func columnTypeNames(rows *sql.Rows) ([]string, error) {
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(cts))
+	for i, ct := range cts {
+		names[i] = ct.DatabaseTypeName()
+	}
+	return names, nil
+}
+
+// describeQuery wraps a single query in a LIMIT 0 select, which reports its
+// columns without producing rows when run with every parameter bound to
+// NULL. It returns false for statements that don't produce a result set.
+func describeQuery(sqlText string) (string, bool) {
+	stmts := sqltext.Split(sqlText)
+	if len(stmts) != 1 {
+		return "", false
+	}
+	switch sqltext.FirstKeyword(stmts[0].SQL) {
+	case "SELECT", "WITH", "FROM", "VALUES", "TABLE":
+		return "SELECT * FROM (" + stmts[0].SQL + ") AS _q LIMIT 0", true
+	}
+	return "", false
+}
+
+// unscannableTypes can't be read by the driver, even nested inside a list or
+// struct.
+var unscannableTypes = regexp.MustCompile(`\b(BIT|UNION|VARINT)\b`)
+
+// castUnscannable rewrites a query so columns of types the driver can't scan
+// come back as VARCHAR. It returns "" if nothing needs casting.
+func castUnscannable(sqlText string, columns, typeNames []string) (string, error) {
+	casts := unscannableColumns(columns, typeNames)
+	if len(casts) == 0 {
+		return "", nil
+	}
+	stmts := sqltext.Split(sqlText)
+	if len(stmts) != 1 {
+		return "", fmt.Errorf("result has columns of unsupported types (%s); cast them to VARCHAR", strings.Join(casts, ", "))
+	}
+	return fmt.Sprintf("SELECT * REPLACE (%s) FROM (%s) AS _q", strings.Join(casts, ", "), stmts[0].SQL), nil
+}
+
+// unscannableColumns returns a cast to VARCHAR for each column the driver
+// can't scan.
+func unscannableColumns(columns, typeNames []string) []string {
+	var casts []string
+	for i, t := range typeNames {
+		if unscannableTypes.MatchString(t) {
+			col := db.QuoteIdent(columns[i])
+			casts = append(casts, fmt.Sprintf("CAST(%s AS VARCHAR) AS %s", col, col))
+		}
+	}
+	return casts
+}
+
+// watchDisconnect polls the client connection while a query runs and calls
+// onGone if the peer closes it. The returned func stops the watcher.
+func watchDisconnect(c *fiber.Ctx, onGone func()) func() {
+	return watchConn(c.Context().Conn(), onGone)
+}
+
+func watchConn(conn net.Conn, onGone func()) func() {
+	if conn == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if connClosed(conn) {
+					onGone()
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
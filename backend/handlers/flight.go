@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/flight/flightsql"
+	"github.com/apache/arrow-go/v18/arrow/flight/flightsql/schema_ref"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Arrow Flight SQL serves query results to programmatic clients (ADBC, the
+// Flight SQL JDBC driver, pyarrow) as Arrow record batches taken from DuckDB
+// without converting values. Statements run under the "flight" source, so
+// they get the same policy, admission, timeout, tracking and history as HTTP
+// queries and can be canceled from /api/queries.
+//
+// A result is collected in full before it is sent, so its size is capped by
+// FLIGHT_MAX_ROWS (default 1000000). With a password, clients authenticate
+// with basic auth and get a bearer token; the connection isn't encrypted.
+//
+// The catalog is exposed as a single catalog "memory" with schema "main",
+// which is where DuckDB keeps the managed tables.
+const (
+	flightCatalog = "memory"
+	flightSchema  = "main"
+)
+
+type flightServer struct {
+	flightsql.BaseServer
+
+	mu       sync.Mutex
+	prepared map[string]*flightStatement // by handle
+}
+
+// flightMaxRows caps the rows a Flight query may return (FLIGHT_MAX_ROWS).
+var flightMaxRows = 1000000
+
+// flightStatement is a prepared statement and the parameters last bound to
+// it.
+type flightStatement struct {
+	sql    string
+	params *queryParams
+}
+
+// ServeFlight runs the Flight SQL server on addr until it fails. If password
+// is set, clients must send it with basic auth, under any user name.
+func ServeFlight(addr, password string) error {
+	if v := os.Getenv("FLIGHT_MAX_ROWS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid FLIGHT_MAX_ROWS %q", v)
+		}
+		flightMaxRows = n
+	}
+
+	srv := &flightServer{prepared: map[string]*flightStatement{}}
+	srv.Alloc = memory.DefaultAllocator
+	info := map[flightsql.SqlInfo]interface{}{
+		flightsql.SqlInfoFlightSqlServerName:     "ArtemisGO",
+		flightsql.SqlInfoFlightSqlServerVersion:  "1.0",
+		flightsql.SqlInfoFlightSqlServerReadOnly: !db.PolicyFor("flight").AllowWrites,
+		flightsql.SqlInfoFlightSqlServerSql:      true,
+		flightsql.SqlInfoFlightSqlServerCancel:   true,
+	}
+	for id, v := range info {
+		if err := srv.RegisterSqlInfo(id, v); err != nil {
+			return err
+		}
+	}
+
+	var middleware []flight.ServerMiddleware
+	if password != "" {
+		auth, err := newFlightAuth(password)
+		if err != nil {
+			return err
+		}
+		middleware = append(middleware, flight.CreateServerBasicAuthMiddleware(auth))
+	}
+	server := flight.NewServerWithMiddleware(middleware)
+	server.RegisterFlightService(flightsql.NewFlightServer(srv))
+	if err := server.Init(addr); err != nil {
+		return err
+	}
+	log.Printf("Arrow Flight SQL listener on %s", server.Addr())
+	return server.Serve()
+}
+
+// flightAuth checks the password clients send with basic auth and issues
+// the bearer token they present on later calls. The token is an HMAC of the
+// password under a key made at startup, so it stays valid until the server
+// restarts.
+type flightAuth struct {
+	password string
+	token    string
+}
+
+func newFlightAuth(password string) (*flightAuth, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return &flightAuth{password: password, token: hex.EncodeToString(mac.Sum(nil))}, nil
+}
+
+func (a *flightAuth) Validate(_, password string) (string, error) {
+	if subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) != 1 {
+		return "", status.Error(codes.Unauthenticated, "password authentication failed")
+	}
+	return a.token, nil
+}
+
+func (a *flightAuth) IsValid(token string) (interface{}, error) {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "authenticate with basic auth first")
+	}
+	return "", nil
+}
+
+// flightError converts an error to a gRPC status for the client.
+func flightError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var policyErr *db.PolicyError
+	var paramErr *paramError
+	code := codes.InvalidArgument
+	switch {
+	case errors.As(err, &policyErr):
+		code = codes.PermissionDenied
+	case errors.Is(err, db.ErrDuplicateQueryID):
+		code = codes.AlreadyExists
+	case errors.As(err, &paramErr):
+		code = codes.InvalidArgument
+	case errors.Is(err, db.ErrQueryTimeout):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, db.ErrQueryCanceled), errors.Is(err, db.ErrClientGone):
+		code = codes.Canceled
+	case errors.Is(err, errFlightTooLarge):
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
+
+func (s *flightServer) flightInfo(desc *flight.FlightDescriptor, ticket []byte, schema *arrow.Schema) *flight.FlightInfo {
+	info := &flight.FlightInfo{
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: ticket}}},
+		FlightDescriptor: desc,
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}
+	if schema != nil {
+		info.Schema = flight.SerializeSchema(schema, s.Alloc)
+	}
+	return info
+}
+
+// describeArrow returns the Arrow schema of a query's result without running
+// it, or nil if the query doesn't produce a result set.
+func describeArrow(ctx context.Context, sqlText string) (*arrow.Schema, error) {
+	probe, ok := describeQuery(sqlText)
+	if !ok {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, db.QueryTimeout)
+	defer cancel()
+	if _, err := db.PolicyFor("flight").Check(ctx, sqlText); err != nil {
+		return nil, err
+	}
+	types, err := db.ParamTypes(ctx, sqlText)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := db.QueryArrow(ctx, probe, make([]interface{}, len(types))...)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+	return reader.Schema(), nil
+}
+
+var errFlightTooLarge = errors.New("result too large")
+
+// streamQuery runs a query and streams its result once DuckDB has produced
+// all of it. The query is tracked until the stream ends and interrupted if
+// the client goes away first, but its execution slot is freed as soon as the
+// result is buffered, so a slow reader doesn't hold up other queries. Results
+// over flightMaxRows fail rather than being cut short.
+func streamQuery(ctx context.Context, spec execSpec) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	start := time.Now()
+	version := db.DatasetVersion()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		recordHistory(spec, start, version, 0, false, err)
+		return nil, nil, flightError(err)
+	}
+	stop := context.AfterFunc(ctx, func() { db.Interrupt(spec.ID, db.ErrClientGone) })
+	finish := func(rows int64, err error) {
+		stop()
+		ex.done()
+		if ex.writes {
+			db.BumpVersion()
+		}
+		recordHistory(spec, start, version, int(rows), false, err)
+	}
+
+	if err := ex.admit(); err != nil {
+		finish(0, err)
+		return nil, nil, flightError(err)
+	}
+	sqlText, args := positionalArgs(ex.sql, ex.args)
+	records, err := flightRecords(ex.ctx, sqlText, args)
+	if err != nil {
+		err = queryError(ex.ctx, err)
+		finish(0, err)
+		return nil, nil, flightError(err)
+	}
+	ex.release()
+
+	ch := make(chan flight.StreamChunk)
+	go func() {
+		var rows int64
+		var err error
+		defer func() {
+			records.Release()
+			close(ch)
+			finish(rows, err)
+		}()
+		for records.Next() {
+			rec := records.Record()
+			rec.Retain()
+			select {
+			case ch <- flight.StreamChunk{Data: rec}:
+				rows += rec.NumRows()
+			case <-ctx.Done():
+				rec.Release()
+				err = db.ErrClientGone
+				return
+			}
+		}
+	}()
+	return records.Schema(), ch, nil
+}
+
+// flightRecords runs a statement and returns its result. Statements that
+// don't produce rows return their count of affected rows, as DuckDB does.
+func flightRecords(ctx context.Context, sqlText string, args []interface{}) (array.RecordReader, error) {
+	if _, ok := describeQuery(sqlText); !ok {
+		res, err := db.DB.ExecContext(ctx, sqlText, args...)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := res.RowsAffected()
+		schema := arrow.NewSchema([]arrow.Field{{Name: "Count", Type: arrow.PrimitiveTypes.Int64}}, nil)
+		b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+		defer b.Release()
+		b.Field(0).(*array.Int64Builder).Append(n)
+		rec := b.NewRecord()
+		defer rec.Release()
+		return array.NewRecordReader(schema, []arrow.Record{rec})
+	}
+
+	query := sqltext.Split(sqlText)[0].SQL
+	reader, err := db.QueryArrow(ctx, fmt.Sprintf("SELECT * FROM (%s) AS _q LIMIT %d", query, flightMaxRows+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+	var records []arrow.Record
+	defer func() {
+		for _, rec := range records {
+			rec.Release()
+		}
+	}()
+	var rows int64
+	for reader.Next() {
+		rec := reader.Record()
+		rec.Retain()
+		records = append(records, rec)
+		rows += rec.NumRows()
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	if rows > int64(flightMaxRows) {
+		return nil, fmt.Errorf("%w: more than %d rows; add a LIMIT or raise FLIGHT_MAX_ROWS", errFlightTooLarge, flightMaxRows)
+	}
+	return array.NewRecordReader(reader.Schema(), records)
+}
+
+func (s *flightServer) GetFlightInfoStatement(ctx context.Context, cmd flightsql.StatementQuery, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	schema, err := describeArrow(ctx, cmd.GetQuery())
+	if err != nil {
+		return nil, flightError(err)
+	}
+	ticket, err := flightsql.CreateStatementQueryTicket([]byte(cmd.GetQuery()))
+	if err != nil {
+		return nil, err
+	}
+	return s.flightInfo(desc, ticket, schema), nil
+}
+
+func (s *flightServer) GetSchemaStatement(ctx context.Context, cmd flightsql.StatementQuery, _ *flight.FlightDescriptor) (*flight.SchemaResult, error) {
+	schema, err := describeArrow(ctx, cmd.GetQuery())
+	if err != nil {
+		return nil, flightError(err)
+	}
+	if schema == nil {
+		schema = arrow.NewSchema(nil, nil)
+	}
+	return &flight.SchemaResult{Schema: flight.SerializeSchema(schema, s.Alloc)}, nil
+}
+
+// DoGetStatement runs a query. The ticket handle is the SQL itself, so
+// tickets stay valid across server restarts.
+func (s *flightServer) DoGetStatement(ctx context.Context, ticket flightsql.StatementQueryTicket) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	return streamQuery(ctx, execSpec{
+		ID:      uuid.NewString(),
+		SQL:     string(ticket.GetStatementHandle()),
+		Source:  "flight",
+		Timeout: db.QueryTimeout,
+	})
+}
+
+func (s *flightServer) CreatePreparedStatement(ctx context.Context, req flightsql.ActionCreatePreparedStatementRequest) (flightsql.ActionCreatePreparedStatementResult, error) {
+	var result flightsql.ActionCreatePreparedStatementResult
+	schema, err := describeArrow(ctx, req.GetQuery())
+	if err != nil {
+		return result, flightError(err)
+	}
+	handle := uuid.NewString()
+	s.mu.Lock()
+	s.prepared[handle] = &flightStatement{sql: req.GetQuery()}
+	s.mu.Unlock()
+	result.Handle = []byte(handle)
+	result.DatasetSchema = schema
+	return result, nil
+}
+
+func (s *flightServer) ClosePreparedStatement(_ context.Context, req flightsql.ActionClosePreparedStatementRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle := string(req.GetPreparedStatementHandle())
+	if _, ok := s.prepared[handle]; !ok {
+		return status.Error(codes.NotFound, "prepared statement not found")
+	}
+	delete(s.prepared, handle)
+	return nil
+}
+
+func (s *flightServer) statement(handle []byte) (*flightStatement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmt, ok := s.prepared[string(handle)]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "prepared statement not found")
+	}
+	return stmt, nil
+}
+
+func (s *flightServer) GetFlightInfoPreparedStatement(ctx context.Context, cmd flightsql.PreparedStatementQuery, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	stmt, err := s.statement(cmd.GetPreparedStatementHandle())
+	if err != nil {
+		return nil, err
+	}
+	schema, err := describeArrow(ctx, stmt.sql)
+	if err != nil {
+		return nil, flightError(err)
+	}
+	return s.flightInfo(desc, desc.Cmd, schema), nil
+}
+
+func (s *flightServer) DoGetPreparedStatement(ctx context.Context, cmd flightsql.PreparedStatementQuery) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	stmt, err := s.statement(cmd.GetPreparedStatementHandle())
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	sqlText, params := stmt.sql, stmt.params
+	s.mu.Unlock()
+	return streamQuery(ctx, execSpec{
+		ID:      uuid.NewString(),
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "flight",
+		Timeout: db.QueryTimeout,
+	})
+}
+
+// DoPutPreparedStatementQuery binds parameters to a prepared statement. The
+// client sends them as a record batch with one column per placeholder; only
+// a single row is supported.
+func (s *flightServer) DoPutPreparedStatementQuery(_ context.Context, cmd flightsql.PreparedStatementQuery, r flight.MessageReader, _ flight.MetadataWriter) ([]byte, error) {
+	stmt, err := s.statement(cmd.GetPreparedStatementHandle())
+	if err != nil {
+		return nil, err
+	}
+
+	var params *queryParams
+	for r.Next() {
+		rec := r.Record()
+		if rec.NumRows() == 0 {
+			continue
+		}
+		if params != nil || rec.NumRows() > 1 {
+			return nil, status.Error(codes.InvalidArgument, "only one row of parameters is supported")
+		}
+		params = &queryParams{positional: make([]paramValue, rec.NumCols())}
+		for i, col := range rec.Columns() {
+			if params.positional[i], err = arrowParam(col, 0); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter %d: %v", i+1, err)
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	stmt.params = params
+	s.mu.Unlock()
+	return cmd.GetPreparedStatementHandle(), nil
+}
+
+// arrowParam converts one value of a parameter batch to a typed parameter.
+func arrowParam(arr arrow.Array, i int) (paramValue, error) {
+	if arr.IsNull(i) {
+		return paramValue{Value: json.RawMessage("null")}, nil
+	}
+	var typ string
+	text := arr.ValueStr(i)
+	switch arr.DataType().ID() {
+	case arrow.STRING, arrow.LARGE_STRING, arrow.STRING_VIEW:
+		typ = "VARCHAR"
+	case arrow.BOOL:
+		typ = "BOOLEAN"
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32:
+		typ = "BIGINT"
+	case arrow.UINT64:
+		typ = "HUGEINT"
+	case arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64:
+		typ = "DOUBLE"
+	case arrow.DECIMAL128, arrow.DECIMAL256:
+		typ = "DECIMAL"
+	case arrow.DATE32, arrow.DATE64:
+		typ = "DATE"
+		text = text[:len("2006-01-02")]
+	case arrow.TIMESTAMP:
+		ts := arr.(*array.Timestamp)
+		tt := ts.DataType().(*arrow.TimestampType)
+		toTime, err := tt.GetToTimeFunc()
+		if err != nil {
+			return paramValue{}, err
+		}
+		typ, text = "TIMESTAMP", toTime(ts.Value(i)).Format("2006-01-02 15:04:05.999999999")
+		if tt.TimeZone != "" {
+			typ, text = "TIMESTAMPTZ", toTime(ts.Value(i)).Format(time.RFC3339Nano)
+		}
+	default:
+		return paramValue{}, fmt.Errorf("unsupported type %s", arr.DataType())
+	}
+	value, _ := json.Marshal(text)
+	return paramValue{Value: value, Type: typ}, nil
+}
+
+func (s *flightServer) GetFlightInfoCatalogs(_ context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return s.flightInfo(desc, desc.Cmd, schema_ref.Catalogs), nil
+}
+
+func (s *flightServer) DoGetCatalogs(context.Context) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(s.Alloc, schema_ref.Catalogs)
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).Append(flightCatalog)
+	return recordChunks(b.NewRecord())
+}
+
+func (s *flightServer) GetFlightInfoSchemas(_ context.Context, _ flightsql.GetDBSchemas, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return s.flightInfo(desc, desc.Cmd, schema_ref.DBSchemas), nil
+}
+
+func (s *flightServer) DoGetDBSchemas(_ context.Context, cmd flightsql.GetDBSchemas) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(s.Alloc, schema_ref.DBSchemas)
+	defer b.Release()
+	if catalogMatches(cmd.GetCatalog()) && likeMatches(cmd.GetDBSchemaFilterPattern(), flightSchema) {
+		b.Field(0).(*array.StringBuilder).Append(flightCatalog)
+		b.Field(1).(*array.StringBuilder).Append(flightSchema)
+	}
+	return recordChunks(b.NewRecord())
+}
+
+func (s *flightServer) GetFlightInfoTableTypes(_ context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return s.flightInfo(desc, desc.Cmd, schema_ref.TableTypes), nil
+}
+
+func (s *flightServer) DoGetTableTypes(context.Context) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(s.Alloc, schema_ref.TableTypes)
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).AppendValues([]string{"TABLE", "VIEW"}, nil)
+	return recordChunks(b.NewRecord())
+}
+
+func (s *flightServer) GetFlightInfoTables(_ context.Context, cmd flightsql.GetTables, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	schema := schema_ref.Tables
+	if cmd.GetIncludeSchema() {
+		schema = schema_ref.TablesWithIncludedSchema
+	}
+	return s.flightInfo(desc, desc.Cmd, schema), nil
+}
+
+// DoGetTables lists the tables in the application catalog: the uploaded
+// table and those derived from it.
+func (s *flightServer) DoGetTables(ctx context.Context, cmd flightsql.GetTables) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	schema := schema_ref.Tables
+	if cmd.GetIncludeSchema() {
+		schema = schema_ref.TablesWithIncludedSchema
+	}
+	b := array.NewRecordBuilder(s.Alloc, schema)
+	defer b.Release()
+
+	wantTypes := map[string]bool{}
+	for _, t := range cmd.GetTableTypes() {
+		wantTypes[strings.ToUpper(t)] = true
+	}
+	if !catalogMatches(cmd.GetCatalog()) || !likeMatches(cmd.GetDBSchemaFilterPattern(), flightSchema) {
+		return recordChunks(b.NewRecord())
+	}
+	for _, e := range db.Tables() {
+		tableType := "TABLE"
+		if e.Kind == "view" {
+			tableType = "VIEW"
+		}
+		if len(wantTypes) > 0 && !wantTypes[tableType] {
+			continue
+		}
+		if !likeMatches(cmd.GetTableNameFilterPattern(), e.Name) {
+			continue
+		}
+		b.Field(0).(*array.StringBuilder).Append(flightCatalog)
+		b.Field(1).(*array.StringBuilder).Append(flightSchema)
+		b.Field(2).(*array.StringBuilder).Append(e.Name)
+		b.Field(3).(*array.StringBuilder).Append(tableType)
+		if cmd.GetIncludeSchema() {
+			tableSchema, err := describeArrow(ctx, "SELECT * FROM "+db.QuoteIdent(e.Name))
+			if err != nil {
+				return nil, nil, flightError(err)
+			}
+			b.Field(4).(*array.BinaryBuilder).Append(flight.SerializeSchema(tableSchema, s.Alloc))
+		}
+	}
+	return recordChunks(b.NewRecord())
+}
+
+// recordChunks streams a single record.
+func recordChunks(rec arrow.Record) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	ch := make(chan flight.StreamChunk, 1)
+	ch <- flight.StreamChunk{Data: rec}
+	close(ch)
+	return rec.Schema(), ch, nil
+}
+
+func catalogMatches(catalog *string) bool {
+	return catalog == nil || *catalog == "" || *catalog == flightCatalog
+}
+
+// likeMatches applies a Flight SQL filter pattern, which uses LIKE syntax.
+// A nil pattern matches everything.
+func likeMatches(pattern *string, s string) bool {
+	if pattern == nil {
+		return true
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range *pattern {
+		switch r {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), s)
+	return ok
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Query endpoints can return results in three layouts, chosen with ?format=
+// or the Accept header:
+//
+//   - rows (application/json): the default, one array per row
+//   - columnar (application/vnd.artemis.columnar+json): one array per column
+//     under "data", with the same value encoding as rows
+//   - arrow (application/vnd.apache.arrow.stream): an Arrow IPC stream built
+//     directly from DuckDB's Arrow output, with the query ID in X-Query-Id
+//
+// Errors are always returned as JSON.
+const (
+	formatRows     = "rows"
+	formatColumnar = "columnar"
+	formatArrow    = "arrow"
+
+	columnarMIME = "application/vnd.artemis.columnar+json"
+	arrowMIME    = "application/vnd.apache.arrow.stream"
+)
+
+func resultFormat(c *fiber.Ctx) (string, error) {
+	switch f := c.Query("format"); f {
+	case "":
+	case formatRows, formatColumnar, formatArrow:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q; use rows, columnar or arrow", f)
+	}
+
+	switch c.Accepts(fiber.MIMEApplicationJSON, columnarMIME, arrowMIME) {
+	case columnarMIME:
+		return formatColumnar, nil
+	case arrowMIME:
+		return formatArrow, nil
+	}
+	return formatRows, nil
+}
+
+// serveQuery runs an editor-style query and writes the result in the format
+// the client asked for.
+func serveQuery(c *fiber.Ctx, spec execSpec) error {
+	format, err := resultFormat(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if format == formatArrow {
+		body, err := runArrowQuery(c, spec)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"queryId": spec.ID, "error": err.Error()})
+		}
+		c.Set("X-Query-Id", spec.ID)
+		c.Set(fiber.HeaderContentType, arrowMIME)
+		return c.Send(body)
+	}
+
+	spec.Columnar = format == formatColumnar
+	result, err := runQuery(c, spec)
+	if err != nil || format == formatRows {
+		return sendQueryResult(c, spec.ID, result, err)
+	}
+
+	resp := fiber.Map{
+		"queryId":     spec.ID,
+		"columns":     result.Columns,
+		"columnTypes": result.Types,
+		"rowCount":    result.rowCount(),
+		"data":        result.Data,
+	}
+	if result.Cache != nil {
+		resp["cache"] = result.Cache
+	}
+	return c.JSON(resp, columnarMIME)
+}
+
+// runArrowQuery is runQuery for Arrow output: the same policy, tracking and
+// history, but the result is written straight to an IPC stream. Arrow results
+// bypass the result cache.
+func runArrowQuery(c *fiber.Ctx, spec execSpec) (body []byte, err error) {
+	start := time.Now()
+	version := db.DatasetVersion()
+	var rowCount int64
+	defer func() { recordHistory(spec, start, version, int(rowCount), false, err) }()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer ex.done()
+	if ex.writes {
+		defer db.BumpVersion()
+	}
+
+	stop := watchDisconnect(c, func() { db.Interrupt(spec.ID, db.ErrClientGone) })
+	defer stop()
+	if err := ex.admit(); err != nil {
+		return nil, err
+	}
+
+	sqlText, args := positionalArgs(ex.sql, ex.args)
+	reader, err := db.QueryArrow(ex.ctx, sqlText, args...)
+	if err != nil {
+		return nil, queryError(ex.ctx, err)
+	}
+	defer reader.Release()
+	if ex.ctx.Err() != nil {
+		return nil, context.Cause(ex.ctx)
+	}
+
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(reader.Schema()))
+	for reader.Next() {
+		rec := reader.Record()
+		rowCount += rec.NumRows()
+		if err := w.Write(rec); err != nil {
+			return nil, err
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, queryError(ex.ctx, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
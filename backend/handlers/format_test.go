@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestScanColumnar(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	const query = "SELECT range AS i, CASE WHEN range % 2 = 0 THEN range::VARCHAR END AS s, DATE '2024-01-01' + range::INTEGER AS d FROM range(5)"
+	rows, err := scanQuery(ctx, db.DB, query, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := scanQuery(ctx, db.DB, query, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols.Rows != nil || len(cols.Data) != 3 || cols.rowCount() != 5 {
+		t.Fatalf("got %d columns of %d rows, rows layout %v", len(cols.Data), cols.rowCount(), cols.Rows)
+	}
+	for r, row := range rows.Rows {
+		for i, v := range row {
+			if !reflect.DeepEqual(cols.Data[i][r], v) {
+				t.Errorf("row %d column %d: columnar %#v, rows %#v", r, i, cols.Data[i][r], v)
+			}
+		}
+	}
+
+	empty, err := scanQuery(ctx, db.DB, "SELECT 1 AS a WHERE false", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty.Data) != 1 || empty.Data[0] == nil || empty.rowCount() != 0 {
+		t.Errorf("empty result: %#v", empty.Data)
+	}
+}
@@ -0,0 +1,16 @@
+package handlers
+
+// Init sets up handler state that depends on configuration. It must run after
+// the environment is loaded and the database is initialized.
+func Init() error {
+	if err := initResultCache(); err != nil {
+		return err
+	}
+	if err := initMacros(); err != nil {
+		return err
+	}
+	if err := initStatsCache(); err != nil {
+		return err
+	}
+	return initScheduler()
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"artemisgo/sqltext"
+	"artemisgo/store"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// History searches past query executions. Supported filters: q (SQL text),
+// source, status (ok or error), since and until (RFC 3339), limit and offset.
+func History(c *fiber.Ctx) error {
+	f := store.HistoryFilter{
+		Text:   c.Query("q"),
+		Source: c.Query("source"),
+		Status: c.Query("status"),
+		Limit:  c.QueryInt("limit", 50),
+		Offset: c.QueryInt("offset", 0),
+	}
+	if f.Status != "" && f.Status != "ok" && f.Status != "error" {
+		return c.Status(400).JSON(fiber.Map{"error": "status must be ok or error"})
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 500
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	for param, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": param + " must be an RFC 3339 timestamp"})
+			}
+			*dst = t
+		}
+	}
+
+	entries, total := store.SearchHistory(f)
+	return c.JSON(fiber.Map{"entries": entries, "total": total})
+}
+
+// HistoryEntry returns a single history entry.
+func HistoryEntry(c *fiber.Ctx) error {
+	entry, ok := store.HistoryEntryByID(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "History entry not found"})
+	}
+	return c.JSON(entry)
+}
+
+type rerunRequest struct {
+	QueryID   string `json:"queryId"`
+	TimeoutMs int    `json:"timeoutMs"`
+}
+
+// RerunHistory runs a past query again, with its original parameters, against
+// the current data. A script is run again as a script, in a transaction if
+// it was the first time.
+func RerunHistory(c *fiber.Ctx) error {
+	entry, ok := store.HistoryEntryByID(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "History entry not found"})
+	}
+
+	var req rerunRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	var params *queryParams
+	if len(entry.Params) > 0 {
+		params = &queryParams{}
+		if err := json.Unmarshal(entry.Params, params); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Stored parameters are unreadable"})
+		}
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	// A re-run gets the policy and admission class of the original source
+	source := entry.Source
+	if source == "" {
+		source = "editor"
+	}
+	spec := execSpec{
+		ID:          id,
+		SQL:         entry.SQL,
+		Params:      params,
+		Source:      source,
+		Timeout:     requestTimeout(req.TimeoutMs),
+		RerunOf:     entry.ID,
+		Script:      entry.Script,
+		Transaction: entry.Transaction,
+	}
+	if entry.Script {
+		return serveScript(c, spec, sqltext.Split(entry.SQL))
+	}
+	return serveQuery(c, spec)
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// largeTableRows is the size above which SELECT * without LIMIT is flagged.
+const largeTableRows = 100_000
+
+var (
+	unknownColumnRe = regexp.MustCompile(`Referenced column "([^"]+)" not found|does not have a column named "([^"]+)"`)
+	syntaxNearRe    = regexp.MustCompile(`syntax error at or near "([^"]+)"`)
+)
+
+// Statements worth binding to find unknown columns. Preparing them doesn't
+// execute anything.
+var bindableStatements = map[string]bool{
+	"SELECT": true, "WITH": true, "FROM": true, "VALUES": true,
+	"INSERT": true, "UPDATE": true, "DELETE": true,
+}
+
+type sqlTextRequest struct {
+	SQL string `json:"sql"`
+}
+
+// lintWarning is a problem found in SQL text. Offsets and columns count
+// UTF-16 code units, like the completion endpoint's.
+type lintWarning struct {
+	Rule     string `json:"rule"`     // unknown-column, unquoted-identifier, select-star, cross-join, policy, syntax or invalid
+	Severity string `json:"severity"` // error or warning
+	Message  string `json:"message"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Line     int    `json:"line"`   // 1-based
+	Column   int    `json:"column"` // 1-based
+}
+
+// FormatSQL pretty-prints SQL in the house style.
+func FormatSQL(c *fiber.Ctx) error {
+	var req sqlTextRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	return c.JSON(fiber.Map{"sql": sqltext.Format(req.SQL)})
+}
+
+// Lint checks SQL against the live schema without running it.
+func Lint(c *fiber.Ctx) error {
+	var req sqlTextRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	warnings, err := lintSQL(c.Context(), req.SQL, "editor")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to read schema: %v", err)})
+	}
+	return c.JSON(fiber.Map{"warnings": warnings})
+}
+
+// lintSQL runs every check over each statement of text. Statements are only
+// prepared once they pass the policy of the source that would run them:
+// binding opens the files and URLs a query reads, so preparing a rejected
+// statement would reveal whether they exist and what they hold.
+func lintSQL(ctx context.Context, text, source string) ([]lintWarning, error) {
+	cat, err := loadCompletionCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []lintWarning{}
+	for _, stmt := range sqltext.Split(text) {
+		l := &linter{cat: cat, text: text, offset: stmt.Start, code: sqltext.Code(stmt.SQL)}
+		l.checkSpacedNames()
+		spaced := len(l.warnings) > 0
+		l.checkCrossJoins()
+		l.checkSelectStar()
+		_, err := db.PolicyFor(source).Check(ctx, stmt.SQL)
+		var policyErr *db.PolicyError
+		switch {
+		case errors.As(err, &policyErr) && len(l.code) > 0:
+			l.warn("policy", "error", err.Error(), 0, len(l.code)-1)
+		case spaced:
+			// A name with spaces already breaks parsing or binding; don't
+			// report the same mistake twice
+		case err != nil:
+			l.reportError(err.Error())
+		default:
+			l.checkBinding(ctx, stmt.SQL)
+		}
+		warnings = append(warnings, l.warnings...)
+	}
+	return warnings, nil
+}
+
+type linter struct {
+	cat      *completionCatalog
+	text     string
+	offset   int // of the statement in text
+	code     []sqltext.Token
+	warnings []lintWarning
+}
+
+// warn records a problem spanning code tokens from..to inclusive.
+func (l *linter) warn(rule, severity, message string, from, to int) {
+	start := l.offset + l.code[from].Pos
+	end := l.offset + l.code[to].Pos + len(l.code[to].Text)
+	line := strings.Count(l.text[:start], "\n") + 1
+	lineStart := strings.LastIndex(l.text[:start], "\n") + 1
+	l.warnings = append(l.warnings, lintWarning{
+		Rule:     rule,
+		Severity: severity,
+		Message:  message,
+		Start:    byteToUTF16(l.text, start),
+		End:      byteToUTF16(l.text, end),
+		Line:     line,
+		Column:   utf16Len(l.text[lineStart:start]) + 1,
+	})
+}
+
+// checkSpacedNames finds runs of bare words that spell a column or table
+// name containing spaces, which DuckDB reads as a name and an alias.
+func (l *linter) checkSpacedNames() {
+	names := map[string]string{}
+	for _, t := range l.cat.tables {
+		if strings.Contains(t.Name, " ") {
+			names[strings.ToLower(t.Name)] = t.Name
+		}
+		for _, col := range t.Columns {
+			if strings.Contains(col.Name, " ") {
+				names[strings.ToLower(col.Name)] = col.Name
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	for i := 0; i < len(l.code); i++ {
+		words := []string{}
+		for j := i; j < len(l.code) && l.code[j].Kind == sqltext.Ident; j++ {
+			// Only words separated by plain whitespace
+			if j > i && strings.TrimSpace(l.between(j-1, j)) != "" {
+				break
+			}
+			words = append(words, l.code[j].Text)
+			if j == i {
+				continue
+			}
+			if name, ok := names[strings.ToLower(strings.Join(words, " "))]; ok {
+				l.warn("unquoted-identifier", "error",
+					fmt.Sprintf("%s contains spaces and must be quoted: %s", name, db.QuoteIdent(name)), i, j)
+				i = j
+				break
+			}
+		}
+	}
+}
+
+// between returns the source text between two code tokens.
+func (l *linter) between(a, b int) string {
+	return l.text[l.offset+l.code[a].Pos+len(l.code[a].Text) : l.offset+l.code[b].Pos]
+}
+
+// checkCrossJoins flags comma-separated FROM lists, which join every row
+// with every row unless a WHERE condition happens to connect them.
+func (l *linter) checkCrossJoins() {
+	for i, tok := range l.code {
+		if !tok.IsKeyword("FROM") {
+			continue
+		}
+		depth := 0
+		for j := i + 1; j < len(l.code); j++ {
+			t := l.code[j]
+			if t.Kind == sqltext.Punct && t.Text == "(" {
+				depth++
+			} else if t.Kind == sqltext.Punct && t.Text == ")" {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+			if depth > 0 {
+				continue
+			}
+			if t.Kind == sqltext.Punct && t.Text == ";" || t.Kind == sqltext.Ident && clauseWord(t.Upper()) {
+				break
+			}
+			if t.Kind == sqltext.Punct && t.Text == "," && j+1 < len(l.code) {
+				l.warn("cross-join", "warning",
+					"Implicit cross join: join with JOIN ... ON, or write CROSS JOIN if every combination is intended", j, j+1)
+			}
+		}
+	}
+}
+
+// checkSelectStar flags a top-level SELECT * (or a bare FROM) over a large
+// table with no LIMIT.
+func (l *linter) checkSelectStar() {
+	depth := 0
+	star, limit, selectSeen := -1, false, false
+	for i, tok := range l.code {
+		switch {
+		case tok.Kind == sqltext.Punct && tok.Text == "(":
+			depth++
+		case tok.Kind == sqltext.Punct && tok.Text == ")":
+			depth--
+		case depth > 0:
+		case tok.IsKeyword("SELECT"):
+			selectSeen = true
+			j := i + 1
+			if j < len(l.code) && l.code[j].IsKeyword("DISTINCT") {
+				j++
+			}
+			if j < len(l.code) && l.code[j].Text == "*" && star < 0 {
+				star = j
+			}
+		case tok.IsKeyword("LIMIT"):
+			limit = true
+		}
+	}
+	if len(l.code) > 0 && l.code[0].IsKeyword("FROM") && !selectSeen {
+		star = 0
+	}
+	if star < 0 || limit {
+		return
+	}
+
+	for _, table := range tableScope(l.code) {
+		t := l.cat.table(table)
+		if t == nil || t.Rows < largeTableRows {
+			continue
+		}
+		l.warn("select-star", "warning",
+			fmt.Sprintf("SELECT * over %s (about %d rows) without LIMIT; list the columns you need or add a LIMIT", t.Name, t.Rows), star, star)
+		return
+	}
+}
+
+// checkBinding prepares the statement so DuckDB's binder reports unknown
+// columns and syntax errors, then points at the offending token.
+func (l *linter) checkBinding(ctx context.Context, stmtSQL string) {
+	if !bindableStatements[sqltext.FirstKeyword(stmtSQL)] {
+		return
+	}
+	prepared, err := db.DB.PrepareContext(ctx, stmtSQL)
+	if err == nil {
+		prepared.Close()
+		return
+	}
+	l.reportError(err.Error())
+}
+
+// reportError points a parser or binder error at the offending token.
+func (l *linter) reportError(msg string) {
+	summary, _, _ := strings.Cut(msg, "\n")
+
+	if m := unknownColumnRe.FindStringSubmatch(msg); m != nil {
+		name := m[1] + m[2]
+		found := false
+		for i, tok := range l.code {
+			if (tok.Kind == sqltext.Ident || tok.Kind == sqltext.QuotedIdent) && strings.EqualFold(tok.Name(), name) &&
+				!(i > 0 && l.code[i-1].IsKeyword("AS")) {
+				l.warn("unknown-column", "error", summary, i, i)
+				found = true
+			}
+		}
+		if found {
+			return
+		}
+	}
+	if len(l.code) == 0 {
+		return
+	}
+	rule, at := "invalid", 0
+	if m := syntaxNearRe.FindStringSubmatch(msg); m != nil {
+		rule = "syntax"
+		for i, tok := range l.code {
+			if strings.EqualFold(tok.Text, m[1]) {
+				at = i
+				break
+			}
+		}
+	} else if strings.Contains(msg, "Parser Error") {
+		rule = "syntax"
+	}
+	l.warn(rule, "error", summary, at, at)
+}
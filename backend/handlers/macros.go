@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/store"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// User macros are DuckDB macros kept in a registry, so shared expressions
+// (fiscal quarters, bucketing, parsing) have one definition. The in-memory
+// database loses them on restart, so they are re-created at startup.
+
+// macrosMu serializes changes to macros, which span DuckDB and the store.
+var macrosMu sync.Mutex
+
+type macroRequest struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Params      []string `json:"params"`
+	Body        string   `json:"body"`
+	Description string   `json:"description"`
+}
+
+// initMacros re-creates the registered macros, oldest first so macros that
+// use other macros find them. A macro that no longer binds (say, one whose
+// body reads a table that isn't loaded yet) is logged and skipped.
+func initMacros() error {
+	for _, m := range store.MacrosByCreation() {
+		if _, err := db.DB.Exec(createMacroSQL(m)); err != nil {
+			log.Printf("Macro %s could not be created: %v", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// ListMacros returns every user macro.
+func ListMacros(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"macros": store.ListMacros()})
+}
+
+func GetMacro(c *fiber.Ctx) error {
+	m, ok := store.GetMacro(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Macro not found"})
+	}
+	return c.JSON(m)
+}
+
+func CreateMacro(c *fiber.Ctx) error {
+	var req macroRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	m := store.Macro{ID: uuid.NewString(), Created: time.Now()}
+	return saveMacro(c, nil, m, req)
+}
+
+func UpdateMacro(c *fiber.Ctx) error {
+	m, ok := store.GetMacro(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Macro not found"})
+	}
+	var req macroRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	prev := m
+	return saveMacro(c, &prev, m, req)
+}
+
+// saveMacro validates a macro by creating it in a transaction, then saves it
+// to the registry once the transaction has committed. If the registry can't
+// be saved, the macro is put back as it was, so DuckDB never keeps a macro
+// the registry doesn't know about.
+func saveMacro(c *fiber.Ctx, prev *store.Macro, m store.Macro, req macroRequest) error {
+	m.Name = strings.TrimSpace(req.Name)
+	m.Kind = req.Kind
+	m.Params = req.Params
+	m.Body = strings.TrimSpace(req.Body)
+	m.Description = req.Description
+	m.Updated = time.Now()
+	if m.Kind == "" {
+		m.Kind = "scalar"
+	}
+	if m.Params == nil {
+		m.Params = []string{}
+	}
+
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(c.Context(), db.QueryTimeout)
+	defer cancel()
+	if err := validateMacro(ctx, m); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to save macro: %v", err)})
+	}
+	defer tx.Rollback()
+	if prev != nil && (!strings.EqualFold(prev.Name, m.Name) || prev.Kind != m.Kind) {
+		if _, err := tx.ExecContext(ctx, dropMacroSQL(*prev)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to replace macro: %v", err)})
+		}
+	}
+	if err := createMacro(ctx, tx, m); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to create macro: %v", err)})
+	}
+	if err := store.PutMacro(m); err != nil {
+		restoreMacro(prev, m)
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to save macro: %v", err)})
+	}
+	// Cached results and completions may depend on the old definition
+	db.BumpVersion()
+	return c.JSON(m)
+}
+
+// restoreMacro undoes the creation of m, re-creating prev if m replaced it.
+func restoreMacro(prev *store.Macro, m store.Macro) {
+	if _, err := db.DB.Exec(dropMacroSQL(m)); err != nil {
+		log.Printf("Macro %s could not be dropped: %v", m.Name, err)
+	}
+	if prev == nil {
+		return
+	}
+	if _, err := db.DB.Exec(createMacroSQL(*prev)); err != nil {
+		log.Printf("Macro %s could not be re-created: %v", prev.Name, err)
+	}
+}
+
+func DeleteMacro(c *fiber.Ctx) error {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	m, ok, err := store.DeleteMacro(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to delete macro: %v", err)})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Macro not found"})
+	}
+	if _, err := db.DB.Exec(dropMacroSQL(m)); err != nil {
+		log.Printf("Macro %s could not be dropped: %v", m.Name, err)
+	}
+	db.BumpVersion()
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+// validateMacro checks the name, parameters and body before the macro is
+// created. The body runs wherever the macro is called, out of sight of the
+// policy check on the calling query, so it must pass the read-only policy
+// itself.
+func validateMacro(ctx context.Context, m store.Macro) error {
+	if !variableNameRe.MatchString(m.Name) {
+		return fmt.Errorf("name must be an identifier (letters, digits and underscores)")
+	}
+	if other, ok := store.FindMacro(m.Name); ok && other.ID != m.ID {
+		return fmt.Errorf("a macro named %s already exists", other.Name)
+	}
+	var builtin int
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT count(*) FROM duckdb_functions() WHERE internal AND lower(function_name) = lower(?)", m.Name).Scan(&builtin)
+	if err != nil {
+		return err
+	}
+	if builtin > 0 {
+		return fmt.Errorf("%s is a built-in function", m.Name)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range m.Params {
+		if !variableNameRe.MatchString(p) {
+			return fmt.Errorf("invalid parameter name %q", p)
+		}
+		if seen[strings.ToLower(p)] {
+			return fmt.Errorf("parameter %s is listed twice", p)
+		}
+		seen[strings.ToLower(p)] = true
+	}
+
+	if err := checkExpr("body", m.Body); err != nil {
+		return err
+	}
+	check := m.Body
+	switch m.Kind {
+	case "scalar":
+		check = "SELECT (" + m.Body + ")"
+	case "table":
+		if _, ok := describeQuery(m.Body); !ok {
+			return fmt.Errorf("the body of a table macro must be a query")
+		}
+	default:
+		return fmt.Errorf("kind must be \"scalar\" or \"table\"")
+	}
+	if _, err := db.PolicyFor("macros").Check(ctx, check); err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+	return nil
+}
+
+// createMacro creates m in tx and binds a call to it, so mistakes in the body
+// show up now rather than in every query that uses it. A table macro over a
+// table that isn't loaded yet is accepted.
+func createMacro(ctx context.Context, tx *sql.Tx, m store.Macro) error {
+	if _, err := tx.ExecContext(ctx, createMacroSQL(m)); err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+
+	args := make([]string, len(m.Params))
+	for i := range args {
+		args[i] = "NULL"
+	}
+	call := db.QuoteIdent(m.Name) + "(" + strings.Join(args, ", ") + ")"
+	probe := "SELECT " + call
+	if m.Kind == "table" {
+		probe = "SELECT * FROM " + call
+	}
+	stmt, err := tx.PrepareContext(ctx, probe)
+	if err != nil {
+		if m.Kind == "table" && strings.HasPrefix(err.Error(), "Catalog Error: Table with name") {
+			return nil
+		}
+		return fmt.Errorf("body: %v", err)
+	}
+	stmt.Close()
+	return nil
+}
+
+func createMacroSQL(m store.Macro) string {
+	head := "CREATE OR REPLACE MACRO " + db.QuoteIdent(m.Name) + "(" + strings.Join(m.Params, ", ") + ")"
+	if m.Kind == "table" {
+		return head + " AS TABLE " + m.Body
+	}
+	return head + " AS (" + m.Body + ")"
+}
+
+func dropMacroSQL(m store.Macro) string {
+	if m.Kind == "table" {
+		return "DROP MACRO TABLE IF EXISTS " + db.QuoteIdent(m.Name)
+	}
+	return "DROP MACRO IF EXISTS " + db.QuoteIdent(m.Name)
+}
+
+// buildMacrosContext lists the user macros for the chat prompt.
+func buildMacrosContext() string {
+	var sb strings.Builder
+	for _, m := range store.ListMacros() {
+		sig := m.Name + "(" + strings.Join(m.Params, ", ") + ")"
+		if m.Kind == "table" {
+			fmt.Fprintf(&sb, "\n  table macro %s AS TABLE %s", sig, m.Body)
+		} else {
+			fmt.Fprintf(&sb, "\n  macro %s = %s", sig, m.Body)
+		}
+		if m.Description != "" {
+			sb.WriteString(" -- " + m.Description)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/store"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openMacroStore opens an empty store, dropping macros an earlier test left
+// registered.
+func openMacroStore(t *testing.T) {
+	t.Helper()
+	openTestStore(t)
+	for _, m := range store.ListMacros() {
+		if _, _, err := store.DeleteMacro(m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidateMacro(t *testing.T) {
+	openTestDB(t)
+	openMacroStore(t)
+	if err := store.PutMacro(store.Macro{ID: "m1", Name: "fiscal_quarter", Kind: "scalar", Params: []string{"d"}, Body: "quarter(d + INTERVAL 3 MONTH)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		m       store.Macro
+		wantErr string
+	}{
+		{store.Macro{Name: "bucket", Kind: "scalar", Params: []string{"x", "size"}, Body: "floor(x / size) * size"}, ""},
+		{store.Macro{Name: "recent", Kind: "table", Params: []string{"n"}, Body: "SELECT * FROM range(n)"}, ""},
+		{store.Macro{ID: "m1", Name: "FISCAL_QUARTER", Kind: "scalar", Params: []string{"d"}, Body: "quarter(d)"}, ""},
+		{store.Macro{Name: "fiscal_quarter", Kind: "scalar", Body: "1"}, "already exists"},
+		{store.Macro{Name: "bad name", Kind: "scalar", Body: "1"}, "must be an identifier"},
+		{store.Macro{Name: "sum", Kind: "scalar", Params: []string{"x"}, Body: "x"}, "built-in function"},
+		{store.Macro{Name: "m", Kind: "scalar", Params: []string{"x", "X"}, Body: "x"}, "listed twice"},
+		{store.Macro{Name: "m", Kind: "scalar", Params: []string{"1x"}, Body: "1"}, "invalid parameter name"},
+		{store.Macro{Name: "m", Kind: "view", Body: "1"}, "kind must be"},
+		{store.Macro{Name: "m", Kind: "table", Body: "1 + 1"}, "must be a query"},
+		{store.Macro{Name: "m", Kind: "scalar", Body: "1; DROP TABLE t"}, "can't contain ;"},
+		{store.Macro{Name: "m", Kind: "scalar", Body: "1 -- x"}, "comments"},
+		// Bodies run out of sight of the policy check on the calling query,
+		// so ones that read files are rejected
+		{store.Macro{Name: "m", Kind: "scalar", Body: "(SELECT count(*) FROM read_csv('/etc/passwd'))"}, "not allowed"},
+		{store.Macro{Name: "m", Kind: "scalar", Body: `(SELECT count(*) FROM "read_csv"('/etc/passwd'))`}, "not allowed"},
+		{store.Macro{Name: "m", Kind: "table", Body: "SELECT * FROM '/etc/passwd'"}, "not allowed"},
+		{store.Macro{Name: "m", Kind: "table", Params: []string{"p"}, Body: `SELECT * FROM "read_text"(p)`}, "not allowed"},
+		{store.Macro{Name: "m", Kind: "scalar", Params: []string{"p"}, Body: "(SELECT content FROM read_text(p))"}, "not allowed"},
+	}
+	for _, tt := range tests {
+		err := validateMacro(context.Background(), tt.m)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s %q: unexpected error %v", tt.m.Name, tt.m.Body, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s %q: got %v, want an error containing %q", tt.m.Name, tt.m.Body, err, tt.wantErr)
+		}
+	}
+}
+
+func macroRequestTo(t *testing.T, app *fiber.App, method, target, body string) (int, store.Macro) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m store.Macro
+	json.NewDecoder(resp.Body).Decode(&m)
+	return resp.StatusCode, m
+}
+
+func TestSaveMacroKeepsDuckDBInStep(t *testing.T) {
+	openTestDB(t)
+	openMacroStore(t)
+	app := fiber.New()
+	app.Post("/api/macros", CreateMacro)
+	app.Put("/api/macros/:id", UpdateMacro)
+
+	call := func(sql string) (int64, error) {
+		var n int64
+		err := db.DB.QueryRow(sql).Scan(&n)
+		return n, err
+	}
+
+	status, m := macroRequestTo(t, app, "POST", "/api/macros", `{"name": "scale", "params": ["x"], "body": "x * 2"}`)
+	if status != 200 {
+		t.Fatalf("create: status %d", status)
+	}
+	if n, err := call("SELECT scale(21)"); err != nil || n != 42 {
+		t.Fatalf("scale(21) = %d, %v", n, err)
+	}
+	// A body that doesn't bind is rejected and leaves the macro as it was
+	if status, _ := macroRequestTo(t, app, "PUT", "/api/macros/"+m.ID, `{"name": "scale", "params": ["x"], "body": "x * missing"}`); status != 400 {
+		t.Errorf("bad body: status %d, want 400", status)
+	}
+	if n, err := call("SELECT scale(21)"); err != nil || n != 42 {
+		t.Errorf("after a rejected update, scale(21) = %d, %v", n, err)
+	}
+
+	// With the registry unwritable, neither a new macro nor a change to an
+	// existing one may stay in DuckDB
+	if err := os.RemoveAll(store.Dir()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := macroRequestTo(t, app, "POST", "/api/macros", `{"name": "halve", "params": ["x"], "body": "x / 2"}`); status != 500 {
+		t.Errorf("create without a registry: status %d, want 500", status)
+	}
+	if _, err := call("SELECT halve(4)"); err == nil {
+		t.Error("halve exists in DuckDB but not in the registry")
+	}
+	if status, _ := macroRequestTo(t, app, "PUT", "/api/macros/"+m.ID, `{"name": "scale", "params": ["x"], "body": "x * 3"}`); status != 500 {
+		t.Errorf("update without a registry: status %d, want 500", status)
+	}
+	if n, err := call("SELECT scale(21)"); err != nil || n != 42 {
+		t.Errorf("after a failed update, scale(21) = %d, %v", n, err)
+	}
+	if status, _ := macroRequestTo(t, app, "PUT", "/api/macros/"+m.ID, `{"name": "rescale", "params": ["x"], "body": "x * 3"}`); status != 500 {
+		t.Errorf("rename without a registry: status %d, want 500", status)
+	}
+	if n, err := call("SELECT scale(21)"); err != nil || n != 42 {
+		t.Errorf("after a failed rename, scale(21) = %d, %v", n, err)
+	}
+	if _, err := call("SELECT rescale(21)"); err == nil {
+		t.Error("rescale exists in DuckDB but not in the registry")
+	}
+	if got, _ := store.GetMacro(m.ID); got.Body != "x * 2" {
+		t.Errorf("registry has %q", got.Body)
+	}
+}
+
+func TestInitMacrosOrder(t *testing.T) {
+	openTestDB(t)
+	openMacroStore(t)
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Names sort the other way round from creation, so only creation order
+	// has each macro's dependency in place
+	macros := []store.Macro{
+		{ID: "1", Name: "z_inner", Kind: "scalar", Params: []string{"x"}, Body: "x + 1"},
+		{ID: "2", Name: "m_broken", Kind: "scalar", Body: "no_such_function(1)"},
+		{ID: "3", Name: "a_outer", Kind: "scalar", Params: []string{"x"}, Body: "z_inner(x) * 10"},
+		{ID: "4", Name: "a_rows", Kind: "table", Params: []string{"n"}, Body: "SELECT a_outer(range) AS v FROM range(n)"},
+	}
+	for i, m := range macros {
+		m.Created = created.Add(time.Duration(i) * time.Hour)
+		if err := store.PutMacro(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := initMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int64
+	if err := db.DB.QueryRow("SELECT sum(v) FROM a_rows(3)").Scan(&sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 60 {
+		t.Errorf("sum(v) = %d, want 60", sum)
+	}
+	// A macro that doesn't bind is skipped, not fatal
+	if _, err := db.DB.Exec("SELECT m_broken()"); err == nil {
+		t.Error("m_broken was created")
+	}
+}
@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"artemisgo/store"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// The metrics layer gives business metrics one definition. A model names
+// measures (aggregates), dimensions (row expressions, optionally time
+// dimensions with grains) and filters over a table; metric queries pick from
+// them and are compiled to SQL, and the chat prompt lists the same
+// definitions so generated SQL agrees with them.
+
+// timeGrains are the date_trunc parts a time dimension can be truncated to.
+var timeGrains = []string{"second", "minute", "hour", "day", "week", "month", "quarter", "year"}
+
+type metricModelRequest struct {
+	Name        string               `json:"name"`
+	Table       string               `json:"table"`
+	Description string               `json:"description"`
+	Measures    []store.Measure      `json:"measures"`
+	Dimensions  []store.Dimension    `json:"dimensions"`
+	Filters     []store.MetricFilter `json:"filters"`
+}
+
+// ListMetricModels returns every metric model.
+func ListMetricModels(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"models": store.ListMetricModels()})
+}
+
+func GetMetricModel(c *fiber.Ctx) error {
+	m, ok := store.GetMetricModel(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Metric model not found"})
+	}
+	return c.JSON(m)
+}
+
+func CreateMetricModel(c *fiber.Ctx) error {
+	var req metricModelRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	m := store.MetricModel{ID: uuid.NewString(), Created: time.Now()}
+	return saveMetricModel(c, m, req)
+}
+
+func UpdateMetricModel(c *fiber.Ctx) error {
+	m, ok := store.GetMetricModel(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Metric model not found"})
+	}
+	var req metricModelRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	return saveMetricModel(c, m, req)
+}
+
+func saveMetricModel(c *fiber.Ctx, m store.MetricModel, req metricModelRequest) error {
+	m.Name = strings.TrimSpace(req.Name)
+	m.Table = req.Table
+	m.Description = req.Description
+	m.Measures = req.Measures
+	m.Dimensions = req.Dimensions
+	m.Filters = req.Filters
+	m.Updated = time.Now()
+	if m.Table == "" {
+		m.Table = db.TableName
+	}
+	if m.Measures == nil {
+		m.Measures = []store.Measure{}
+	}
+	if m.Dimensions == nil {
+		m.Dimensions = []store.Dimension{}
+	}
+	if m.Filters == nil {
+		m.Filters = []store.MetricFilter{}
+	}
+
+	if err := validateMetricModel(c.Context(), m); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := store.PutMetricModel(m); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to save metric model: %v", err)})
+	}
+	return c.JSON(m)
+}
+
+func DeleteMetricModel(c *fiber.Ctx) error {
+	ok, err := store.DeleteMetricModel(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to delete metric model: %v", err)})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Metric model not found"})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+// validateMetricModel checks names and expressions. If the table is loaded,
+// each expression is also prepared against it, so mistakes such as a measure
+// that isn't an aggregate are caught when the model is saved rather than when
+// it is queried.
+func validateMetricModel(ctx context.Context, m store.MetricModel) error {
+	if !variableNameRe.MatchString(m.Name) {
+		return fmt.Errorf("name must be an identifier (letters, digits and underscores)")
+	}
+	if other, ok := store.FindMetricModel(m.Name); ok && other.ID != m.ID {
+		return fmt.Errorf("a metric model named %s already exists", other.Name)
+	}
+	if !db.ValidTableName(m.Table) {
+		return fmt.Errorf("invalid table name %q", m.Table)
+	}
+	if len(m.Measures) == 0 && len(m.Dimensions) == 0 {
+		return fmt.Errorf("a model needs at least one measure or dimension")
+	}
+
+	// Measures and dimensions share the result's column names
+	outputs := map[string]bool{}
+	checkName := func(kind, name string, seen map[string]bool) error {
+		if !variableNameRe.MatchString(name) {
+			return fmt.Errorf("invalid %s name %q", kind, name)
+		}
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("%s is defined twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+		return nil
+	}
+	for _, ms := range m.Measures {
+		if err := checkName("measure", ms.Name, outputs); err != nil {
+			return err
+		}
+		if err := checkExpr(ms.Name, ms.Expr); err != nil {
+			return err
+		}
+	}
+	for _, d := range m.Dimensions {
+		if err := checkName("dimension", d.Name, outputs); err != nil {
+			return err
+		}
+		if err := checkExpr(d.Name, d.Expr); err != nil {
+			return err
+		}
+		switch d.Type {
+		case "":
+			if len(d.Grains) > 0 {
+				return fmt.Errorf("dimension %s: grains are only allowed on time dimensions", d.Name)
+			}
+		case "time":
+			for _, g := range d.Grains {
+				if !containsFold(timeGrains, g) {
+					return fmt.Errorf("dimension %s: unknown grain %q; use one of %s", d.Name, g, strings.Join(timeGrains, ", "))
+				}
+			}
+		default:
+			return fmt.Errorf("dimension %s: type must be \"time\" or empty", d.Name)
+		}
+	}
+	filters := map[string]bool{}
+	for _, f := range m.Filters {
+		if err := checkName("filter", f.Name, filters); err != nil {
+			return err
+		}
+		if err := checkExpr(f.Name, f.Expr); err != nil {
+			return err
+		}
+	}
+
+	entry, ok := db.LookupTable(m.Table)
+	if !ok {
+		return nil
+	}
+	from := " FROM " + db.QuoteIdent(entry.Name)
+	type probe struct{ what, sql string }
+	var probes []probe
+	for _, ms := range m.Measures {
+		probes = append(probes, probe{"measure " + ms.Name, "SELECT (" + ms.Expr + ")" + from + " GROUP BY ()"})
+	}
+	for _, d := range m.Dimensions {
+		expr := "(" + d.Expr + ")"
+		if d.Type == "time" {
+			expr = "date_trunc('day', " + expr + ")"
+		}
+		probes = append(probes, probe{"dimension " + d.Name, "SELECT " + expr + from + " GROUP BY 1"})
+	}
+	for _, f := range m.Filters {
+		probes = append(probes, probe{"filter " + f.Name, "SELECT count(*)" + from + " WHERE (" + f.Expr + ")"})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, db.QueryTimeout)
+	defer cancel()
+	for _, p := range probes {
+		if _, err := db.PolicyFor("metrics").Check(ctx, p.sql); err != nil {
+			return fmt.Errorf("%s: %v", p.what, err)
+		}
+		stmt, err := db.DB.PrepareContext(ctx, p.sql)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.what, err)
+		}
+		stmt.Close()
+	}
+	return nil
+}
+
+// checkExpr makes sure an expression can't escape the place it is spliced
+// into: no statement separators, comments or unbalanced parentheses.
+func checkExpr(name, expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return fmt.Errorf("%s: expr is required", name)
+	}
+	depth := 0
+	for _, tok := range sqltext.Tokenize(expr) {
+		switch {
+		case tok.Kind == sqltext.Comment:
+			return fmt.Errorf("%s: comments aren't allowed in expressions", name)
+		case tok.Kind == sqltext.Punct && tok.Text == ";":
+			return fmt.Errorf("%s: expressions can't contain ;", name)
+		case tok.Kind == sqltext.Punct && tok.Text == "(":
+			depth++
+		case tok.Kind == sqltext.Punct && tok.Text == ")":
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%s: unbalanced parentheses", name)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("%s: unbalanced parentheses", name)
+	}
+	return nil
+}
+
+// metricsQuery asks for measures of a model, broken down by dimensions.
+type metricsQuery struct {
+	Model      string        `json:"model"`
+	Measures   []string      `json:"measures"`
+	Dimensions []string      `json:"dimensions"` // name, or name:grain for time dimensions
+	Filters    []string      `json:"filters"`    // names of the model's filters
+	Where      []queryFilter `json:"where"`      // conditions on dimensions, as in structured queries
+	Sort       []querySort   `json:"sort"`       // by measure or dimension name
+	Limit      *int          `json:"limit"`
+}
+
+type metricsRequest struct {
+	metricsQuery
+	QueryID   string `json:"queryId"`
+	TimeoutMs int    `json:"timeoutMs"`
+	NoCache   bool   `json:"noCache"`
+	DryRun    bool   `json:"dryRun"` // compile only
+}
+
+// QueryMetrics compiles a metrics query to SQL and runs it. Like structured
+// queries, the response carries the generated SQL and parameters.
+func QueryMetrics(c *fiber.Ctx) error {
+	var req metricsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	sqlText, params, err := compileMetrics(req.metricsQuery)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.DryRun {
+		return c.JSON(fiber.Map{"sql": sqlText, "params": params})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	result, err := runQuery(c, execSpec{
+		ID:      id,
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "metrics",
+		Timeout: requestTimeout(req.TimeoutMs),
+		NoCache: req.NoCache,
+	})
+	if err != nil {
+		return c.Status(errorStatus(err)).JSON(fiber.Map{"queryId": id, "sql": sqlText, "params": params, "error": err.Error()})
+	}
+	resp := fiber.Map{
+		"queryId":     id,
+		"sql":         sqlText,
+		"params":      params,
+		"columns":     result.Columns,
+		"columnTypes": result.Types,
+		"rows":        result.Rows,
+	}
+	if result.Cache != nil {
+		resp["cache"] = result.Cache
+	}
+	return c.JSON(resp)
+}
+
+// compileMetrics builds the SQL for a metrics query from the model's
+// definitions. Values in where conditions become named parameters.
+func compileMetrics(q metricsQuery) (string, *queryParams, error) {
+	m, ok := store.FindMetricModel(q.Model)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown metric model %q", q.Model)
+	}
+	entry, ok := db.LookupTable(m.Table)
+	if !ok {
+		return "", nil, fmt.Errorf("table %s of model %s is not loaded", m.Table, m.Name)
+	}
+	if len(q.Measures) == 0 && len(q.Dimensions) == 0 {
+		return "", nil, fmt.Errorf("ask for at least one measure or dimension")
+	}
+
+	qc := &structuredCompiler{params: map[string]paramValue{}, exprs: map[string]namedExpr{}}
+	dims := map[string]store.Dimension{}
+	for _, d := range m.Dimensions {
+		dims[strings.ToLower(d.Name)] = d
+		qc.exprs[strings.ToLower(d.Name)] = namedExpr{name: d.Name, sql: d.Expr}
+	}
+	measures := map[string]store.Measure{}
+	for _, ms := range m.Measures {
+		measures[strings.ToLower(ms.Name)] = ms
+	}
+	filters := map[string]store.MetricFilter{}
+	for _, f := range m.Filters {
+		filters[strings.ToLower(f.Name)] = f
+	}
+
+	outputs := map[string]string{} // lower-case name -> name as declared
+	addOutput := func(name string) error {
+		if _, dup := outputs[strings.ToLower(name)]; dup {
+			return fmt.Errorf("%s is requested twice", name)
+		}
+		outputs[strings.ToLower(name)] = name
+		return nil
+	}
+
+	var selectList, groups []string
+	for _, spec := range q.Dimensions {
+		name, grain, _ := strings.Cut(spec, ":")
+		d, ok := dims[strings.ToLower(name)]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown dimension %q in model %s", name, m.Name)
+		}
+		expr := "(" + d.Expr + ")"
+		if grain != "" {
+			grain = strings.ToLower(grain)
+			if d.Type != "time" {
+				return "", nil, fmt.Errorf("dimension %s is not a time dimension and has no grains", d.Name)
+			}
+			allowed := d.Grains
+			if len(allowed) == 0 {
+				allowed = timeGrains
+			}
+			if !containsFold(allowed, grain) {
+				return "", nil, fmt.Errorf("dimension %s can't be truncated to %s; use one of %s", d.Name, grain, strings.Join(allowed, ", "))
+			}
+			expr = fmt.Sprintf("date_trunc('%s', %s)", grain, expr)
+		}
+		if err := addOutput(d.Name); err != nil {
+			return "", nil, err
+		}
+		selectList = append(selectList, expr+" AS "+db.QuoteIdent(d.Name))
+		groups = append(groups, fmt.Sprint(len(selectList)))
+	}
+	for _, name := range q.Measures {
+		ms, ok := measures[strings.ToLower(name)]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown measure %q in model %s", name, m.Name)
+		}
+		if err := addOutput(ms.Name); err != nil {
+			return "", nil, err
+		}
+		selectList = append(selectList, "("+ms.Expr+") AS "+db.QuoteIdent(ms.Name))
+	}
+
+	var conds []string
+	for _, name := range q.Filters {
+		f, ok := filters[strings.ToLower(name)]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown filter %q in model %s", name, m.Name)
+		}
+		conds = append(conds, "("+f.Expr+")")
+	}
+	if len(q.Where) > 0 {
+		cond, err := qc.conjunction(q.Where, " AND ")
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(selectList, ", "))
+	sb.WriteString("\nFROM " + db.QuoteIdent(entry.Name))
+	if len(conds) > 0 {
+		sb.WriteString("\nWHERE " + strings.Join(conds, " AND "))
+	}
+	if len(groups) > 0 {
+		sb.WriteString("\nGROUP BY " + strings.Join(groups, ", "))
+	}
+
+	// Without an explicit order, results come out by dimension
+	var keys []string
+	for _, s := range q.Sort {
+		name, ok := outputs[strings.ToLower(s.Column)]
+		if !ok {
+			return "", nil, fmt.Errorf("can't sort by %s: it is not a requested measure or dimension", s.Column)
+		}
+		key := db.QuoteIdent(name)
+		if s.Desc {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		keys = groups
+	}
+	if len(keys) > 0 {
+		sb.WriteString("\nORDER BY " + strings.Join(keys, ", "))
+	}
+
+	limit := maxStructuredLimit
+	if q.Limit != nil {
+		if *q.Limit < 0 || *q.Limit > maxStructuredLimit {
+			return "", nil, fmt.Errorf("limit must be between 0 and %d", maxStructuredLimit)
+		}
+		limit = *q.Limit
+	}
+	fmt.Fprintf(&sb, "\nLIMIT %d", limit)
+
+	var params *queryParams
+	if len(qc.params) > 0 {
+		params = &queryParams{named: qc.params}
+	}
+	return sb.String(), params, nil
+}
+
+// buildMetricsContext lists the metric definitions for the chat prompt, or
+// returns "" if there are none.
+func buildMetricsContext() string {
+	models := store.ListMetricModels()
+	if len(models) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, m := range models {
+		fmt.Fprintf(&sb, "\nModel %s over table %s", m.Name, db.QuoteIdent(m.Table))
+		if m.Description != "" {
+			sb.WriteString(": " + m.Description)
+		}
+		sb.WriteString("\n")
+		for _, ms := range m.Measures {
+			fmt.Fprintf(&sb, "  measure %s = %s", ms.Name, ms.Expr)
+			writeDescription(&sb, ms.Description)
+		}
+		for _, d := range m.Dimensions {
+			fmt.Fprintf(&sb, "  dimension %s = %s", d.Name, d.Expr)
+			if d.Type == "time" {
+				sb.WriteString(" (time")
+				if len(d.Grains) > 0 {
+					sb.WriteString("; grains: " + strings.Join(d.Grains, ", "))
+				}
+				sb.WriteString(")")
+			}
+			writeDescription(&sb, d.Description)
+		}
+		for _, f := range m.Filters {
+			fmt.Fprintf(&sb, "  filter %s: WHERE %s", f.Name, f.Expr)
+			writeDescription(&sb, f.Description)
+		}
+	}
+	return sb.String()
+}
+
+func writeDescription(sb *strings.Builder, description string) {
+	if description != "" {
+		sb.WriteString(" -- " + description)
+	}
+	sb.WriteString("\n")
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/store"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// openTestStore points the store at an empty data directory.
+func openTestStore(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompileMetrics(t *testing.T) {
+	openTestDB(t)
+	openTestStore(t)
+	if _, err := db.CreateDerived(context.Background(), "orders", "table", `SELECT * FROM (VALUES
+		(TIMESTAMP '2024-03-04 10:00', 'eu', 'done', 10.0),
+		(TIMESTAMP '2024-03-06 12:00', 'eu', 'done', 20.0),
+		(TIMESTAMP '2024-03-12 09:00', 'us', 'open', 5.0),
+		(TIMESTAMP '2024-03-13 18:00', 'us', 'done', 7.5)) AS v(placed_at, region, status, amount)`, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutMetricModel(store.MetricModel{
+		ID:    "m1",
+		Name:  "orders",
+		Table: "orders",
+		Measures: []store.Measure{
+			{Name: "revenue", Expr: "sum(amount)"},
+			{Name: "order_count", Expr: "count(*)"},
+		},
+		Dimensions: []store.Dimension{
+			{Name: "region", Expr: "region"},
+			{Name: "placed", Expr: "placed_at", Type: "time", Grains: []string{"day", "week"}},
+			{Name: "any_grain", Expr: "placed_at", Type: "time"},
+		},
+		Filters: []store.MetricFilter{{Name: "completed", Expr: "status = 'done'"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantSQL string // the compiled SQL, or part of the error
+		rows    int
+		wantErr bool
+	}{
+		{
+			name:  "time dimension with a grain",
+			query: `{"model": "orders", "measures": ["revenue"], "dimensions": ["placed:week"]}`,
+			wantSQL: "SELECT date_trunc('week', (placed_at)) AS \"placed\", (sum(amount)) AS \"revenue\"\nFROM \"orders\"\n" +
+				"GROUP BY 1\nORDER BY 1\nLIMIT 10000",
+			rows: 2,
+		},
+		{
+			name:  "grain on a dimension without a grain list",
+			query: `{"model": "orders", "measures": ["order_count"], "dimensions": ["any_grain:MONTH"]}`,
+			wantSQL: "SELECT date_trunc('month', (placed_at)) AS \"any_grain\", (count(*)) AS \"order_count\"\nFROM \"orders\"\n" +
+				"GROUP BY 1\nORDER BY 1\nLIMIT 10000",
+			rows: 1,
+		},
+		{
+			name:    "disallowed grain",
+			query:   `{"model": "orders", "measures": ["revenue"], "dimensions": ["placed:month"]}`,
+			wantSQL: "placed can't be truncated to month; use one of day, week",
+			wantErr: true,
+		},
+		{
+			name:    "grain on a plain dimension",
+			query:   `{"model": "orders", "dimensions": ["region:day"]}`,
+			wantSQL: "region is not a time dimension",
+			wantErr: true,
+		},
+		{
+			name:    "sort by an undeclared name",
+			query:   `{"model": "orders", "measures": ["revenue"], "dimensions": ["region"], "sort": [{"column": "amount"}]}`,
+			wantSQL: "can't sort by amount",
+			wantErr: true,
+		},
+		{
+			name:    "sort by a model name that wasn't requested",
+			query:   `{"model": "orders", "measures": ["revenue"], "sort": [{"column": "region"}]}`,
+			wantSQL: "can't sort by region",
+			wantErr: true,
+		},
+		{
+			name: "named filters and where",
+			query: `{"model": "orders", "measures": ["revenue"], "dimensions": ["region"], "filters": ["completed"],
+				"where": [{"column": "region", "op": "eq", "value": "us"}], "sort": [{"column": "REVENUE", "desc": true}], "limit": 5}`,
+			wantSQL: "SELECT (region) AS \"region\", (sum(amount)) AS \"revenue\"\nFROM \"orders\"\n" +
+				"WHERE (status = 'done') AND (region) = $p1\nGROUP BY 1\nORDER BY \"revenue\" DESC\nLIMIT 5",
+			rows: 1,
+		},
+		{
+			name:    "unknown filter",
+			query:   `{"model": "orders", "measures": ["revenue"], "filters": ["cancelled"]}`,
+			wantSQL: `unknown filter "cancelled"`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate dimension",
+			query:   `{"model": "orders", "dimensions": ["region", "REGION"]}`,
+			wantSQL: "region is requested twice",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate dimension at another grain",
+			query:   `{"model": "orders", "dimensions": ["placed:day", "placed:week"]}`,
+			wantSQL: "placed is requested twice",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate measure",
+			query:   `{"model": "orders", "measures": ["revenue", "revenue"]}`,
+			wantSQL: "revenue is requested twice",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		var q metricsQuery
+		if err := json.Unmarshal([]byte(tt.query), &q); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		sqlText, params, err := compileMetrics(q)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), tt.wantSQL) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantSQL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if sqlText != tt.wantSQL {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, sqlText, tt.wantSQL)
+		}
+		ex, err := startQuery(execSpec{SQL: sqlText, Params: params, Source: "metrics", Timeout: db.QueryTimeout})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		result, err := queryRows(ex.ctx, db.DB, ex.sql, ex.args)
+		ex.done()
+		if err != nil || len(result.Rows) != tt.rows {
+			t.Errorf("%s: got %v, %v; want %d rows", tt.name, result, err, tt.rows)
+		}
+	}
+}
+
+func TestCheckExpr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"sum(amount)", ""},
+		{"count(*) FILTER (WHERE status = 'done')", ""},
+		{"status = ';'", ""},
+		{"amount - -1", ""},
+		{"coalesce(region, '(none')", ""},
+		{"", "expr is required"},
+		{"sum(amount); DROP TABLE orders", "can't contain ;"},
+		{"sum(amount) -- ", "comments"},
+		{"sum(amount) /* x */", "comments"},
+		{"sum(amount))", "unbalanced"},
+		{"sum((amount)", "unbalanced"},
+		{"amount) OR (1 = 1", "unbalanced"},
+	}
+	for _, tt := range tests {
+		err := checkExpr("m", tt.expr)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%q: got %v, want an error containing %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
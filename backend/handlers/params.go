@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"artemisgo/sqltext"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// queryParams holds bind values sent with a query: a JSON array binds ? and
+// $1-style placeholders by position, a JSON object binds $name placeholders.
+// Each value is either a plain JSON scalar or {"value": ..., "type": "DATE"}
+// to request a specific coercion.
+type queryParams struct {
+	positional []paramValue
+	named      map[string]paramValue
+}
+
+type paramValue struct {
+	Value json.RawMessage `json:"value"`
+	Type  string          `json:"type,omitempty"`
+}
+
+func (p *queryParams) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		return json.Unmarshal(data, &p.positional)
+	case len(data) > 0 && data[0] == '{':
+		return json.Unmarshal(data, &p.named)
+	}
+	return fmt.Errorf("params must be an array or an object")
+}
+
+func (p *queryParams) MarshalJSON() ([]byte, error) {
+	if p.named != nil {
+		return json.Marshal(p.named)
+	}
+	return json.Marshal(p.positional)
+}
+
+func (v *paramValue) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		type typed paramValue
+		return json.Unmarshal(data, (*typed)(v))
+	}
+	v.Value = append(json.RawMessage(nil), trimmed...)
+	return nil
+}
+
+func (p *queryParams) empty() bool {
+	return p == nil || (len(p.positional) == 0 && len(p.named) == 0)
+}
+
+// paramError reports a problem with the supplied parameters; handlers return
+// it to the client as a 400.
+type paramError struct {
+	msg string
+}
+
+func (e *paramError) Error() string { return e.msg }
+
+func paramErrorf(format string, args ...interface{}) error {
+	return &paramError{msg: fmt.Sprintf(format, args...)}
+}
+
+// bind matches the parameters to the placeholders in sqlText and converts them
+// to driver arguments. The driver binds dates, times, decimals and UUIDs as
+// VARCHAR, which DuckDB won't compare with a DATE or DECIMAL column, so the
+// returned SQL casts their placeholders to the requested type.
+func (p *queryParams) bind(sqlText string) (string, []interface{}, error) {
+	positional, names := placeholders(sqlText)
+
+	if p.empty() {
+		switch {
+		case positional > 0:
+			return "", nil, paramErrorf("query has %d positional parameter(s) but no params were given", positional)
+		case len(names) > 0:
+			return "", nil, paramErrorf("missing value for parameter $%s", names[0])
+		}
+		return sqlText, nil, nil
+	}
+
+	if p.named == nil {
+		if len(names) > 0 {
+			return "", nil, paramErrorf("query uses named parameters ($%s); pass params as an object", names[0])
+		}
+		if len(p.positional) != positional {
+			return "", nil, paramErrorf("query has %d positional parameter(s) but %d were given", positional, len(p.positional))
+		}
+		args := make([]interface{}, len(p.positional))
+		casts := make([]string, len(p.positional))
+		for i, v := range p.positional {
+			val, err := v.coerce()
+			if err != nil {
+				return "", nil, paramErrorf("parameter %d: %v", i+1, err)
+			}
+			args[i], casts[i] = val, v.castType(val)
+		}
+		return castPlaceholders(sqlText, casts, nil), args, nil
+	}
+
+	if positional > 0 {
+		return "", nil, paramErrorf("query uses positional parameters; pass params as an array")
+	}
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for name := range p.named {
+		if !known[name] {
+			return "", nil, paramErrorf("unknown parameter $%s", name)
+		}
+	}
+	args := make([]interface{}, 0, len(names))
+	casts := make(map[string]string, len(names))
+	for _, name := range names {
+		v, ok := p.named[name]
+		if !ok {
+			return "", nil, paramErrorf("missing value for parameter $%s", name)
+		}
+		val, err := v.coerce()
+		if err != nil {
+			return "", nil, paramErrorf("parameter $%s: %v", name, err)
+		}
+		args = append(args, sql.Named(name, val))
+		casts[name] = v.castType(val)
+	}
+	return castPlaceholders(sqlText, nil, casts), args, nil
+}
+
+// castPlaceholders wraps placeholders in CAST(... AS type), taking the type of
+// ? and $N from positional and of $name from named. Placeholders with no type
+// are left alone.
+func castPlaceholders(sqlText string, positional []string, named map[string]string) string {
+	var sb strings.Builder
+	last, anonymous := 0, 0
+	for _, tok := range sqltext.Code(sqlText) {
+		if tok.Kind != sqltext.Param {
+			continue
+		}
+		var typ string
+		if tok.Text == "?" {
+			if anonymous < len(positional) {
+				typ = positional[anonymous]
+			}
+			anonymous++
+		} else if n, err := strconv.Atoi(tok.Text[1:]); err == nil {
+			if n >= 1 && n <= len(positional) {
+				typ = positional[n-1]
+			}
+		} else {
+			typ = named[tok.Text[1:]]
+		}
+		if typ != "" {
+			sb.WriteString(sqlText[last:tok.Pos])
+			fmt.Fprintf(&sb, "CAST(%s AS %s)", tok.Text, typ)
+			last = tok.Pos + len(tok.Text)
+		}
+	}
+	sb.WriteString(sqlText[last:])
+	return sb.String()
+}
+
+// placeholders counts the positional placeholders (? or $N) in sqlText and
+// lists its distinct named ones, in sorted order.
+func placeholders(sqlText string) (int, []string) {
+	anonymous, maxOrdinal := 0, 0
+	seen := map[string]bool{}
+	var names []string
+	for _, tok := range sqltext.Code(sqlText) {
+		if tok.Kind != sqltext.Param {
+			continue
+		}
+		if tok.Text == "?" {
+			anonymous++
+			continue
+		}
+		name := tok.Text[1:]
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > maxOrdinal {
+				maxOrdinal = n
+			}
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return anonymous + maxOrdinal, names
+}
+
+var paramTypes = map[string]bool{
+	"": true, "VARCHAR": true, "TEXT": true, "STRING": true,
+	"INTEGER": true, "INT": true, "BIGINT": true, "SMALLINT": true, "TINYINT": true, "HUGEINT": true,
+	"DOUBLE": true, "REAL": true, "FLOAT": true, "DECIMAL": true, "NUMERIC": true,
+	"BOOLEAN": true, "BOOL": true, "DATE": true, "TIMESTAMP": true, "DATETIME": true, "TIMESTAMPTZ": true,
+	"TIME": true, "UUID": true,
+}
+
+// coerce converts a JSON value to a Go value for the driver. Untyped numbers
+// become int64 when integral and float64 otherwise. Typed values are checked
+// against their type; dates, times, decimals and UUIDs become canonical
+// strings, which bind casts in the SQL (see castType).
+func (v paramValue) coerce() (interface{}, error) {
+	typ := strings.ToUpper(strings.TrimSpace(v.Type))
+	if !paramTypes[typ] {
+		return nil, fmt.Errorf("unsupported parameter type %q", v.Type)
+	}
+
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(v.Value))
+	dec.UseNumber()
+	if len(v.Value) > 0 {
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.([]interface{}); ok {
+		return nil, fmt.Errorf("list values are not supported")
+	}
+	if _, ok := raw.(map[string]interface{}); ok {
+		return nil, fmt.Errorf("object values are not supported")
+	}
+
+	text := fmt.Sprint(raw)
+	switch typ {
+	case "":
+		if n, ok := raw.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+		return raw, nil
+	case "VARCHAR", "TEXT", "STRING":
+		return text, nil
+	case "INTEGER", "INT", "BIGINT", "SMALLINT", "TINYINT":
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %q as %s", text, typ)
+		}
+		return i, nil
+	case "HUGEINT":
+		i, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return nil, fmt.Errorf("cannot use %q as %s", text, typ)
+		}
+		return i, nil
+	case "DOUBLE", "REAL", "FLOAT":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %q as %s", text, typ)
+		}
+		return f, nil
+	case "DECIMAL", "NUMERIC":
+		d, ok := decimalText(text)
+		if !ok {
+			return nil, fmt.Errorf("cannot use %q as %s", text, typ)
+		}
+		return d, nil
+	case "BOOLEAN", "BOOL":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %q as %s", text, typ)
+		}
+		return b, nil
+	case "DATE":
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %q as DATE (want YYYY-MM-DD)", text)
+		}
+		return t.Format("2006-01-02"), nil
+	case "TIMESTAMP", "DATETIME", "TIMESTAMPTZ":
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				if typ == "TIMESTAMPTZ" {
+					return t.Format(time.RFC3339Nano), nil
+				}
+				return t.Format("2006-01-02 15:04:05.999999999"), nil
+			}
+		}
+		return nil, fmt.Errorf("cannot use %q as %s (want RFC 3339 or YYYY-MM-DD HH:MM:SS)", text, typ)
+	case "TIME":
+		if _, err := time.Parse("15:04:05.999999999", text); err != nil {
+			return nil, fmt.Errorf("cannot use %q as TIME (want HH:MM:SS)", text)
+		}
+		return text, nil
+	case "UUID":
+		u, err := uuid.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %q as UUID", text)
+		}
+		return u.String(), nil
+	default:
+		return nil, fmt.Errorf("unsupported parameter type %q", v.Type)
+	}
+}
+
+// castType returns the SQL type a coerced value's placeholder is cast to, or
+// "" if the driver binds it with the right type already. A decimal gets the
+// precision and scale of its digits, so none are lost to DECIMAL's default
+// scale of 3.
+func (v paramValue) castType(val interface{}) string {
+	switch typ := strings.ToUpper(strings.TrimSpace(v.Type)); typ {
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ", "TIME", "UUID":
+		return typ
+	case "DATETIME":
+		return "TIMESTAMP"
+	case "DECIMAL", "NUMERIC":
+		d, _ := val.(string)
+		whole, frac, _ := strings.Cut(strings.TrimPrefix(d, "-"), ".")
+		precision, scale := len(whole)+len(frac), len(frac)
+		if precision == 0 {
+			return "DECIMAL"
+		}
+		return fmt.Sprintf("DECIMAL(%d,%d)", min(precision, maxDecimalDigits), scale)
+	}
+	return ""
+}
+
+const maxDecimalDigits = 38
+
+// decimalText returns text as an exact decimal, without exponent, or false if
+// it isn't one DuckDB can hold.
+func decimalText(text string) (string, bool) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return "", false
+	}
+	scaled := new(big.Rat).Set(r)
+	for scale := 0; scale <= maxDecimalDigits; scale++ {
+		if scaled.IsInt() {
+			d := r.FloatString(scale)
+			digits := strings.TrimLeft(strings.Replace(strings.TrimPrefix(d, "-"), ".", "", 1), "0")
+			return d, len(digits) <= maxDecimalDigits
+		}
+		scaled.Mul(scaled, big.NewRat(10, 1))
+	}
+	return "", false
+}
+
+// positionalArgs rewrites $name placeholders as $1, $2, ... for callers that
+// can only bind by position, such as the Arrow interface. Named arguments from
+// bind are in sorted name order, which fixes their positions.
+func positionalArgs(sqlText string, args []interface{}) (string, []interface{}) {
+	ordinals := map[string]int{}
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		named, ok := arg.(sql.NamedArg)
+		if !ok {
+			return sqlText, args
+		}
+		ordinals[named.Name] = i + 1
+		values[i] = named.Value
+	}
+	if len(ordinals) == 0 {
+		return sqlText, args
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, tok := range sqltext.Code(sqlText) {
+		if tok.Kind != sqltext.Param || len(tok.Text) < 2 {
+			continue
+		}
+		if n, ok := ordinals[tok.Text[1:]]; ok {
+			sb.WriteString(sqlText[last:tok.Pos])
+			sb.WriteString("$" + strconv.Itoa(n))
+			last = tok.Pos + len(tok.Text)
+		}
+	}
+	sb.WriteString(sqlText[last:])
+	return sb.String(), values
+}
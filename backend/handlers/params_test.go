@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestParamValueCoerce(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    interface{}
+		wantErr bool
+	}{
+		{`42`, int64(42), false},
+		{`4.5`, 4.5, false},
+		{`"text"`, "text", false},
+		{`true`, true, false},
+		{`null`, nil, false},
+		{`[1, 2]`, nil, true},
+		{`{"value": 7, "type": "varchar"}`, "7", false},
+		{`{"value": "12", "type": "INTEGER"}`, int64(12), false},
+		{`{"value": "1.5", "type": "INTEGER"}`, nil, true},
+		{`{"value": "170141183460469231731687303715884105727", "type": "HUGEINT"}`,
+			func() *big.Int {
+				i, _ := new(big.Int).SetString("170141183460469231731687303715884105727", 10)
+				return i
+			}(), false},
+		{`{"value": "2.50", "type": "DOUBLE"}`, 2.5, false},
+		{`{"value": "12.345", "type": "DECIMAL"}`, "12.345", false},
+		{`{"value": "12,3", "type": "DECIMAL"}`, nil, true},
+		{`{"value": 1e2, "type": "DECIMAL"}`, "100", false},
+		{`{"value": "1/3", "type": "DECIMAL"}`, nil, true},
+		{`{"value": "true", "type": "BOOLEAN"}`, true, false},
+		{`{"value": "2024-02-29", "type": "DATE"}`, "2024-02-29", false},
+		{`{"value": "2023-02-29", "type": "DATE"}`, nil, true},
+		{`{"value": "2024-03-01T10:30:00Z", "type": "TIMESTAMP"}`, "2024-03-01 10:30:00", false},
+		{`{"value": "2024-03-01 10:30:00", "type": "TIMESTAMPTZ"}`, "2024-03-01T10:30:00Z", false},
+		{`{"value": "2024-03-01", "type": "TIMESTAMP"}`, "2024-03-01 00:00:00", false},
+		{`{"value": "25:00:00", "type": "TIME"}`, nil, true},
+		{`{"value": "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11", "type": "UUID"}`, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", false},
+		{`{"value": null, "type": "DATE"}`, nil, false},
+		{`{"value": 1, "type": "BLOB"}`, nil, true},
+	}
+	for _, tt := range tests {
+		var v paramValue
+		if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+			t.Fatalf("%s: %v", tt.json, err)
+		}
+		got, err := v.coerce()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want an error, got %#v", tt.json, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.json, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestBindTypedParams(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.DB.Exec(`CREATE TABLE sales AS SELECT * FROM (VALUES
+		(DATE '2024-02-28', 9.99::DECIMAL(10,2), TIMESTAMP '2024-02-28 23:00:00'),
+		(DATE '2024-03-01', 10.50::DECIMAL(10,2), TIMESTAMP '2024-03-01 08:30:00'),
+		(DATE '2024-03-15', 120.00::DECIMAL(10,2), TIMESTAMP '2024-03-15 12:00:00')) AS v(day, amount, at)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql    string
+		params string
+		want   int
+	}{
+		{"SELECT * FROM sales WHERE day >= ?", `[{"value": "2024-03-01", "type": "DATE"}]`, 2},
+		{"SELECT * FROM sales WHERE day BETWEEN $1 AND $2", `[{"value": "2024-02-01", "type": "date"}, {"value": "2024-03-01", "type": "DATE"}]`, 2},
+		{"SELECT * FROM sales WHERE amount > $min", `{"min": {"value": "10.5", "type": "DECIMAL"}}`, 1},
+		{"SELECT * FROM sales WHERE amount = $a OR amount = $a", `{"a": {"value": 10.50, "type": "NUMERIC"}}`, 1},
+		{"SELECT * FROM sales WHERE amount < ?", `[{"value": "9.991", "type": "DECIMAL"}]`, 1},
+		{"SELECT * FROM sales WHERE at < ?", `[{"value": "2024-03-01T09:00:00Z", "type": "TIMESTAMP"}]`, 2},
+		{"SELECT * FROM sales WHERE day = ? OR ? IS NULL", `[{"value": null, "type": "DATE"}, {"value": null, "type": "DATE"}]`, 3},
+		{"SELECT * FROM sales WHERE amount >= ?", `[10]`, 2},
+	}
+	for _, tt := range tests {
+		var params queryParams
+		if err := json.Unmarshal([]byte(tt.params), &params); err != nil {
+			t.Fatal(err)
+		}
+		ex, err := startQuery(execSpec{SQL: tt.sql, Params: &params, Source: "editor", Timeout: db.QueryTimeout})
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		result, err := queryRows(ex.ctx, db.DB, ex.sql, ex.args)
+		ex.done()
+		if err != nil {
+			t.Errorf("%s: %s: %v", tt.sql, ex.sql, err)
+			continue
+		}
+		if len(result.Rows) != tt.want {
+			t.Errorf("%s: got %d rows, want %d", ex.sql, len(result.Rows), tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/pgwire"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+)
+
+// WireBackend runs statements for the PostgreSQL protocol listener. They take
+// the same path as editor queries under the "pgwire" source: the policy check,
+// admission, tracking, the result cache and history.
+type WireBackend struct{}
+
+func (WireBackend) Query(conn net.Conn, id, sqlText string, args []pgwire.Arg) (*pgwire.Result, error) {
+	params, err := wireParams(sqlText, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := runQueryConn(conn, execSpec{
+		ID:      id,
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "pgwire",
+		Timeout: db.QueryTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pgwire.Result{Columns: result.Columns, Types: result.Types, Rows: result.Rows}, nil
+}
+
+// Describe reports the columns of a query without running it.
+func (WireBackend) Describe(sqlText string, nargs int) (*pgwire.Result, error) {
+	probe, ok := describeQuery(sqlText)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.QueryTimeout)
+	defer cancel()
+	if _, err := db.PolicyFor("pgwire").Check(ctx, sqlText); err != nil {
+		return nil, err
+	}
+	rows, err := db.DB.QueryContext(ctx, probe, make([]interface{}, nargs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := columnTypeNames(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &pgwire.Result{Columns: columns, Types: types}, nil
+}
+
+func (WireBackend) Cancel(id string) {
+	db.Interrupt(id, db.ErrQueryCanceled)
+}
+
+// SQLState maps an error to the closest PostgreSQL error code.
+func (WireBackend) SQLState(err error) string {
+	var policyErr *db.PolicyError
+	var paramErr *paramError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &policyErr):
+		return "42501" // insufficient_privilege
+	case errors.As(err, &paramErr):
+		return "22023" // invalid_parameter_value
+	case errors.Is(err, db.ErrQueryCanceled), errors.Is(err, db.ErrQueryTimeout), errors.Is(err, db.ErrClientGone):
+		return "57014" // query_canceled
+	case strings.Contains(msg, "Parser Error"):
+		return "42601" // syntax_error
+	case strings.Contains(msg, "Catalog Error"):
+		return "42P01" // undefined_table
+	case strings.Contains(msg, "Binder Error"):
+		return "42703" // undefined_column
+	case strings.Contains(msg, "Conversion Error"):
+		return "22P02" // invalid_text_representation
+	}
+	return "XX000"
+}
+
+// wireParams binds protocol parameters by position. Parameters the client
+// sent without a type arrive as text; they are converted to the type DuckDB
+// expects in their place, since it won't compare a VARCHAR with a number.
+func wireParams(sqlText string, args []pgwire.Arg) (*queryParams, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	var inferred []string
+	looked := false
+	params := &queryParams{positional: make([]paramValue, len(args))}
+	for i, arg := range args {
+		value := json.RawMessage("null")
+		if !arg.Null {
+			value, _ = json.Marshal(arg.Text)
+		}
+		typ := arg.Type
+		if typ == "" && !arg.Null {
+			if !looked {
+				ctx, cancel := context.WithTimeout(context.Background(), db.QueryTimeout)
+				types, err := db.ParamTypes(ctx, sqlText)
+				cancel()
+				if err != nil {
+					return nil, err
+				}
+				inferred, looked = types, true
+			}
+			if i < len(inferred) {
+				typ = inferred[i]
+			}
+		}
+		params.positional[i] = paramValue{Value: value, Type: typ}
+	}
+	return params, nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"artemisgo/sqltext"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// PlanNode is one operator of a query plan. Actual figures are only present
+// for EXPLAIN ANALYZE.
+type PlanNode struct {
+	Operator             string                 `json:"operator"`
+	EstimatedCardinality *int64                 `json:"estimatedCardinality,omitempty"`
+	ActualCardinality    *int64                 `json:"actualCardinality,omitempty"`
+	RowsScanned          *int64                 `json:"rowsScanned,omitempty"`
+	TimingMs             *float64               `json:"timingMs,omitempty"`
+	Details              map[string]interface{} `json:"details"`
+	Children             []*PlanNode            `json:"children"`
+}
+
+type queryPlan struct {
+	Analyzed      bool      `json:"analyzed"`
+	TotalTimingMs *float64  `json:"totalTimingMs,omitempty"`
+	Root          *PlanNode `json:"root"`
+}
+
+type planRequest struct {
+	SQL       string       `json:"sql"`
+	Params    *queryParams `json:"params"`
+	Analyze   bool         `json:"analyze"`
+	QueryID   string       `json:"queryId"`
+	TimeoutMs int          `json:"timeoutMs"`
+}
+
+// Plan runs EXPLAIN (or EXPLAIN ANALYZE, which executes the query) and returns
+// the plan as an operator tree.
+func Plan(c *fiber.Ctx) error {
+	var req planRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	if req.SQL == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "SQL query is required"})
+	}
+	if err := checkExplainable(req.SQL); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	plan, err := explainQuery(c, execSpec{
+		ID:      id,
+		SQL:     req.SQL,
+		Params:  req.Params,
+		Source:  "editor",
+		Timeout: requestTimeout(req.TimeoutMs),
+	}, req.Analyze)
+	if err != nil {
+		return c.Status(errorStatus(err)).JSON(fiber.Map{"queryId": id, "error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"queryId": id, "plan": plan})
+}
+
+// explainQuery wraps spec.SQL in EXPLAIN with JSON output and parses the
+// result. It goes through runQuery, so the explained statement is subject to
+// the same policy, limits and history as a normal execution.
+func explainQuery(c *fiber.Ctx, spec execSpec, analyze bool) (*queryPlan, error) {
+	if err := checkExplainable(spec.SQL); err != nil {
+		return nil, err
+	}
+
+	options := "FORMAT JSON"
+	if analyze {
+		options = "ANALYZE, FORMAT JSON"
+	}
+	spec.SQL = fmt.Sprintf("EXPLAIN (%s) %s", options, strings.TrimRight(strings.TrimSpace(spec.SQL), ";"))
+	spec.NoCache = true
+
+	result, err := runQuery(c, spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Rows) == 0 || len(result.Rows[0]) < 2 {
+		return nil, fmt.Errorf("EXPLAIN returned no plan")
+	}
+	raw, ok := result.Rows[0][1].(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected EXPLAIN output")
+	}
+	return parsePlan(raw, analyze)
+}
+
+// parsePlan builds the operator tree from EXPLAIN's JSON output.
+func parsePlan(raw string, analyze bool) (*queryPlan, error) {
+	if !analyze {
+		var nodes []rawPlanNode
+		if err := json.Unmarshal([]byte(raw), &nodes); err != nil {
+			return nil, fmt.Errorf("failed to parse plan: %w", err)
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("EXPLAIN returned no plan")
+		}
+		return &queryPlan{Root: nodes[0].toPlanNode()}, nil
+	}
+
+	var profile rawPlanNode
+	if err := json.Unmarshal([]byte(raw), &profile); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	// The profile root is the query itself, with the EXPLAIN_ANALYZE operator
+	// as its only child; the real plan starts below that.
+	root := &profile
+	for len(root.Children) == 1 && (root.OperatorType == "" || root.OperatorType == "EXPLAIN_ANALYZE") {
+		root = &root.Children[0]
+	}
+	plan := &queryPlan{Analyzed: true, Root: root.toPlanNode()}
+	total := sumTiming(plan.Root)
+	plan.TotalTimingMs = &total
+	return plan, nil
+}
+
+func checkExplainable(sqlText string) error {
+	if n := len(sqltext.Split(sqlText)); n != 1 {
+		return fmt.Errorf("plan requires exactly one statement, got %d", n)
+	}
+	if sqltext.FirstKeyword(sqlText) == "EXPLAIN" {
+		return fmt.Errorf("pass the query without EXPLAIN")
+	}
+	return nil
+}
+
+// rawPlanNode covers both DuckDB JSON shapes: EXPLAIN emits name/extra_info,
+// EXPLAIN ANALYZE emits operator_type with profiling counters.
+type rawPlanNode struct {
+	Name                string                 `json:"name"`
+	OperatorType        string                 `json:"operator_type"`
+	OperatorCardinality *int64                 `json:"operator_cardinality"`
+	OperatorRowsScanned *int64                 `json:"operator_rows_scanned"`
+	OperatorTiming      *float64               `json:"operator_timing"`
+	ExtraInfo           map[string]interface{} `json:"extra_info"`
+	Children            []rawPlanNode          `json:"children"`
+}
+
+func (r *rawPlanNode) toPlanNode() *PlanNode {
+	n := &PlanNode{
+		Operator:          strings.TrimSpace(r.Name),
+		ActualCardinality: r.OperatorCardinality,
+		RowsScanned:       r.OperatorRowsScanned,
+		Details:           map[string]interface{}{},
+		Children:          make([]*PlanNode, 0, len(r.Children)),
+	}
+	if r.OperatorType != "" {
+		n.Operator = r.OperatorType
+	}
+	if r.OperatorTiming != nil {
+		ms := *r.OperatorTiming * 1000
+		n.TimingMs = &ms
+	}
+	for k, v := range r.ExtraInfo {
+		if k == "Estimated Cardinality" {
+			if s, ok := v.(string); ok {
+				if est, err := strconv.ParseInt(strings.TrimPrefix(s, "~"), 10, 64); err == nil {
+					n.EstimatedCardinality = &est
+					continue
+				}
+			}
+		}
+		n.Details[k] = v
+	}
+	for i := range r.Children {
+		n.Children = append(n.Children, r.Children[i].toPlanNode())
+	}
+	return n
+}
+
+func sumTiming(n *PlanNode) float64 {
+	var total float64
+	if n.TimingMs != nil {
+		total = *n.TimingMs
+	}
+	for _, child := range n.Children {
+		total += sumTiming(child)
+	}
+	return total
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"testing"
+)
+
+func findOperator(n *PlanNode, op string) *PlanNode {
+	if n.Operator == op {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := findOperator(child, op); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestParsePlan(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.DB.Exec(`CREATE TABLE a AS SELECT range AS id FROM range(100);
+		CREATE TABLE b AS SELECT range AS id, range * 2 AS v FROM range(50)`); err != nil {
+		t.Fatal(err)
+	}
+	const query = "SELECT a.id, sum(b.v) FROM a JOIN b ON a.id = b.id GROUP BY a.id"
+
+	for _, analyze := range []bool{false, true} {
+		// EXPLAIN names scans by operator, the profile by operator type
+		options, scan := "FORMAT JSON", "SEQ_SCAN"
+		if analyze {
+			options, scan = "ANALYZE, FORMAT JSON", "TABLE_SCAN"
+		}
+		var key, raw string
+		if err := db.DB.QueryRow("EXPLAIN ("+options+") "+query).Scan(&key, &raw); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := parsePlan(raw, analyze)
+		if err != nil {
+			t.Fatalf("analyze=%v: %v", analyze, err)
+		}
+		if plan.Analyzed != analyze || plan.Root.Operator != "PROJECTION" {
+			t.Errorf("analyze=%v: got analyzed=%v, root %q", analyze, plan.Analyzed, plan.Root.Operator)
+		}
+
+		join := findOperator(plan.Root, "HASH_JOIN")
+		if join == nil {
+			t.Fatalf("analyze=%v: no HASH_JOIN in the plan", analyze)
+		}
+		if len(join.Children) != 2 {
+			t.Fatalf("analyze=%v: HASH_JOIN has %d children", analyze, len(join.Children))
+		}
+		scanned := map[interface{}]bool{}
+		for _, child := range join.Children {
+			if child.Operator != scan || len(child.Children) != 0 {
+				t.Errorf("analyze=%v: join child %q with %d children", analyze, child.Operator, len(child.Children))
+			}
+			scanned[child.Details["Text"]] = true
+			if child.EstimatedCardinality == nil {
+				t.Errorf("analyze=%v: %v has no estimated cardinality", analyze, child.Details["Text"])
+			}
+		}
+		if !scanned["a"] || !scanned["b"] {
+			t.Errorf("analyze=%v: join scans %v, want a and b", analyze, scanned)
+		}
+		if findOperator(plan.Root, "PERFECT_HASH_GROUP_BY") == nil && findOperator(plan.Root, "HASH_GROUP_BY") == nil {
+			t.Errorf("analyze=%v: no GROUP BY operator", analyze)
+		}
+
+		if analyze {
+			if join.ActualCardinality == nil || *join.ActualCardinality != 50 || join.TimingMs == nil {
+				t.Errorf("HASH_JOIN figures: cardinality %v, timing %v", join.ActualCardinality, join.TimingMs)
+			}
+			if plan.TotalTimingMs == nil || *plan.TotalTimingMs <= 0 {
+				t.Errorf("total timing %v", plan.TotalTimingMs)
+			}
+		} else if join.ActualCardinality != nil || plan.TotalTimingMs != nil {
+			t.Error("EXPLAIN without ANALYZE has actual figures")
+		}
+	}
+}
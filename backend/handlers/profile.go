@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// Profiling reads each table in at most three scans per batch of columns,
+// however many columns it has:
+//
+//  1. one aggregate query computes every column's summary statistics
+//  2. one query computes every numeric histogram with DuckDB's histogram
+//     aggregate, using the boundaries chosen from the summaries
+//  3. one GROUPING SETS query counts values per group for every column that
+//     needs it: top values, date buckets, weekdays and hours
+//
+// Columns are batched because exact quantiles and distinct counts hold their
+// values in memory, for every column of the query at once.
+const profileBatchColumns = 32
+
+// columnProfile accumulates the stats of one column over the passes.
+type columnProfile interface {
+	// aggregates returns the column's expressions for the first pass and the
+	// scan targets for their values.
+	aggregates() ([]string, []interface{})
+	// plan asks for histograms and group counts from the later passes, once
+	// the first pass has filled in the aggregates.
+	plan(p *profilePlan)
+	// result returns the stats and the distribution after the passes.
+	result() (fiber.Map, []fiber.Map)
+}
+
+// histogramRequest counts a numeric expression in buckets given by their
+// ascending lower boundaries. Each bucket holds the values from its boundary
+// up to the next one; the last holds the rest. counts stays nil if the pass
+// fails.
+type histogramRequest struct {
+	expr   string
+	lower  []float64
+	counts []int
+}
+
+// groupRequest counts the rows per value of an expression, keeping the limit
+// most frequent non-null values. rows stays nil if the pass fails.
+type groupRequest struct {
+	expr  string
+	limit int
+	rows  []groupRow
+}
+
+type groupRow struct {
+	key   interface{}
+	count int
+}
+
+type profilePlan struct {
+	histograms []*histogramRequest
+	groups     []*groupRequest
+}
+
+func (p *profilePlan) histogram(expr string, lower []float64) *histogramRequest {
+	h := &histogramRequest{expr: expr, lower: lower}
+	p.histograms = append(p.histograms, h)
+	return h
+}
+
+func (p *profilePlan) group(expr string, limit int) *groupRequest {
+	g := &groupRequest{expr: expr, limit: limit}
+	p.groups = append(p.groups, g)
+	return g
+}
+
+type profiledColumn struct {
+	colMeta
+	profile columnProfile
+	ok      bool // the first pass succeeded
+}
+
+type profiler struct {
+	ctx      context.Context
+	table    string
+	rowCount int
+	queries  int
+}
+
+// profileTable computes the /api/stats response for a table.
+func profileTable(ctx context.Context, tableName string, opts statsOptions) (fiber.Map, error) {
+	start := time.Now()
+	table := db.QuoteIdent(tableName)
+	cols, err := describeProfiled(ctx, table, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cols == nil {
+		// Nothing loaded yet
+		return fiber.Map{
+			"rowCount":    0,
+			"columnCount": 0,
+			"columns":     []fiber.Map{},
+		}, nil
+	}
+
+	p := &profiler{ctx: ctx, table: table}
+	if err := p.profile(cols); err != nil {
+		return nil, err
+	}
+
+	columns := make([]fiber.Map, 0, len(cols))
+	for _, col := range cols {
+		entry := fiber.Map{"name": col.Name, "type": col.Type}
+		if col.ok {
+			entry["stats"], entry["distribution"] = col.profile.result()
+		}
+		columns = append(columns, entry)
+	}
+	log.Printf("Stats: %s, %d columns in %d queries (%.1fs)", tableName, len(cols), p.queries, time.Since(start).Seconds())
+
+	return fiber.Map{
+		"table":       tableName,
+		"rowCount":    p.rowCount,
+		"columnCount": len(columns),
+		"columns":     columns,
+	}, nil
+}
+
+// describeProfiled lists a table's columns with an empty profile each, or
+// nil if the table can't be described.
+func describeProfiled(ctx context.Context, table string, opts statsOptions) ([]*profiledColumn, error) {
+	rows, err := db.DB.QueryContext(ctx, fmt.Sprintf("DESCRIBE %s", table))
+	if err != nil {
+		return nil, nil
+	}
+	defer rows.Close()
+
+	var cols []*profiledColumn
+	for rows.Next() {
+		var name, colType string
+		var isNull, key, defaultVal, extra sql.NullString
+		if err := rows.Scan(&name, &colType, &isNull, &key, &defaultVal, &extra); err != nil {
+			return nil, err
+		}
+		col := colMeta{Name: name, Type: db.SimpleType(colType)}
+		cols = append(cols, &profiledColumn{colMeta: col, profile: newColumnProfile(col, opts)})
+	}
+	return cols, rows.Err()
+}
+
+// profile runs the passes over cols in batches.
+func (p *profiler) profile(cols []*profiledColumn) error {
+	for i := 0; i < len(cols); i += profileBatchColumns {
+		p.run(cols[i:min(i+profileBatchColumns, len(cols))])
+	}
+	return p.ctx.Err()
+}
+
+// run profiles a batch of columns. If a query fails, the batch is retried one
+// column at a time, so a column DuckDB can't profile only loses its own stats.
+func (p *profiler) run(cols []*profiledColumn) {
+	if err := p.runBatch(cols, len(cols) == 1); err != nil && len(cols) > 1 && p.ctx.Err() == nil {
+		for _, col := range cols {
+			p.run([]*profiledColumn{col})
+		}
+	}
+}
+
+// runBatch runs the passes over cols. When lenient, a failed histogram or
+// group pass leaves the distributions empty instead of failing the batch.
+func (p *profiler) runBatch(cols []*profiledColumn, lenient bool) error {
+	exprs := []string{"COUNT(*)"}
+	targets := []interface{}{&p.rowCount}
+	for _, col := range cols {
+		col.ok = false
+		e, t := col.profile.aggregates()
+		exprs = append(exprs, e...)
+		targets = append(targets, t...)
+	}
+	p.queries++
+	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), p.table)
+	if err := db.DB.QueryRowContext(p.ctx, q).Scan(targets...); err != nil {
+		return err
+	}
+
+	plan := &profilePlan{}
+	for _, col := range cols {
+		col.profile.plan(plan)
+	}
+	if err := p.histograms(plan.histograms); err != nil && !lenient {
+		return err
+	}
+	if err := p.groups(plan.groups); err != nil && !lenient {
+		return err
+	}
+	for _, col := range cols {
+		col.ok = true
+	}
+	return nil
+}
+
+// histograms fills in the counts of every histogram in one query. DuckDB's
+// histogram aggregate puts a value in the first boundary at or above it; the
+// buckets here are closed at the bottom instead, so values and boundaries are
+// negated, which makes "the first boundary at or above -x" the highest lower
+// boundary at or below x.
+func (p *profiler) histograms(hists []*histogramRequest) error {
+	if len(hists) == 0 {
+		return nil
+	}
+	exprs := make([]string, len(hists))
+	for i, h := range hists {
+		bounds := make([]string, len(h.lower))
+		for j := range h.lower {
+			bounds[j] = strconv.FormatFloat(-h.lower[len(h.lower)-1-j], 'g', -1, 64)
+		}
+		exprs[i] = fmt.Sprintf("histogram(-(%s), [%s]::DOUBLE[])", h.expr, strings.Join(bounds, ", "))
+	}
+	maps := make([]interface{}, len(hists))
+	targets := make([]interface{}, len(hists))
+	for i := range maps {
+		targets[i] = &maps[i]
+	}
+	p.queries++
+	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), p.table)
+	if err := db.DB.QueryRowContext(p.ctx, q).Scan(targets...); err != nil {
+		return err
+	}
+
+	for i, h := range hists {
+		index := make(map[float64]int, len(h.lower))
+		for j, lo := range h.lower {
+			index[-lo] = j
+		}
+		counts := make([]int, len(h.lower))
+		m, _ := maps[i].(duckdb.Map)
+		for k, v := range m {
+			bound, ok1 := k.(float64)
+			n, ok2 := v.(uint64)
+			if j, ok3 := index[bound]; ok1 && ok2 && ok3 {
+				counts[j] = int(n)
+			}
+		}
+		h.counts = counts
+	}
+	return nil
+}
+
+// groups fills in every group request in one GROUPING SETS query. Each
+// grouping set leaves the other sets' key columns NULL, so a row belongs to
+// the set whose key is not NULL; rows for NULL values have no key at all and
+// are dropped. Ties in count are cut in key order, which is the only key
+// column that varies within a set.
+func (p *profiler) groups(groups []*groupRequest) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	keys := make([]string, len(groups))
+	order := []string{"n DESC"}
+	sets := make([]string, len(groups))
+	which := make([]string, len(groups))
+	limits := make([]string, len(groups))
+	for i, g := range groups {
+		keys[i] = fmt.Sprintf("%s AS k%d", g.expr, i)
+		order = append(order, fmt.Sprintf("k%d", i))
+		sets[i] = "(" + g.expr + ")"
+		which[i] = fmt.Sprintf("WHEN k%d IS NOT NULL THEN %d", i, i)
+		limits[i] = fmt.Sprintf("WHEN %d THEN %d", i, g.limit)
+	}
+	q := fmt.Sprintf(`SELECT s, n, * EXCLUDE (s, n, r) FROM (
+			SELECT *, row_number() OVER (PARTITION BY s ORDER BY %s) AS r FROM (
+				SELECT CAST(CASE %s END AS BIGINT) AS s, * FROM (
+					SELECT %s, COUNT(*) AS n FROM %s GROUP BY GROUPING SETS (%s)
+				)
+			)
+		)
+		WHERE s IS NOT NULL AND r <= CASE s %s END`,
+		strings.Join(order, ", "), strings.Join(which, " "), strings.Join(keys, ", "), p.table, strings.Join(sets, ", "), strings.Join(limits, " "))
+	p.queries++
+	rows, err := db.DB.QueryContext(p.ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	results := make([][]groupRow, len(groups))
+	values := make([]interface{}, len(groups)+2)
+	targets := make([]interface{}, len(values))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
+			return err
+		}
+		set, _ := values[0].(int64)
+		n, _ := values[1].(int64)
+		results[set] = append(results[set], groupRow{key: values[2+set], count: int(n)})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, g := range groups {
+		g.rows = results[i]
+		if g.rows == nil {
+			g.rows = []groupRow{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestProfileWideTable(t *testing.T) {
+	openTestDB(t)
+	// Numeric, text, date and boolean columns, so every batch needs all three
+	// passes; the last text column has 20 values tied for most frequent, first
+	// seen in descending order
+	const width = 70
+	exprs := make([]string, width)
+	for i := range exprs {
+		switch i % 4 {
+		case 0:
+			exprs[i] = fmt.Sprintf("range %% 97 + %d AS c%d", i, i)
+		case 1:
+			exprs[i] = fmt.Sprintf("'v' || lpad(CAST(19 - range %% 20 AS VARCHAR), 2, '0') AS c%d", i)
+		case 2:
+			exprs[i] = fmt.Sprintf("DATE '2024-01-01' + CAST(range %% 40 AS INTEGER) AS c%d", i)
+		case 3:
+			exprs[i] = fmt.Sprintf("range %% 2 = 0 AS c%d", i)
+		}
+	}
+	if _, err := db.DB.Exec("CREATE TABLE wide AS SELECT " + strings.Join(exprs, ", ") + " FROM range(1000)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	opts := statsOptions{Percentiles: defaultPercentiles, Histogram: "equal-width", Buckets: defaultBuckets}
+	cols, err := describeProfiled(ctx, "wide", opts)
+	if err != nil || len(cols) != width {
+		t.Fatalf("got %d columns, err %v", len(cols), err)
+	}
+	p := &profiler{ctx: ctx, table: "wide"}
+	if err := p.profile(cols); err != nil {
+		t.Fatal(err)
+	}
+
+	batches := (width + profileBatchColumns - 1) / profileBatchColumns
+	if p.queries != 3*batches {
+		t.Errorf("profiled %d columns in %d queries, want %d", width, p.queries, 3*batches)
+	}
+	if p.rowCount != 1000 {
+		t.Errorf("rowCount = %d", p.rowCount)
+	}
+	for _, col := range cols {
+		if !col.ok {
+			t.Errorf("column %s wasn't profiled", col.Name)
+		}
+	}
+
+	stats, _ := cols[width-1].profile.result()
+	top, _ := stats["topValues"].([]fiber.Map)
+	if len(top) != topValuesLimit {
+		t.Fatalf("got %d top values", len(top))
+	}
+	for i, v := range top {
+		if want := fmt.Sprintf("v%02d", i); v["value"] != want || v["count"] != 50 {
+			t.Errorf("top value %d = %v, want %s with 50 rows", i, v, want)
+		}
+	}
+}
+
+// BenchmarkProfile compares batched profiling with profiling each column on
+// its own, which takes the same two or three scans per column as the
+// per-column queries batching replaced. Both report the queries they ran.
+func BenchmarkProfile(b *testing.B) {
+	if err := db.Init(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.DB.Close() })
+	const width = 40
+	exprs := make([]string, width)
+	for i := range exprs {
+		switch i % 4 {
+		case 0:
+			exprs[i] = fmt.Sprintf("random() * %d AS c%d", i+1, i)
+		case 1:
+			exprs[i] = fmt.Sprintf("'v' || CAST(range %% %d AS VARCHAR) AS c%d", 10*(i+1), i)
+		case 2:
+			exprs[i] = fmt.Sprintf("TIMESTAMP '2024-01-01' + range * INTERVAL %d SECOND AS c%d", i, i)
+		case 3:
+			exprs[i] = fmt.Sprintf("range %% 3 = 0 AS c%d", i)
+		}
+	}
+	if _, err := db.DB.Exec("CREATE TABLE bench AS SELECT " + strings.Join(exprs, ", ") + " FROM range(100000)"); err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	opts := statsOptions{Percentiles: defaultPercentiles, Histogram: "equal-width", Buckets: defaultBuckets}
+
+	for _, mode := range []string{"batched", "per-column"} {
+		b.Run(mode, func(b *testing.B) {
+			queries := 0
+			for i := 0; i < b.N; i++ {
+				cols, err := describeProfiled(ctx, "bench", opts)
+				if err != nil || len(cols) != width {
+					b.Fatalf("got %d columns, err %v", len(cols), err)
+				}
+				p := &profiler{ctx: ctx, table: "bench"}
+				if mode == "batched" {
+					err = p.profile(cols)
+				} else {
+					for _, col := range cols {
+						p.run([]*profiledColumn{col})
+					}
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				queries += p.queries
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"artemisgo/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListQueries returns the queries currently executing or queued, along with
+// the admission limits and load.
+func ListQueries(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"queries": db.RunningQueries(), "admission": db.Admission()})
+}
+
+// CancelQuery interrupts a running query by ID.
+func CancelQuery(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !db.CancelQuery(id) {
+		return c.Status(404).JSON(fiber.Map{"error": "No running query with that ID"})
+	}
+	return c.JSON(fiber.Map{"queryId": id, "status": "canceled"})
+}
@@ -2,63 +2,76 @@ package handlers
 
 import (
 	"artemisgo/db"
+	"errors"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type queryRequest struct {
-	SQL string `json:"sql"`
+	SQL       string       `json:"sql"`
+	Params    *queryParams `json:"params"`
+	QueryID   string       `json:"queryId"`
+	TimeoutMs int          `json:"timeoutMs"`
+	NoCache   bool         `json:"noCache"`
 }
 
 func Query(c *fiber.Ctx) error {
 	var req queryRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
 	}
 	if req.SQL == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "SQL query is required"})
 	}
 
-	rows, err := db.DB.Query(req.SQL)
-	if err != nil {
-		return c.JSON(fiber.Map{"error": err.Error(), "columns": []string{}, "rows": [][]interface{}{}})
-	}
-	defer rows.Close()
-
-	columns, err := rows.Columns()
-	if err != nil {
-		return c.JSON(fiber.Map{"error": err.Error(), "columns": []string{}, "rows": [][]interface{}{}})
+	// Clients may pick their own ID so they can cancel before the response arrives
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
 	}
 
-	var results [][]interface{}
-	for rows.Next() {
-		vals := make([]interface{}, len(columns))
-		ptrs := make([]interface{}, len(columns))
-		for i := range vals {
-			ptrs[i] = &vals[i]
-		}
-		if err := rows.Scan(ptrs...); err != nil {
-			return c.JSON(fiber.Map{"error": err.Error(), "columns": columns, "rows": results})
-		}
+	return serveQuery(c, execSpec{
+		ID:      id,
+		SQL:     req.SQL,
+		Params:  req.Params,
+		Source:  "editor",
+		Timeout: requestTimeout(req.TimeoutMs),
+		NoCache: req.NoCache,
+	})
+}
 
-		// Convert []byte values to string for JSON serialization
-		row := make([]interface{}, len(vals))
-		for i, v := range vals {
-			if b, ok := v.([]byte); ok {
-				row[i] = string(b)
-			} else {
-				row[i] = v
-			}
-		}
-		results = append(results, row)
+// errorStatus maps a query failure to an HTTP status. Execution errors come
+// back as 200 with an error field, as the editor expects; request problems use
+// the matching status code.
+func errorStatus(err error) int {
+	var policyErr *db.PolicyError
+	var paramErr *paramError
+	switch {
+	case errors.Is(err, db.ErrDuplicateQueryID):
+		return 409
+	case errors.As(err, &paramErr):
+		return 400
+	case errors.As(err, &policyErr):
+		return 403
 	}
+	return 200
+}
 
-	if results == nil {
-		results = [][]interface{}{}
+// sendQueryResult writes a query result in the default row layout.
+func sendQueryResult(c *fiber.Ctx, id string, result *queryResult, err error) error {
+	if err != nil {
+		return c.Status(errorStatus(err)).JSON(fiber.Map{"queryId": id, "error": err.Error(), "columns": []string{}, "columnTypes": []string{}, "rows": [][]interface{}{}})
 	}
 
-	return c.JSON(fiber.Map{
-		"columns": columns,
-		"rows":    results,
-	})
+	resp := fiber.Map{
+		"queryId":     id,
+		"columns":     result.Columns,
+		"columnTypes": result.Types,
+		"rows":        result.Rows,
+	}
+	if result.Cache != nil {
+		resp["cache"] = result.Cache
+	}
+	return c.JSON(resp)
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"artemisgo/cache"
+	"artemisgo/sqltext"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// resultCache holds query results keyed by normalized SQL, bound parameters
+// and the version of the tables the query reads (see db.QueryVersion), so a
+// change to one table leaves results from the others in place. Results from
+// older versions are never hit again and age out.
+var resultCache = cache.New(0, 0)
+
+type cacheInfo struct {
+	Hit   bool    `json:"hit"`
+	AgeMs float64 `json:"ageMs,omitempty"`
+}
+
+// volatileFunctions return different results on each call, so queries using
+// them are never cached.
+var volatileFunctions = map[string]bool{
+	"random": true, "setseed": true, "uuid": true, "gen_random_uuid": true,
+	"now": true, "current_timestamp": true, "current_date": true, "current_time": true,
+	"get_current_time": true, "get_current_timestamp": true, "today": true,
+	"transaction_timestamp": true, "nextval": true, "currval": true,
+}
+
+// initResultCache configures the cache from RESULT_CACHE_MAX_MB (default 64,
+// 0 disables) and RESULT_CACHE_TTL (default 10m).
+func initResultCache() error {
+	maxMB := 64
+	if v := os.Getenv("RESULT_CACHE_MAX_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid RESULT_CACHE_MAX_MB %q", v)
+		}
+		maxMB = n
+	}
+	ttl := 10 * time.Minute
+	if v := os.Getenv("RESULT_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid RESULT_CACHE_TTL %q", v)
+		}
+		ttl = d
+	}
+
+	resultCache = cache.New(int64(maxMB)*1024*1024, ttl)
+	return nil
+}
+
+// cacheKey normalizes whitespace, comments and identifier case so trivially
+// different spellings of a query share an entry. It returns "" for queries
+// that must not be cached.
+func cacheKey(sqlText string, args []interface{}, version int64) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "v%d|", version)
+	for _, tok := range sqltext.Code(sqlText) {
+		text := tok.Text
+		if tok.Kind == sqltext.Ident {
+			text = strings.ToLower(text)
+			if volatileFunctions[text] {
+				return ""
+			}
+		}
+		if text == ";" {
+			continue
+		}
+		sb.WriteString(text)
+		sb.WriteByte(' ')
+	}
+	sb.WriteByte('|')
+	for _, a := range args {
+		fmt.Fprintf(&sb, "%T:%#v,", a, a)
+	}
+	return sb.String()
+}
+
+// resultSize roughly estimates the memory held by a result.
+func resultSize(r *queryResult) int64 {
+	size := int64(64)
+	for _, col := range r.Columns {
+		size += int64(len(col)) + 16
+	}
+	for _, layout := range [][][]interface{}{r.Rows, r.Data} {
+		for _, values := range layout {
+			size += 24
+			for _, v := range values {
+				if s, ok := v.(string); ok {
+					size += int64(len(s)) + 16
+				} else {
+					size += 16
+				}
+			}
+		}
+	}
+	return size
+}
+
+// CacheStats reports result cache usage.
+func CacheStats(c *fiber.Ctx) error {
+	return c.JSON(resultCache.Stats())
+}
+
+// PurgeCache empties the result cache.
+func PurgeCache(c *fiber.Ctx) error {
+	resultCache.Purge()
+	return c.JSON(resultCache.Stats())
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"artemisgo/sqltext"
+	"artemisgo/store"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var variableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type savedQueryRequest struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	SQL         string           `json:"sql"`
+	Variables   []store.Variable `json:"variables"`
+	Tags        []string         `json:"tags"`
+	Owner       string           `json:"owner"`
+}
+
+// ListSavedQueries returns saved queries, optionally filtered by ?tag=.
+func ListSavedQueries(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"queries": store.ListSavedQueries(c.Query("tag"))})
+}
+
+func GetSavedQuery(c *fiber.Ctx) error {
+	q, ok := store.GetSavedQuery(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Saved query not found"})
+	}
+	return c.JSON(q)
+}
+
+func CreateSavedQuery(c *fiber.Ctx) error {
+	var req savedQueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	now := time.Now()
+	q := store.SavedQuery{ID: uuid.NewString(), Created: now}
+	return saveQuery(c, q, req)
+}
+
+func UpdateSavedQuery(c *fiber.Ctx) error {
+	q, ok := store.GetSavedQuery(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Saved query not found"})
+	}
+	var req savedQueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	return saveQuery(c, q, req)
+}
+
+func saveQuery(c *fiber.Ctx, q store.SavedQuery, req savedQueryRequest) error {
+	q.Name = strings.TrimSpace(req.Name)
+	q.Description = req.Description
+	q.SQL = req.SQL
+	q.Variables = req.Variables
+	q.Tags = req.Tags
+	q.Owner = req.Owner
+	q.Updated = time.Now()
+	if q.Variables == nil {
+		q.Variables = []store.Variable{}
+	}
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
+
+	if err := validateSavedQuery(q); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := store.PutSavedQuery(q); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to save query: %v", err)})
+	}
+	return c.JSON(q)
+}
+
+func DeleteSavedQuery(c *fiber.Ctx) error {
+	ok, err := store.DeleteSavedQuery(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to delete query: %v", err)})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Saved query not found"})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+func validateSavedQuery(q store.SavedQuery) error {
+	if q.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.TrimSpace(q.SQL) == "" {
+		return fmt.Errorf("sql is required")
+	}
+
+	declared := map[string]bool{}
+	for _, v := range q.Variables {
+		if !variableNameRe.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("variable %s is declared twice", v.Name)
+		}
+		declared[v.Name] = true
+
+		// Check the type (and default, if any) the same way a run would
+		if _, err := (paramValue{Value: v.Default, Type: v.Type}).coerce(); err != nil {
+			return fmt.Errorf("variable %s: %v", v.Name, err)
+		}
+	}
+
+	_, used, undeclared := expandVariables(q.SQL, declared)
+	if len(undeclared) > 0 {
+		return fmt.Errorf("variable %s is used but not declared", undeclared[0])
+	}
+	for _, v := range q.Variables {
+		if !used[v.Name] {
+			return fmt.Errorf("variable %s is declared but not used in the SQL", v.Name)
+		}
+	}
+	return nil
+}
+
+// expandVariables rewrites references to declared variables (:name, {{name}}
+// or $name) as $name placeholders for binding. It also reports which
+// variables were used and any references that aren't declared. Only declared
+// names are rewritten after a colon, and an undeclared :name is only reported
+// outside brackets and braces, so struct literals such as {'a':b} and slices
+// such as l[1:n] are left alone.
+func expandVariables(sqlText string, declared map[string]bool) (string, map[string]bool, []string) {
+	code := sqltext.Code(sqlText)
+	used := map[string]bool{}
+	var undeclared []string
+	var sb strings.Builder
+	last, nesting := 0, 0
+
+	replace := func(start, end int, name string) {
+		sb.WriteString(sqlText[last:start])
+		sb.WriteString("$" + name)
+		last = end
+		used[name] = true
+	}
+
+	for i := 0; i < len(code); i++ {
+		tok := code[i]
+		switch {
+		case tok.Kind == sqltext.Operator && strings.HasSuffix(tok.Text, ":") && !strings.HasSuffix(tok.Text, "::") &&
+			i+1 < len(code) && code[i+1].Kind == sqltext.Ident && code[i+1].Pos == tok.Pos+len(tok.Text):
+			// The lexer may glue the colon to a preceding operator, as in a=:b
+			name := code[i+1]
+			if declared[name.Text] {
+				replace(tok.Pos+len(tok.Text)-1, name.Pos+len(name.Text), name.Text)
+			} else if nesting == 0 {
+				undeclared = append(undeclared, name.Text)
+			}
+			i++
+
+		case tok.Text == "{" && i+4 < len(code) && code[i+1].Text == "{" && code[i+2].Kind == sqltext.Ident &&
+			code[i+3].Text == "}" && code[i+4].Text == "}":
+			name := code[i+2].Text
+			if !declared[name] {
+				undeclared = append(undeclared, name)
+			}
+			replace(tok.Pos, code[i+4].Pos+1, name)
+			i += 4
+
+		case tok.Text == "[" || tok.Text == "{":
+			nesting++
+		case tok.Text == "]" || tok.Text == "}":
+			nesting--
+
+		case tok.Kind == sqltext.Param && len(tok.Text) > 1:
+			if name := tok.Text[1:]; declared[name] {
+				used[name] = true
+			} else if _, err := strconv.Atoi(name); err != nil {
+				undeclared = append(undeclared, name)
+			}
+		}
+	}
+	sb.WriteString(sqlText[last:])
+	sort.Strings(undeclared)
+	return sb.String(), used, undeclared
+}
+
+type runSavedQueryRequest struct {
+	Variables map[string]json.RawMessage `json:"variables"`
+	QueryID   string                     `json:"queryId"`
+	TimeoutMs int                        `json:"timeoutMs"`
+}
+
+// RunSavedQuery executes a saved query with the given variable values,
+// falling back to each variable's default.
+func RunSavedQuery(c *fiber.Ctx) error {
+	q, ok := store.GetSavedQuery(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Saved query not found"})
+	}
+
+	var req runSavedQueryRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	sqlText, params, err := savedQueryParams(q, req.Variables)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return serveQuery(c, execSpec{
+		ID:      id,
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "saved",
+		Timeout: requestTimeout(req.TimeoutMs),
+	})
+}
+
+// savedQueryParams expands a saved query's variables and builds the named
+// parameters for a run.
+func savedQueryParams(q store.SavedQuery, values map[string]json.RawMessage) (string, *queryParams, error) {
+	declared := map[string]bool{}
+	for _, v := range q.Variables {
+		declared[v.Name] = true
+	}
+	for name := range values {
+		if !declared[name] {
+			return "", nil, fmt.Errorf("unknown variable %s", name)
+		}
+	}
+
+	sqlText, used, _ := expandVariables(q.SQL, declared)
+	params := &queryParams{named: map[string]paramValue{}}
+	for _, v := range q.Variables {
+		if !used[v.Name] {
+			continue
+		}
+		value, ok := values[v.Name]
+		if !ok {
+			value = v.Default
+		}
+		if len(value) == 0 {
+			return "", nil, fmt.Errorf("missing value for variable %s", v.Name)
+		}
+		params.named[v.Name] = paramValue{Value: value, Type: v.Type}
+	}
+	return sqlText, params, nil
+}
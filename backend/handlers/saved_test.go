@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/store"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	declared := map[string]bool{"day": true, "n": true}
+	tests := []struct {
+		sql        string
+		want       string
+		used       []string
+		undeclared []string
+	}{
+		{"SELECT * FROM t WHERE d = :day", "SELECT * FROM t WHERE d = $day", []string{"day"}, nil},
+		{"SELECT * FROM t WHERE d=:day LIMIT {{n}}", "SELECT * FROM t WHERE d=$day LIMIT $n", []string{"day", "n"}, nil},
+		{"SELECT * FROM t WHERE d = $day", "SELECT * FROM t WHERE d = $day", []string{"day"}, nil},
+		{"SELECT ':day', x::DATE, {'a':b} FROM t", "SELECT ':day', x::DATE, {'a':b} FROM t", nil, nil},
+		{"SELECT {'a':other}, l[1:k] FROM t -- :day", "SELECT {'a':other}, l[1:k] FROM t -- :day", nil, nil},
+		{"SELECT * FROM t WHERE d = :other", "SELECT * FROM t WHERE d = :other", nil, []string{"other"}},
+		{"SELECT * FROM t WHERE d = $other AND e = $1", "SELECT * FROM t WHERE d = $other AND e = $1", nil, []string{"other"}},
+		{"SELECT {{other}}", "SELECT $other", []string{"other"}, []string{"other"}},
+	}
+	for _, tt := range tests {
+		got, used, undeclared := expandVariables(tt.sql, declared)
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.sql, got, tt.want)
+		}
+		var usedNames []string
+		for name := range used {
+			usedNames = append(usedNames, name)
+		}
+		if len(usedNames) != len(tt.used) || !reflect.DeepEqual(undeclared, tt.undeclared) {
+			t.Errorf("%s: used %v, undeclared %v; want %v, %v", tt.sql, usedNames, undeclared, tt.used, tt.undeclared)
+		}
+		for _, name := range tt.used {
+			if !used[name] {
+				t.Errorf("%s: %s not reported as used", tt.sql, name)
+			}
+		}
+	}
+}
+
+func TestValidateSavedQuery(t *testing.T) {
+	day := store.Variable{Name: "day", Type: "DATE", Default: json.RawMessage(`"2024-03-01"`)}
+	tests := []struct {
+		sql     string
+		vars    []store.Variable
+		wantErr string
+	}{
+		{"SELECT * FROM t WHERE d >= :day", []store.Variable{day}, ""},
+		{"SELECT * FROM t WHERE d >= :day AND e = :other", []store.Variable{day}, "other is used but not declared"},
+		{"SELECT * FROM t WHERE d >= $day AND e = $other", []store.Variable{day}, "other is used but not declared"},
+		{"SELECT * FROM t WHERE d >= {{other}}", nil, "other is used but not declared"},
+		{"SELECT 1", []store.Variable{day}, "day is declared but not used"},
+		{"SELECT :day", []store.Variable{{Name: "day", Type: "DATE", Default: json.RawMessage(`"March"`)}}, "cannot use"},
+		{"SELECT :day", []store.Variable{day, day}, "declared twice"},
+	}
+	for _, tt := range tests {
+		err := validateSavedQuery(store.SavedQuery{Name: "q", SQL: tt.sql, Variables: tt.vars})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.sql, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSavedQueryTypedVariables(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.DB.Exec(`CREATE TABLE sales AS SELECT * FROM (VALUES
+		(DATE '2024-02-28', 9.99::DECIMAL(10,2)),
+		(DATE '2024-03-01', 10.50::DECIMAL(10,2)),
+		(DATE '2024-03-15', 120.00::DECIMAL(10,2))) AS v(day, amount)`); err != nil {
+		t.Fatal(err)
+	}
+	q := store.SavedQuery{
+		Name: "big sales",
+		SQL:  "SELECT * FROM sales WHERE day >= :since AND amount > {{min}}",
+		Variables: []store.Variable{
+			{Name: "since", Type: "DATE", Default: json.RawMessage(`"2024-01-01"`)},
+			{Name: "min", Type: "DECIMAL"},
+		},
+	}
+	if err := validateSavedQuery(q); err != nil {
+		t.Fatal(err)
+	}
+	sqlText, params, err := savedQueryParams(q, map[string]json.RawMessage{"min": json.RawMessage(`"10.5"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex, err := startQuery(execSpec{SQL: sqlText, Params: params, Source: "saved", Timeout: db.QueryTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ex.done()
+	result, err := queryRows(ex.ctx, db.DB, ex.sql, ex.args)
+	if err != nil {
+		t.Fatalf("%s: %v", ex.sql, err)
+	}
+	if len(result.Rows) != 1 {
+		t.Errorf("got %d rows, want 1", len(result.Rows))
+	}
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"artemisgo/cron"
+	"artemisgo/db"
+	"artemisgo/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var fileNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// exportDir receives the files written by scheduled queries.
+var exportDir string
+
+var (
+	schedulerMu      sync.Mutex
+	nextRuns         = map[string]time.Time{}
+	runningSchedules = map[string]bool{}
+)
+
+type scheduleRequest struct {
+	Name         string                     `json:"name"`
+	SavedQueryID string                     `json:"savedQueryId"`
+	Cron         string                     `json:"cron"`
+	Timezone     string                     `json:"timezone"`
+	Variables    map[string]json.RawMessage `json:"variables"`
+	Output       store.ScheduleOutput       `json:"output"`
+	Enabled      *bool                      `json:"enabled"`
+}
+
+type scheduleView struct {
+	store.Schedule
+	NextRun *time.Time `json:"nextRun"`
+	Running bool       `json:"running"`
+}
+
+// initScheduler prepares the export directory (EXPORT_DIR, default
+// <DATA_DIR>/exports) and starts the scheduler. Runs missed while the server
+// was down are not made up; each schedule resumes at its next time after
+// startup.
+func initScheduler() error {
+	exportDir = os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = filepath.Join(store.Dir(), "exports")
+	}
+	if err := os.MkdirAll(exportDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	now := time.Now()
+	for _, s := range store.ListSchedules() {
+		planNextRun(s, now)
+	}
+	go schedulerLoop()
+	return nil
+}
+
+func schedulerLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, s := range store.ListSchedules() {
+			schedulerMu.Lock()
+			next := nextRuns[s.ID]
+			due := s.Enabled && !next.IsZero() && !now.Before(next)
+			busy := runningSchedules[s.ID]
+			if due {
+				nextRuns[s.ID] = nextRunTime(s, now)
+			}
+			schedulerMu.Unlock()
+
+			switch {
+			case due && busy:
+				log.Printf("Scheduler: skipping %s (%s), previous run still in progress", s.Name, s.ID)
+			case due:
+				go runSchedule(s, false)
+			}
+		}
+	}
+}
+
+// nextRunTime returns when s should next fire after t, or the zero time if
+// it never will.
+func nextRunTime(s store.Schedule, t time.Time) time.Time {
+	expr, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return expr.Next(t.In(loc))
+}
+
+func planNextRun(s store.Schedule, now time.Time) {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	nextRuns[s.ID] = nextRunTime(s, now)
+}
+
+func viewSchedule(s store.Schedule) scheduleView {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	v := scheduleView{Schedule: s, Running: runningSchedules[s.ID]}
+	if next := nextRuns[s.ID]; s.Enabled && !next.IsZero() {
+		v.NextRun = &next
+	}
+	return v
+}
+
+// runSchedule executes a schedule once and records the run.
+func runSchedule(s store.Schedule, manual bool) store.ScheduleRun {
+	run := store.ScheduleRun{
+		ID:         uuid.NewString(),
+		ScheduleID: s.ID,
+		QueryID:    uuid.NewString(),
+		Started:    time.Now(),
+		Manual:     manual,
+	}
+
+	schedulerMu.Lock()
+	busy := runningSchedules[s.ID]
+	runningSchedules[s.ID] = true
+	schedulerMu.Unlock()
+	if busy {
+		run.Status, run.Error = "error", "previous run still in progress"
+		return run
+	}
+	defer func() {
+		schedulerMu.Lock()
+		delete(runningSchedules, s.ID)
+		schedulerMu.Unlock()
+	}()
+
+	rowCount, output, err := executeSchedule(s, run.QueryID, run.Started)
+	run.DurationMs = float64(time.Since(run.Started).Microseconds()) / 1000
+	run.RowCount, run.Output, run.Status = rowCount, output, "ok"
+	if err != nil {
+		run.Status, run.Error = "error", err.Error()
+		log.Printf("Scheduler: %s (%s) failed: %v", s.Name, s.ID, err)
+	}
+	if err := store.RecordScheduleRun(run); err != nil {
+		log.Printf("Scheduler: failed to record run of %s: %v", s.ID, err)
+	}
+	return run
+}
+
+// executeSchedule runs the schedule's saved query and writes the result to
+// its output. Scheduled queries are checked under the read-only policy.
+func executeSchedule(s store.Schedule, queryID string, runAt time.Time) (rowCount int64, output string, err error) {
+	q, ok := store.GetSavedQuery(s.SavedQueryID)
+	if !ok {
+		return 0, "", fmt.Errorf("saved query %s no longer exists", s.SavedQueryID)
+	}
+	sqlText, params, err := savedQueryParams(q, s.Variables)
+	if err != nil {
+		return 0, "", err
+	}
+
+	spec := execSpec{
+		ID:      queryID,
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "schedule",
+		Timeout: db.QueryTimeout,
+	}
+	start := time.Now()
+	version := db.DatasetVersion()
+	defer func() { recordHistory(spec, start, version, int(rowCount), false, err) }()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		return 0, "", err
+	}
+	defer ex.done()
+	if ex.writes {
+		return 0, "", fmt.Errorf("scheduled queries must be read-only")
+	}
+	if err := ex.admit(); err != nil {
+		return 0, "", err
+	}
+
+	switch s.Output.Kind {
+	case "table":
+		output = s.Output.Table
+		if err := checkScheduleTarget(s); err != nil {
+			return 0, output, err
+		}
+		rowCount, err = db.SnapshotTable(ex.ctx, output, s.ID, ex.sql, ex.args, s.Output.Mode == "append", runAt)
+	case "file":
+		name := fmt.Sprintf("%s_%s.%s", s.Output.FileName, runAt.UTC().Format("20060102T150405Z"), s.Output.Format)
+		output = filepath.Join(exportDir, name)
+		rowCount, err = db.ExportQuery(ex.ctx, output, s.Output.Format, ex.sql, ex.args)
+	default:
+		err = fmt.Errorf("unknown output kind %q", s.Output.Kind)
+	}
+	if err != nil {
+		return 0, output, queryError(ex.ctx, err)
+	}
+	return rowCount, output, nil
+}
+
+// ListSchedules returns every schedule with its next run time.
+func ListSchedules(c *fiber.Ctx) error {
+	views := []scheduleView{}
+	for _, s := range store.ListSchedules() {
+		views = append(views, viewSchedule(s))
+	}
+	return c.JSON(fiber.Map{"schedules": views})
+}
+
+func GetSchedule(c *fiber.Ctx) error {
+	s, ok := store.GetSchedule(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	return c.JSON(viewSchedule(s))
+}
+
+func CreateSchedule(c *fiber.Ctx) error {
+	var req scheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	s := store.Schedule{ID: uuid.NewString(), Created: time.Now()}
+	return saveSchedule(c, s, req)
+}
+
+func UpdateSchedule(c *fiber.Ctx) error {
+	s, ok := store.GetSchedule(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	var req scheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	return saveSchedule(c, s, req)
+}
+
+func saveSchedule(c *fiber.Ctx, s store.Schedule, req scheduleRequest) error {
+	s.Name = strings.TrimSpace(req.Name)
+	s.SavedQueryID = req.SavedQueryID
+	s.Cron = strings.TrimSpace(req.Cron)
+	s.Timezone = req.Timezone
+	s.Variables = req.Variables
+	s.Output = req.Output
+	s.Enabled = req.Enabled == nil || *req.Enabled
+	s.Updated = time.Now()
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if s.Variables == nil {
+		s.Variables = map[string]json.RawMessage{}
+	}
+
+	if err := validateSchedule(&s); err != nil {
+		var policyErr *db.PolicyError
+		if errors.As(err, &policyErr) {
+			return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := store.PutSchedule(s); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to save schedule: %v", err)})
+	}
+	planNextRun(s, time.Now())
+	return c.JSON(viewSchedule(s))
+}
+
+// validateSchedule checks a schedule and fills in output defaults.
+func validateSchedule(s *store.Schedule) error {
+	q, ok := store.GetSavedQuery(s.SavedQueryID)
+	if !ok {
+		return fmt.Errorf("saved query %q not found", s.SavedQueryID)
+	}
+	if s.Name == "" {
+		s.Name = q.Name
+	}
+	if _, err := cron.Parse(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %v", err)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	sqlText, params, err := savedQueryParams(q, s.Variables)
+	if err != nil {
+		return err
+	}
+	for name, v := range params.named {
+		if _, err := v.coerce(); err != nil {
+			return fmt.Errorf("variable %s: %v", name, err)
+		}
+	}
+
+	out := &s.Output
+	switch out.Kind {
+	case "table":
+		if out.Mode == "" {
+			out.Mode = "append"
+		}
+		if out.Mode != "append" && out.Mode != "replace" {
+			return fmt.Errorf("output mode must be append or replace")
+		}
+		if !db.ValidTableName(out.Table) {
+			return fmt.Errorf("invalid output table %q: use letters, digits and underscores", out.Table)
+		}
+		out.Format, out.FileName = "", ""
+		if err := checkScheduleTarget(*s); err != nil {
+			return err
+		}
+		if e, ok := db.LookupTable(out.Table); ok && out.Mode == "append" {
+			if err := checkAppendTarget(e.Name, sqlText, params); err != nil {
+				return err
+			}
+		}
+	case "file":
+		if out.Format == "" {
+			out.Format = "csv"
+		}
+		if !db.ValidExportFormat(out.Format) {
+			return fmt.Errorf("output format must be csv, parquet or json")
+		}
+		if out.FileName == "" {
+			out.FileName = "schedule_" + s.ID
+		}
+		if !fileNameRe.MatchString(out.FileName) {
+			return fmt.Errorf("invalid file name %q: use letters, digits, _ and -", out.FileName)
+		}
+		out.Table, out.Mode = "", ""
+	default:
+		return fmt.Errorf("output kind must be table or file")
+	}
+	return nil
+}
+
+// checkScheduleTarget checks that a schedule may write to its output table.
+// Writing to an existing table changes its data, so unless the schedule
+// created the table, it needs the editor's policy to allow writes, as
+// replacing a table through CreateTable does.
+func checkScheduleTarget(s store.Schedule) error {
+	e, ok := db.LookupTable(s.Output.Table)
+	switch {
+	case !ok:
+		return nil
+	case e.Kind != "table":
+		return fmt.Errorf("%s is not a derived table and can't be written to", e.Name)
+	case e.Schedule != s.ID && !db.PolicyFor("editor").AllowWrites:
+		return &db.PolicyError{Reason: fmt.Sprintf("writing to the existing table %s is not allowed in read-only mode", e.Name)}
+	}
+	return nil
+}
+
+// checkAppendTarget checks that the rows of a saved query can be appended to
+// table: it needs a run_at column, and one for each column of the result. The
+// result columns are only known if the query can be described now; otherwise
+// a run reports the problem.
+func checkAppendTarget(table, sqlText string, params *queryParams) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.QueryTimeout)
+	defer cancel()
+	columns, err := db.DescribeTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, col := range columns {
+		have[strings.ToLower(col.Name)] = true
+	}
+	if !have["run_at"] {
+		return fmt.Errorf("%s has no run_at column to stamp appended rows with; use replace mode or a new table", table)
+	}
+
+	// The query runs, if only for its columns, so it must pass the policy
+	// first
+	writes, err := db.PolicyFor("schedule").Check(ctx, sqlText)
+	if err != nil {
+		return err
+	}
+	if writes {
+		return fmt.Errorf("scheduled queries must be read-only")
+	}
+	bound, args, err := params.bind(sqlText)
+	if err != nil {
+		return err
+	}
+	probe, ok := describeQuery(bound)
+	if !ok {
+		return nil
+	}
+	rows, err := db.DB.QueryContext(ctx, probe, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	result, err := rows.Columns()
+	if err != nil {
+		return nil
+	}
+	for _, name := range result {
+		if !have[strings.ToLower(name)] {
+			return fmt.Errorf("%s has no column %s for the query's results; use replace mode or another table", table, name)
+		}
+	}
+	return nil
+}
+
+func DeleteSchedule(c *fiber.Ctx) error {
+	ok, err := store.DeleteSchedule(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to delete schedule: %v", err)})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	schedulerMu.Lock()
+	delete(nextRuns, c.Params("id"))
+	schedulerMu.Unlock()
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+// ScheduleRuns returns a schedule's run history, newest first.
+func ScheduleRuns(c *fiber.Ctx) error {
+	if _, ok := store.GetSchedule(c.Params("id")); !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	return c.JSON(fiber.Map{"runs": store.ScheduleRuns(c.Params("id"))})
+}
+
+// RunScheduleNow runs a schedule immediately, outside its cron times, and
+// returns the run record.
+func RunScheduleNow(c *fiber.Ctx) error {
+	s, ok := store.GetSchedule(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	return c.JSON(runSchedule(s, true))
+}
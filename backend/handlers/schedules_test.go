@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/store"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleTarget(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.CreateDerived(ctx, "made", "table", "SELECT 1 AS x", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SnapshotTable(ctx, "snap", "s1", "SELECT 1 AS x", nil, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Later runs keep the schedule that created the table
+	if _, err := db.SnapshotTable(ctx, "snap", "s1", "SELECT 2 AS x", nil, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := db.LookupTable("snap"); e.Schedule != "s1" {
+		t.Fatalf("snap belongs to %q, want s1", e.Schedule)
+	}
+
+	schedule := func(id, table string) store.Schedule {
+		return store.Schedule{ID: id, Output: store.ScheduleOutput{Kind: "table", Table: table, Mode: "append"}}
+	}
+	tests := []struct {
+		s      store.Schedule
+		policy bool // a PolicyError is expected
+	}{
+		{schedule("s1", "fresh"), false},
+		{schedule("s1", "snap"), false},
+		{schedule("s2", "snap"), true},
+		{schedule("s1", "made"), true},
+	}
+	for _, tt := range tests {
+		err := checkScheduleTarget(tt.s)
+		var policyErr *db.PolicyError
+		if got := errors.As(err, &policyErr); got != tt.policy {
+			t.Errorf("%s -> %s: got %v", tt.s.ID, tt.s.Output.Table, err)
+		}
+	}
+
+	t.Setenv("QUERY_POLICY", "readwrite")
+	openTestDB(t)
+	if _, err := db.CreateDerived(ctx, "made", "table", "SELECT 1 AS x", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkScheduleTarget(schedule("s1", "made")); err != nil {
+		t.Errorf("readwrite: %v", err)
+	}
+}
+
+func TestCheckAppendTarget(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.CreateDerived(ctx, "plain", "table", "SELECT 1 AS id, 'a' AS name", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SnapshotTable(ctx, "runs", "s1", "SELECT 1 AS id, 'a' AS name", nil, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table, sql string
+		wantErr    string
+	}{
+		{"runs", "SELECT 2 AS id, 'b' AS name", ""},
+		{"runs", "SELECT 'b' AS NAME", ""},
+		{"runs", "SELECT 2 AS id, 3 AS extra", "has no column extra"},
+		{"plain", "SELECT 2 AS id, 'b' AS name", "has no run_at column"},
+		{"runs", "SELECT * FROM read_csv('/etc/passwd')", "not allowed"},
+	}
+	for _, tt := range tests {
+		err := checkAppendTarget(tt.table, tt.sql, &queryParams{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.sql, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type scriptRequest struct {
+	SQL         string `json:"sql"`
+	Transaction bool   `json:"transaction"`
+	QueryID     string `json:"queryId"`
+	TimeoutMs   int    `json:"timeoutMs"`
+}
+
+// statementResult reports one statement of a script. Start and End are byte
+// offsets into the submitted script so the editor can highlight it.
+type statementResult struct {
+	Index        int          `json:"index"`
+	SQL          string       `json:"sql"`
+	Start        int          `json:"start"`
+	End          int          `json:"end"`
+	Status       string       `json:"status"` // ok, error or skipped
+	RowsAffected *int64       `json:"rowsAffected,omitempty"`
+	DurationMs   float64      `json:"durationMs"`
+	Result       *queryResult `json:"result,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// rowStatements return a result set; other statements are executed for their
+// effect and report the number of rows affected.
+var rowStatements = map[string]bool{
+	"SELECT": true, "WITH": true, "FROM": true, "VALUES": true, "TABLE": true,
+	"SHOW": true, "DESCRIBE": true, "SUMMARIZE": true, "EXPLAIN": true,
+	"PIVOT": true, "UNPIVOT": true, "CALL": true, "PRAGMA": true,
+}
+
+// Script runs a multi-statement script in order on a single connection, so
+// later statements see temp tables and settings from earlier ones. Even under
+// the read-only policy a script may create temp tables and views, which are
+// dropped with the connection. It stops at the first failing statement; with
+// "transaction" set, everything is rolled back in that case.
+func Script(c *fiber.Ctx) error {
+	var req scriptRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	stmts := sqltext.Split(req.SQL)
+	if len(stmts) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Script contains no statements"})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return serveScript(c, execSpec{
+		ID:          id,
+		SQL:         req.SQL,
+		Source:      "editor",
+		Timeout:     requestTimeout(req.TimeoutMs),
+		Script:      true,
+		Transaction: req.Transaction,
+	}, stmts)
+}
+
+// serveScript runs a script and writes the per-statement response.
+func serveScript(c *fiber.Ctx, spec execSpec, stmts []sqltext.Statement) error {
+	start := time.Now()
+	results, failed, err := runScript(c, spec, stmts)
+	ran := results != nil
+	if !ran {
+		results = []statementResult{}
+	}
+
+	resp := fiber.Map{
+		"queryId":     spec.ID,
+		"transaction": spec.Transaction,
+		"statements":  results,
+		"durationMs":  float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+		if failed >= 0 {
+			resp["failedStatement"] = failed
+		}
+		if spec.Transaction && ran {
+			resp["rolledBack"] = true
+		}
+		return c.Status(errorStatus(err)).JSON(resp)
+	}
+	return c.JSON(resp)
+}
+
+// runScript executes stmts under one tracked query. It returns the
+// per-statement results, or nil if the script was rejected before running,
+// and the index of the statement that failed (-1 if none did). Statements are
+// numbered from 0, in the results and in errors alike.
+func runScript(c *fiber.Ctx, spec execSpec, stmts []sqltext.Statement) (results []statementResult, failed int, err error) {
+	start := time.Now()
+	version := db.DatasetVersion()
+	rowCount := 0
+	defer func() { recordHistory(spec, start, version, rowCount, false, err) }()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer ex.done()
+	if ex.writes {
+		defer db.BumpVersion()
+	}
+	ctx := ex.ctx
+
+	stop := watchDisconnect(c, func() { db.Interrupt(spec.ID, db.ErrClientGone) })
+	defer stop()
+	if err := ex.admit(); err != nil {
+		return nil, -1, err
+	}
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, -1, queryError(ctx, err)
+	}
+	defer func() {
+		// Temp tables and settings belong to the script, so discard the
+		// connection instead of handing it back to the pool
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		conn.Close()
+	}()
+
+	if spec.Transaction {
+		if _, err := conn.ExecContext(ctx, "BEGIN TRANSACTION"); err != nil {
+			return nil, -1, queryError(ctx, err)
+		}
+	}
+
+	results = make([]statementResult, len(stmts))
+	failed = -1
+	for i, stmt := range stmts {
+		res := &results[i]
+		*res = statementResult{Index: i, SQL: stmt.SQL, Start: stmt.Start, End: stmt.End, Status: "skipped"}
+		if failed >= 0 {
+			continue
+		}
+
+		stmtStart := time.Now()
+		if kw := sqltext.FirstKeyword(stmt.SQL); rowStatements[kw] || hasReturning(stmt.SQL) {
+			res.Result, err = queryRows(ctx, conn, stmt.SQL, nil)
+			if res.Result != nil {
+				rowCount += len(res.Result.Rows)
+			}
+		} else {
+			var r sql.Result
+			if r, err = conn.ExecContext(ctx, stmt.SQL); err == nil {
+				if n, err := r.RowsAffected(); err == nil {
+					res.RowsAffected = &n
+				}
+			} else {
+				err = queryError(ctx, err)
+			}
+		}
+		res.DurationMs = float64(time.Since(stmtStart).Microseconds()) / 1000
+
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+			failed = i
+			continue
+		}
+		res.Status = "ok"
+	}
+
+	if failed >= 0 {
+		if spec.Transaction {
+			// The script's context may already be canceled
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+		return results, failed, fmt.Errorf("statement %d failed: %s", failed, results[failed].Error)
+	}
+	if spec.Transaction {
+		if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			return results, -1, fmt.Errorf("commit failed: %v", queryError(ctx, err))
+		}
+	}
+	return results, -1, nil
+}
+
+func hasReturning(stmt string) bool {
+	for _, tok := range sqltext.Code(stmt) {
+		if tok.Kind == sqltext.Ident && tok.Upper() == "RETURNING" {
+			return true
+		}
+	}
+	return false
+}
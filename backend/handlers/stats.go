@@ -3,223 +3,674 @@ package handlers
 import (
 	"artemisgo/db"
 	"database/sql"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func contains(s, substr string) bool {
-	return strings.Contains(strings.ToUpper(s), strings.ToUpper(substr))
-}
-
 type colMeta struct {
 	Name string
 	Type string
 }
 
+// Stats returns the profile of the uploaded table, or the catalog table
+// named by ?table=, from the stats cache.
 func Stats(c *fiber.Ctx) error {
-	// Check if table exists
-	var count int
-	err := db.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", db.TableName)).Scan(&count)
+	tableName := db.TableName
+	if name := c.Query("table"); name != "" {
+		e, ok := db.LookupTable(name)
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Table not found"})
+		}
+		tableName = e.Name
+	}
+	opts, err := parseStatsOptions(c)
 	if err != nil {
-		return c.JSON(fiber.Map{
-			"rowCount":    0,
-			"columnCount": 0,
-			"columns":     []fiber.Map{},
-		})
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Get column info via DESCRIBE (DuckDB)
-	rows, err := db.DB.Query(fmt.Sprintf("DESCRIBE %s", db.TableName))
+	result, err := cachedStats(tableName, opts)
+	if errors.Is(err, errStatsBusy) {
+		return c.Status(503).JSON(fiber.Map{"error": "Server busy, try again later"})
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	defer rows.Close()
+	return c.JSON(result)
+}
+
+// defaultPercentiles are reported besides the quartiles unless the request
+// lists its own.
+var defaultPercentiles = []float64{0.05, 0.95}
+
+const (
+	defaultBuckets = 10
+	maxBuckets     = 200
+	topValuesLimit = 10
+)
 
-	var cols []colMeta
-	for rows.Next() {
-		var name, colType string
-		var isNull, key, defaultVal, extra sql.NullString
-		if err := rows.Scan(&name, &colType, &isNull, &key, &defaultVal, &extra); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+// histogramStrategies place the bucket boundaries of numeric histograms.
+// equal-width splits the range evenly; quantile puts about as many rows in
+// each bucket; log splits the range evenly on a log scale, for positive
+// skewed data such as amounts; auto picks an equal-width bucket count with
+// the Freedman-Diaconis rule, or Sturges' rule when the IQR is zero.
+var histogramStrategies = []string{"equal-width", "quantile", "log", "auto"}
+
+// statsOptions are the /api/stats query parameters: ?percentiles=,
+// ?histogram= (a strategy) and ?buckets= (ignored by auto).
+type statsOptions struct {
+	Percentiles []float64
+	Histogram   string
+	Buckets     int
+}
+
+func parseStatsOptions(c *fiber.Ctx) (statsOptions, error) {
+	opts := statsOptions{Histogram: c.Query("histogram", "equal-width"), Buckets: defaultBuckets}
+	var err error
+	if opts.Percentiles, err = parsePercentiles(c.Query("percentiles")); err != nil {
+		return opts, err
+	}
+	if !containsFold(histogramStrategies, opts.Histogram) {
+		return opts, fmt.Errorf("unknown histogram strategy %q; use one of %s", opts.Histogram, strings.Join(histogramStrategies, ", "))
+	}
+	opts.Histogram = strings.ToLower(opts.Histogram)
+	if v := c.Query("buckets"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBuckets {
+			return opts, fmt.Errorf("buckets must be between 1 and %d", maxBuckets)
 		}
-		// Map DuckDB types to simple types
-		simpleType := "TEXT"
-		upper := fmt.Sprintf("%s", colType)
-		switch {
-		case contains(upper, "INT"):
-			simpleType = "INTEGER"
-		case contains(upper, "FLOAT"), contains(upper, "DOUBLE"),
-			contains(upper, "DECIMAL"), contains(upper, "NUMERIC"):
-			simpleType = "REAL"
+		opts.Buckets = n
+	}
+	return opts, nil
+}
+
+// parsePercentiles reads a comma-separated list of fractions such as
+// "0.01,0.99".
+func parsePercentiles(s string) ([]float64, error) {
+	if s == "" {
+		return defaultPercentiles, nil
+	}
+	var ps []float64
+	for _, part := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid percentile %q; use fractions between 0 and 1", part)
 		}
-		cols = append(cols, colMeta{Name: name, Type: simpleType})
+		ps = append(ps, p)
 	}
+	return ps, nil
+}
 
-	columns := make([]fiber.Map, 0, len(cols))
-	for _, col := range cols {
-		entry := fiber.Map{"name": col.Name, "type": col.Type}
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
 
-		switch col.Type {
-		case "INTEGER", "REAL":
-			stats, dist, err := numericStats(col.Name)
-			if err == nil {
-				entry["stats"] = stats
-				entry["distribution"] = dist
-			}
-		case "TEXT":
-			stats, dist, err := textStats(col.Name)
-			if err == nil {
-				entry["stats"] = stats
-				entry["distribution"] = dist
-			}
-		}
+// nullableRound3 rounds a statistic that DuckDB leaves NULL when there are
+// too few values, such as the standard deviation of a single row.
+func nullableRound3(f sql.NullFloat64) interface{} {
+	if !f.Valid {
+		return nil
+	}
+	return round3(f.Float64)
+}
 
-		columns = append(columns, entry)
+func nullableString(s sql.NullString) interface{} {
+	if !s.Valid {
+		return nil
 	}
+	return s.String
+}
 
-	return c.JSON(fiber.Map{
-		"rowCount":    count,
-		"columnCount": len(columns),
-		"columns":     columns,
-	})
+// newColumnProfile returns the profile for a column's type class.
+func newColumnProfile(col colMeta, opts statsOptions) columnProfile {
+	quoted := db.QuoteIdent(col.Name)
+	switch col.Type {
+	case "INTEGER", "REAL":
+		return &numericProfile{col: quoted, x: "CAST(" + quoted + " AS DOUBLE)", opts: opts}
+	case "DATE", "TIMESTAMP":
+		return &temporalProfile{col: quoted, ts: "CAST(" + quoted + " AS TIMESTAMP)", class: col.Type}
+	case "TIME":
+		return &timeProfile{col: quoted}
+	case "INTERVAL":
+		return &intervalProfile{col: quoted, secs: "epoch(" + quoted + ")", opts: opts}
+	case "BOOLEAN":
+		return &booleanProfile{col: quoted}
+	}
+	return &textProfile{col: quoted}
 }
 
-func numericStats(colName string) (fiber.Map, []fiber.Map, error) {
-	q := fmt.Sprintf(
-		`SELECT MIN("%s"), MAX("%s"), AVG("%s"), SUM(CASE WHEN "%s" IS NULL THEN 1 ELSE 0 END) FROM %s`,
-		colName, colName, colName, colName, db.TableName,
-	)
+// numericProfile covers INTEGER and REAL columns. The quartiles, requested
+// percentiles and quantile bucket boundaries share a single quantile
+// computation.
+type numericProfile struct {
+	col, x string
+	opts   statsOptions
+
+	fractions                                                    []float64
+	minVal, maxVal, avgVal, stddev, variance, skewness, kurtosis sql.NullFloat64
+	nullCount, valueCount, distinctCount                         int
+	zeroCount, negativeCount                                     int
+	quantiles                                                    interface{}
+
+	strategy string
+	lower    []float64
+	hist     *histogramRequest
+}
 
-	var minVal, maxVal, avgVal sql.NullFloat64
-	var nullCount int
-	if err := db.DB.QueryRow(q).Scan(&minVal, &maxVal, &avgVal, &nullCount); err != nil {
-		return nil, nil, err
+func (p *numericProfile) aggregates() ([]string, []interface{}) {
+	p.fractions = append([]float64{0.25, 0.5, 0.75}, p.opts.Percentiles...)
+	if p.opts.Histogram == "quantile" {
+		for i := 1; i < p.opts.Buckets; i++ {
+			p.fractions = append(p.fractions, float64(i)/float64(p.opts.Buckets))
+		}
+	}
+	list := make([]string, len(p.fractions))
+	for i, f := range p.fractions {
+		list[i] = strconv.FormatFloat(f, 'g', -1, 64)
 	}
+	col, x := p.col, p.x
+	return []string{
+		"MIN(" + x + ")", "MAX(" + x + ")", "AVG(" + x + ")",
+		"COUNT(*) - COUNT(" + col + ")", "COUNT(" + col + ")", "COUNT(DISTINCT " + col + ")",
+		"COUNT(*) FILTER (WHERE " + col + " = 0)", "COUNT(*) FILTER (WHERE " + col + " < 0)",
+		"STDDEV_SAMP(" + x + ")", "VAR_SAMP(" + x + ")", "SKEWNESS(" + x + ")", "KURTOSIS(" + x + ")",
+		"QUANTILE_CONT(" + x + ", [" + strings.Join(list, ", ") + "])",
+	}, []interface{}{
+		&p.minVal, &p.maxVal, &p.avgVal, &p.nullCount, &p.valueCount, &p.distinctCount,
+		&p.zeroCount, &p.negativeCount, &p.stddev, &p.variance, &p.skewness, &p.kurtosis, &p.quantiles,
+	}
+}
 
-	if !minVal.Valid {
+func (p *numericProfile) plan(plan *profilePlan) {
+	p.hist = nil
+	values, _ := p.quantiles.([]interface{})
+	if !p.minVal.Valid || p.minVal.Float64 == p.maxVal.Float64 || len(values) != len(p.fractions) {
+		return
+	}
+	q := make([]float64, len(values))
+	for i, v := range values {
+		q[i], _ = v.(float64)
+	}
+	mn, mx := p.minVal.Float64, p.maxVal.Float64
+
+	var edges []float64
+	p.strategy = p.opts.Histogram
+	switch p.strategy {
+	case "quantile":
+		edges = append([]float64{mn}, q[3+len(p.opts.Percentiles):]...)
+	case "log":
+		if mn > 0 {
+			lo, hi := math.Log(mn), math.Log(mx)
+			edges = []float64{mn}
+			for i := 1; i < p.opts.Buckets; i++ {
+				edges = append(edges, math.Exp(lo+(hi-lo)*float64(i)/float64(p.opts.Buckets)))
+			}
+		} else {
+			p.strategy = "equal-width" // log scale needs positive values
+			edges = equalWidthEdges(mn, mx, p.opts.Buckets)
+		}
+	case "auto":
+		var k int
+		p.strategy, k = autoBuckets(mn, mx, q[2]-q[0], p.valueCount)
+		edges = equalWidthEdges(mn, mx, k)
+	default:
+		edges = equalWidthEdges(mn, mx, p.opts.Buckets)
+	}
+	p.lower = distinctEdges(edges, mx)
+	p.hist = plan.histogram(p.x, p.lower)
+}
+
+func (p *numericProfile) result() (fiber.Map, []fiber.Map) {
+	if !p.minVal.Valid {
 		// All nulls
 		return fiber.Map{
-			"min":       nil,
-			"max":       nil,
-			"mean":      nil,
-			"nullCount": nullCount,
-		}, []fiber.Map{}, nil
+			"min":           nil,
+			"max":           nil,
+			"mean":          nil,
+			"nullCount":     p.nullCount,
+			"median":        nil,
+			"q1":            nil,
+			"q3":            nil,
+			"percentiles":   []fiber.Map{},
+			"stddev":        nil,
+			"variance":      nil,
+			"skewness":      nil,
+			"kurtosis":      nil,
+			"zeroCount":     0,
+			"negativeCount": 0,
+			"distinctCount": 0,
+		}, []fiber.Map{}
 	}
 
-	stats := fiber.Map{
-		"min":       minVal.Float64,
-		"max":       maxVal.Float64,
-		"mean":      math.Round(avgVal.Float64*1000) / 1000,
-		"nullCount": nullCount,
+	values, _ := p.quantiles.([]interface{})
+	quantile := func(i int) interface{} {
+		if i < len(values) {
+			if f, ok := values[i].(float64); ok {
+				return round3(f)
+			}
+		}
+		return nil
+	}
+	pcts := make([]fiber.Map, len(p.opts.Percentiles))
+	for i, pct := range p.opts.Percentiles {
+		pcts[i] = fiber.Map{"percentile": pct, "value": quantile(3 + i)}
 	}
 
-	// Build histogram with 10 equal-width buckets
-	mn := minVal.Float64
-	mx := maxVal.Float64
-	const numBuckets = 10
-	dist := make([]fiber.Map, 0, numBuckets)
+	stats := fiber.Map{
+		"min":           p.minVal.Float64,
+		"max":           p.maxVal.Float64,
+		"mean":          round3(p.avgVal.Float64),
+		"nullCount":     p.nullCount,
+		"median":        quantile(1),
+		"q1":            quantile(0),
+		"q3":            quantile(2),
+		"percentiles":   pcts,
+		"stddev":        nullableRound3(p.stddev),
+		"variance":      nullableRound3(p.variance),
+		"skewness":      nullableRound3(p.skewness),
+		"kurtosis":      nullableRound3(p.kurtosis), // excess kurtosis
+		"zeroCount":     p.zeroCount,
+		"negativeCount": p.negativeCount,
+		"distinctCount": p.distinctCount,
+	}
 
+	mn, mx := p.minVal.Float64, p.maxVal.Float64
 	if mn == mx {
 		// Single bucket
-		var cnt int
-		cntQ := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE "%s" IS NOT NULL`, db.TableName, colName)
-		if err := db.DB.QueryRow(cntQ).Scan(&cnt); err != nil {
-			return stats, dist, nil
-		}
-		dist = append(dist, fiber.Map{"bucketMin": mn, "bucketMax": mx, "count": cnt})
-		return stats, dist, nil
+		stats["histogram"] = fiber.Map{"strategy": p.opts.Histogram, "buckets": 1}
+		return stats, []fiber.Map{{"bucketMin": mn, "bucketMax": mx, "count": p.valueCount}}
 	}
+	stats["histogram"] = fiber.Map{"strategy": p.strategy, "buckets": len(p.lower)}
+	return stats, histogramBuckets(p.lower, mx, p.hist)
+}
 
-	bucketWidth := (mx - mn) / float64(numBuckets)
+// autoBuckets picks the number of equal-width buckets for n values spanning
+// mn to mx with the given IQR, between 1 and maxBuckets.
+func autoBuckets(mn, mx, iqr float64, n int) (strategy string, k int) {
+	var f float64
+	if iqr > 0 {
+		strategy = "freedman-diaconis"
+		f = math.Ceil((mx - mn) / (2 * iqr / math.Cbrt(float64(n))))
+	} else {
+		strategy = "sturges"
+		f = math.Ceil(math.Log2(float64(n))) + 1
+	}
+	// Clamp before converting: a huge range over a tiny IQR overflows int
+	if !(f >= 1) {
+		return strategy, 1
+	}
+	return strategy, int(min(f, maxBuckets))
+}
 
-	histQ := fmt.Sprintf(
-		`SELECT CASE WHEN CAST(("%s" - %f) / %f AS INTEGER) >= %d THEN %d ELSE CAST(("%s" - %f) / %f AS INTEGER) END AS bucket, COUNT(*) FROM %s WHERE "%s" IS NOT NULL GROUP BY bucket ORDER BY bucket`,
-		colName, mn, bucketWidth, numBuckets, numBuckets-1,
-		colName, mn, bucketWidth,
-		db.TableName, colName,
-	)
+// equalWidthEdges returns the lower boundaries of n equal-width buckets.
+func equalWidthEdges(mn, mx float64, n int) []float64 {
+	edges := make([]float64, n)
+	for i := range edges {
+		edges[i] = mn + (mx-mn)*float64(i)/float64(n)
+	}
+	return edges
+}
 
-	hRows, err := db.DB.Query(histQ)
-	if err != nil {
-		return stats, dist, nil
+// distinctEdges drops repeated boundaries, which ties (quantiles) and
+// rounding (tiny ranges) can produce.
+func distinctEdges(edges []float64, mx float64) []float64 {
+	lower := edges[:1]
+	for _, e := range edges[1:] {
+		if e > lower[len(lower)-1] && e < mx {
+			lower = append(lower, e)
+		}
 	}
-	defer hRows.Close()
+	return lower
+}
 
-	// Pre-fill all buckets with 0
-	buckets := make([]int, numBuckets)
-	for hRows.Next() {
-		var bucket, cnt int
-		if err := hRows.Scan(&bucket, &cnt); err != nil {
-			continue
+// histogramBuckets pairs bucket boundaries with their counts. The
+// distribution is empty if the counts couldn't be computed.
+func histogramBuckets(lower []float64, mx float64, hist *histogramRequest) []fiber.Map {
+	if hist == nil || hist.counts == nil {
+		return []fiber.Map{}
+	}
+	dist := make([]fiber.Map, len(lower))
+	for i, bMin := range lower {
+		bMax := mx
+		if i+1 < len(lower) {
+			bMax = lower[i+1]
 		}
-		if bucket >= 0 && bucket < numBuckets {
-			buckets[bucket] = cnt
+		dist[i] = fiber.Map{"bucketMin": bMin, "bucketMax": bMax, "count": hist.counts[i]}
+	}
+	return dist
+}
+
+// textProfile covers TEXT columns, which include nested types.
+type textProfile struct {
+	col string
+
+	uniqueCount, nullCount int
+	top                    *groupRequest
+}
+
+func (p *textProfile) aggregates() ([]string, []interface{}) {
+	return []string{"COUNT(DISTINCT " + p.col + ")", "COUNT(*) - COUNT(" + p.col + ")"},
+		[]interface{}{&p.uniqueCount, &p.nullCount}
+}
+
+func (p *textProfile) plan(plan *profilePlan) {
+	p.top = plan.group("CAST("+p.col+" AS VARCHAR)", topValuesLimit)
+}
+
+func (p *textProfile) result() (fiber.Map, []fiber.Map) {
+	stats := fiber.Map{
+		"uniqueCount": p.uniqueCount,
+		"nullCount":   p.nullCount,
+	}
+	if p.top.rows == nil {
+		return stats, []fiber.Map{}
+	}
+	// Most frequent first, ties in value order so the list is stable
+	sort.Slice(p.top.rows, func(i, j int) bool {
+		a, b := p.top.rows[i], p.top.rows[j]
+		if a.count != b.count {
+			return a.count > b.count
 		}
+		return fmt.Sprint(a.key) < fmt.Sprint(b.key)
+	})
+	topValues := make([]fiber.Map, len(p.top.rows))
+	dist := make([]fiber.Map, len(p.top.rows))
+	for i, r := range p.top.rows {
+		topValues[i] = fiber.Map{"value": r.key, "count": r.count}
+		dist[i] = fiber.Map{"value": r.key, "count": r.count}
+	}
+	stats["topValues"] = topValues
+	return stats, dist
+}
+
+var weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// temporalGrain picks a histogram granularity that gives a readable number of
+// buckets for the span of a column: hours for timestamps within two days,
+// then days, weeks and months, and years beyond 30 years.
+func temporalGrain(span time.Duration, class string) string {
+	days := span.Hours() / 24
+	switch {
+	case class == "TIMESTAMP" && days <= 2:
+		return "hour"
+	case days <= 92:
+		return "day"
+	case days <= 2*366:
+		return "week"
+	case days <= 30*366:
+		return "month"
+	default:
+		return "year"
 	}
+}
 
-	for i := 0; i < numBuckets; i++ {
-		bMin := mn + float64(i)*bucketWidth
-		bMax := mn + float64(i+1)*bucketWidth
-		dist = append(dist, fiber.Map{
-			"bucketMin": math.Round(bMin*1000) / 1000,
-			"bucketMax": math.Round(bMax*1000) / 1000,
-			"count":     buckets[i],
-		})
+// truncateTime and addGrain match DuckDB's date_trunc and interval
+// arithmetic, to lay out the buckets of a temporal histogram. Weeks start on
+// Monday.
+func truncateTime(t time.Time, grain string) time.Time {
+	y, m, d := t.Date()
+	switch grain {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location())
 	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
 
-	return stats, dist, nil
+func addGrain(t time.Time, grain string) time.Time {
+	switch grain {
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "year":
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 0, 1)
 }
 
-func textStats(colName string) (fiber.Map, []fiber.Map, error) {
-	q := fmt.Sprintf(
-		`SELECT COUNT(DISTINCT "%s"), SUM(CASE WHEN "%s" IS NULL THEN 1 ELSE 0 END) FROM %s`,
-		colName, colName, db.TableName,
-	)
+// temporalProfile covers DATE and TIMESTAMP columns: the range, a histogram
+// at an automatically chosen granularity, and day-of-week (and for
+// timestamps, hour-of-day) distributions. Values are compared as TIMESTAMP,
+// which also covers TIMESTAMP WITH TIME ZONE.
+type temporalProfile struct {
+	col, ts, class string
 
-	var uniqueCount, nullCount int
-	if err := db.DB.QueryRow(q).Scan(&uniqueCount, &nullCount); err != nil {
-		return nil, nil, err
+	minStr, maxStr           sql.NullString
+	lo, hi                   sql.NullTime
+	nullCount, distinctCount int
+
+	grain               string
+	starts              []time.Time
+	buckets, dow, hours *groupRequest
+}
+
+func (p *temporalProfile) aggregates() ([]string, []interface{}) {
+	col, ts := p.col, p.ts
+	return []string{
+		"CAST(MIN(" + col + ") AS VARCHAR)", "CAST(MAX(" + col + ") AS VARCHAR)", "MIN(" + ts + ")", "MAX(" + ts + ")",
+		"COUNT(*) - COUNT(" + col + ")", "COUNT(DISTINCT " + col + ")",
+	}, []interface{}{
+		&p.minStr, &p.maxStr, &p.lo, &p.hi, &p.nullCount, &p.distinctCount,
 	}
+}
 
-	// Top 10 values
-	topQ := fmt.Sprintf(
-		`SELECT "%s", COUNT(*) AS cnt FROM %s WHERE "%s" IS NOT NULL GROUP BY "%s" ORDER BY cnt DESC LIMIT 10`,
-		colName, db.TableName, colName, colName,
-	)
+func (p *temporalProfile) plan(plan *profilePlan) {
+	p.buckets, p.dow, p.hours = nil, nil, nil
+	if !p.lo.Valid {
+		return
+	}
+	p.grain = temporalGrain(p.hi.Time.Sub(p.lo.Time), p.class)
+	p.starts = nil
+	for b := truncateTime(p.lo.Time, p.grain); !b.After(p.hi.Time); b = addGrain(b, p.grain) {
+		p.starts = append(p.starts, b)
+	}
+	p.buckets = plan.group("date_trunc('"+p.grain+"', "+p.ts+")", len(p.starts))
+	p.dow = plan.group("isodow("+p.ts+")", 7)
+	if p.class == "TIMESTAMP" {
+		p.hours = plan.group("hour("+p.ts+")", 24)
+	}
+}
 
-	tRows, err := db.DB.Query(topQ)
-	if err != nil {
-		return fiber.Map{"uniqueCount": uniqueCount, "nullCount": nullCount}, []fiber.Map{}, nil
+func (p *temporalProfile) result() (fiber.Map, []fiber.Map) {
+	if !p.lo.Valid {
+		// All nulls
+		return fiber.Map{
+			"min":           nil,
+			"max":           nil,
+			"rangeDays":     nil,
+			"nullCount":     p.nullCount,
+			"distinctCount": 0,
+			"granularity":   nil,
+			"dayOfWeek":     []fiber.Map{},
+		}, []fiber.Map{}
 	}
-	defer tRows.Close()
 
-	var topValues []fiber.Map
-	var dist []fiber.Map
-	for tRows.Next() {
-		var val string
-		var cnt int
-		if err := tRows.Scan(&val, &cnt); err != nil {
-			continue
+	stats := fiber.Map{
+		"min":           p.minStr.String,
+		"max":           p.maxStr.String,
+		"rangeDays":     round3(p.hi.Time.Sub(p.lo.Time).Hours() / 24),
+		"nullCount":     p.nullCount,
+		"distinctCount": p.distinctCount,
+		"granularity":   p.grain,
+	}
+	dows := make([]int, 7)
+	for _, r := range p.dow.rows {
+		if d, ok := r.key.(int64); ok && d >= 1 && d <= 7 {
+			dows[d-1] = r.count
 		}
-		topValues = append(topValues, fiber.Map{"value": val, "count": cnt})
-		dist = append(dist, fiber.Map{"value": val, "count": cnt})
+	}
+	dayOfWeek := make([]fiber.Map, 7)
+	for i, n := range dows {
+		dayOfWeek[i] = fiber.Map{"value": weekdays[i], "count": n}
+	}
+	stats["dayOfWeek"] = dayOfWeek
+	if p.hours != nil {
+		stats["hourOfDay"] = hourCounts(p.hours)
 	}
 
-	if topValues == nil {
-		topValues = []fiber.Map{}
+	if p.buckets.rows == nil {
+		return stats, []fiber.Map{}
 	}
-	if dist == nil {
-		dist = []fiber.Map{}
+	counts := map[int64]int{}
+	for _, r := range p.buckets.rows {
+		if t, ok := r.key.(time.Time); ok {
+			counts[t.Unix()] = r.count
+		}
+	}
+	layout := "2006-01-02 15:04:05"
+	if p.class == "DATE" {
+		layout = "2006-01-02"
+	}
+	dist := make([]fiber.Map, len(p.starts))
+	for i, b := range p.starts {
+		dist[i] = fiber.Map{
+			"bucketMin": b.Format(layout),
+			"bucketMax": addGrain(b, p.grain).Format(layout),
+			"count":     counts[b.Unix()],
+		}
 	}
+	return stats, dist
+}
 
+func hourCounts(g *groupRequest) []fiber.Map {
+	hours := make([]int, 24)
+	for _, r := range g.rows {
+		if h, ok := r.key.(int64); ok && h >= 0 && h < 24 {
+			hours[h] = r.count
+		}
+	}
+	out := make([]fiber.Map, len(hours))
+	for h, n := range hours {
+		out[h] = fiber.Map{"value": h, "count": n}
+	}
+	return out
+}
+
+// timeProfile covers TIME columns; their distribution is by hour of day.
+type timeProfile struct {
+	col string
+
+	minStr, maxStr           sql.NullString
+	nullCount, distinctCount int
+	hours                    *groupRequest
+}
+
+func (p *timeProfile) aggregates() ([]string, []interface{}) {
+	col := p.col
+	return []string{
+		"CAST(MIN(" + col + ") AS VARCHAR)", "CAST(MAX(" + col + ") AS VARCHAR)",
+		"COUNT(*) - COUNT(" + col + ")", "COUNT(DISTINCT " + col + ")",
+	}, []interface{}{
+		&p.minStr, &p.maxStr, &p.nullCount, &p.distinctCount,
+	}
+}
+
+func (p *timeProfile) plan(plan *profilePlan) {
+	p.hours = plan.group("hour("+p.col+")", 24)
+}
+
+func (p *timeProfile) result() (fiber.Map, []fiber.Map) {
+	return fiber.Map{
+		"min":           nullableString(p.minStr),
+		"max":           nullableString(p.maxStr),
+		"nullCount":     p.nullCount,
+		"distinctCount": p.distinctCount,
+		"hourOfDay":     hourCounts(p.hours),
+	}, hourCounts(p.hours)
+}
+
+// intervalProfile covers INTERVAL columns. The histogram is equal-width over
+// the length in seconds, so months count as 30 days.
+type intervalProfile struct {
+	col, secs string
+	opts      statsOptions
+
+	minStr, maxStr                       sql.NullString
+	minSecs, maxSecs, meanSecs           sql.NullFloat64
+	nullCount, valueCount, distinctCount int
+
+	lower []float64
+	hist  *histogramRequest
+}
+
+func (p *intervalProfile) aggregates() ([]string, []interface{}) {
+	col, secs := p.col, p.secs
+	return []string{
+		"CAST(MIN(" + col + ") AS VARCHAR)", "CAST(MAX(" + col + ") AS VARCHAR)",
+		"MIN(" + secs + ")", "MAX(" + secs + ")", "AVG(" + secs + ")",
+		"COUNT(*) - COUNT(" + col + ")", "COUNT(" + col + ")", "COUNT(DISTINCT " + col + ")",
+	}, []interface{}{
+		&p.minStr, &p.maxStr, &p.minSecs, &p.maxSecs, &p.meanSecs, &p.nullCount, &p.valueCount, &p.distinctCount,
+	}
+}
+
+func (p *intervalProfile) plan(plan *profilePlan) {
+	p.hist = nil
+	if !p.minSecs.Valid || p.minSecs.Float64 == p.maxSecs.Float64 {
+		return
+	}
+	p.lower = distinctEdges(equalWidthEdges(p.minSecs.Float64, p.maxSecs.Float64, p.opts.Buckets), p.maxSecs.Float64)
+	p.hist = plan.histogram(p.secs, p.lower)
+}
+
+func (p *intervalProfile) result() (fiber.Map, []fiber.Map) {
 	stats := fiber.Map{
-		"uniqueCount": uniqueCount,
-		"nullCount":   nullCount,
-		"topValues":   topValues,
+		"min":           nullableString(p.minStr),
+		"max":           nullableString(p.maxStr),
+		"minSeconds":    nullableRound3(p.minSecs),
+		"maxSeconds":    nullableRound3(p.maxSecs),
+		"meanSeconds":   nullableRound3(p.meanSecs),
+		"nullCount":     p.nullCount,
+		"distinctCount": p.distinctCount,
 	}
+	if !p.minSecs.Valid {
+		return stats, []fiber.Map{}
+	}
+	mn, mx := p.minSecs.Float64, p.maxSecs.Float64
+	if mn == mx {
+		return stats, []fiber.Map{{"bucketMin": mn, "bucketMax": mx, "count": p.valueCount}}
+	}
+	return stats, histogramBuckets(p.lower, mx, p.hist)
+}
 
-	return stats, dist, nil
+type booleanProfile struct {
+	col string
+
+	trueCount, falseCount, nullCount int
+}
+
+func (p *booleanProfile) aggregates() ([]string, []interface{}) {
+	col := p.col
+	return []string{
+		"COUNT(*) FILTER (WHERE " + col + ")", "COUNT(*) FILTER (WHERE NOT " + col + ")", "COUNT(*) - COUNT(" + col + ")",
+	}, []interface{}{
+		&p.trueCount, &p.falseCount, &p.nullCount,
+	}
+}
+
+func (p *booleanProfile) plan(*profilePlan) {}
+
+func (p *booleanProfile) result() (fiber.Map, []fiber.Map) {
+	return fiber.Map{
+		"trueCount":  p.trueCount,
+		"falseCount": p.falseCount,
+		"nullCount":  p.nullCount,
+	}, []fiber.Map{
+		{"value": "true", "count": p.trueCount},
+		{"value": "false", "count": p.falseCount},
+	}
 }
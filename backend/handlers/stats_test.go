@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestEqualWidthEdges(t *testing.T) {
+	tests := []struct {
+		mn, mx float64
+		n      int
+		want   []float64
+	}{
+		{0, 10, 5, []float64{0, 2, 4, 6, 8}},
+		{-1, 1, 4, []float64{-1, -0.5, 0, 0.5}},
+		{3, 4, 1, []float64{3}},
+	}
+	for _, tt := range tests {
+		if got := equalWidthEdges(tt.mn, tt.mx, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("equalWidthEdges(%v, %v, %d) = %v, want %v", tt.mn, tt.mx, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDistinctEdges(t *testing.T) {
+	tests := []struct {
+		edges []float64
+		mx    float64
+		want  []float64
+	}{
+		{[]float64{0, 1, 2}, 3, []float64{0, 1, 2}},
+		// Tied quantiles
+		{[]float64{0, 1, 1, 1, 2}, 3, []float64{0, 1, 2}},
+		// Quantiles at the maximum would make empty top buckets
+		{[]float64{0, 5, 9, 9}, 9, []float64{0, 5}},
+		// A range too small to split
+		{[]float64{1, 1, 1}, 1 + 1e-16, []float64{1}},
+	}
+	for _, tt := range tests {
+		edges := append([]float64(nil), tt.edges...)
+		if got := distinctEdges(edges, tt.mx); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("distinctEdges(%v, %v) = %v, want %v", tt.edges, tt.mx, got, tt.want)
+		}
+	}
+}
+
+func TestAutoBuckets(t *testing.T) {
+	tests := []struct {
+		mn, mx, iqr  float64
+		n            int
+		wantStrategy string
+		wantK        int
+	}{
+		// Bin width 2 * 50 / cbrt(1000) = 10
+		{0, 100, 50, 1000, "freedman-diaconis", 10},
+		{0, 1, 1, 1, "freedman-diaconis", 1},
+		{0, 1e6, 1, 1000000, "freedman-diaconis", maxBuckets},
+		{-1e300, 1e300, 1e-300, 10, "freedman-diaconis", maxBuckets},
+		{0, 10, 0, 1000, "sturges", 11},
+		{0, 10, 0, 1, "sturges", 1},
+		{0, 10, 0, 1 << 62, "sturges", 63},
+	}
+	for _, tt := range tests {
+		strategy, k := autoBuckets(tt.mn, tt.mx, tt.iqr, tt.n)
+		if strategy != tt.wantStrategy || k != tt.wantK {
+			t.Errorf("autoBuckets(%v, %v, %v, %d) = %s, %d; want %s, %d", tt.mn, tt.mx, tt.iqr, tt.n, strategy, k, tt.wantStrategy, tt.wantK)
+		}
+	}
+}
+
+func TestNumericProfile(t *testing.T) {
+	openTestDB(t)
+	// Sorted: -4 -1 0 0 2 3 5 8 10, plus a NULL. Quantiles interpolate
+	// between the values at (n-1)p = 8p, so q1, the median and q3 fall on
+	// the 3rd, 5th and 7th values; p10 is -4 + 0.8*3 and p90 8 + 0.2*2.
+	// The sum is 23 and the sum of squares 219, so the sample variance is
+	// (219 - 23*23/9) / 8.
+	if _, err := db.DB.Exec(`CREATE TABLE nums AS
+		SELECT CAST(x AS INTEGER) AS x FROM (VALUES (3), (-1), (0), (10), (NULL), (2), (-4), (8), (0), (5)) AS v(x)`); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	opts := statsOptions{Percentiles: []float64{0.1, 0.9}, Histogram: "equal-width", Buckets: 2}
+	cols, err := describeProfiled(ctx, "nums", opts)
+	if err != nil || len(cols) != 1 {
+		t.Fatalf("got %d columns, err %v", len(cols), err)
+	}
+	p := &profiler{ctx: ctx, table: "nums"}
+	if err := p.profile(cols); err != nil {
+		t.Fatal(err)
+	}
+	stats, dist := cols[0].profile.result()
+
+	want := fiber.Map{
+		"min":           -4.0,
+		"max":           10.0,
+		"mean":          2.556,
+		"nullCount":     1,
+		"median":        2.0,
+		"q1":            0.0,
+		"q3":            5.0,
+		"variance":      20.028,
+		"stddev":        4.475,
+		"zeroCount":     2,
+		"negativeCount": 2,
+		"distinctCount": 8,
+	}
+	for key, v := range want {
+		if stats[key] != v {
+			t.Errorf("%s = %v, want %v", key, stats[key], v)
+		}
+	}
+	wantPcts := []fiber.Map{{"percentile": 0.1, "value": -1.6}, {"percentile": 0.9, "value": 8.4}}
+	if !reflect.DeepEqual(stats["percentiles"], wantPcts) {
+		t.Errorf("percentiles = %v, want %v", stats["percentiles"], wantPcts)
+	}
+
+	// Two buckets of width 7: [-4, 3) and [3, 10]
+	if len(dist) != 2 {
+		t.Fatalf("got %d buckets", len(dist))
+	}
+	for i, want := range []string{"-4 3 5", "3 10 4"} {
+		if got := fmt.Sprint(dist[i]["bucketMin"], dist[i]["bucketMax"], dist[i]["count"]); got != want {
+			t.Errorf("bucket %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestTemporalGrain(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		span  time.Duration
+		class string
+		want  string
+	}{
+		{time.Hour, "TIMESTAMP", "hour"},
+		{2 * day, "TIMESTAMP", "hour"},
+		{2*day + time.Hour, "TIMESTAMP", "day"},
+		{day, "DATE", "day"},
+		{92 * day, "DATE", "day"},
+		{93 * day, "DATE", "week"},
+		{732 * day, "TIMESTAMP", "week"},
+		{733 * day, "DATE", "month"},
+		{30 * 366 * day, "DATE", "month"},
+		{30*366*day + day, "DATE", "year"},
+	}
+	for _, tt := range tests {
+		if got := temporalGrain(tt.span, tt.class); got != tt.want {
+			t.Errorf("temporalGrain(%v, %s) = %s, want %s", tt.span, tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateAndAddGrain(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		t, grain, start, next string
+	}{
+		{"2024-03-06 15:45", "hour", "2024-03-06 15:00", "2024-03-06 16:00"},
+		{"2024-12-31 23:59", "hour", "2024-12-31 23:00", "2025-01-01 00:00"},
+		{"2024-02-28 08:00", "day", "2024-02-28 00:00", "2024-02-29 00:00"},
+		{"2024-12-31 08:00", "day", "2024-12-31 00:00", "2025-01-01 00:00"},
+		// Weeks start on Monday, so Sunday belongs to the week before
+		{"2024-03-04 00:00", "week", "2024-03-04 00:00", "2024-03-11 00:00"},
+		{"2024-03-10 22:00", "week", "2024-03-04 00:00", "2024-03-11 00:00"},
+		{"2024-03-06 12:00", "week", "2024-03-04 00:00", "2024-03-11 00:00"},
+		// 2025-01-01 is a Wednesday: its week starts in 2024
+		{"2025-01-01 09:00", "week", "2024-12-30 00:00", "2025-01-06 00:00"},
+		{"2024-02-29 12:00", "month", "2024-02-01 00:00", "2024-03-01 00:00"},
+		{"2024-12-31 12:00", "month", "2024-12-01 00:00", "2025-01-01 00:00"},
+		{"2024-12-31 12:00", "year", "2024-01-01 00:00", "2025-01-01 00:00"},
+	}
+	for _, tt := range tests {
+		start := truncateTime(at(tt.t), tt.grain)
+		if !start.Equal(at(tt.start)) {
+			t.Errorf("truncateTime(%s, %s) = %v, want %s", tt.t, tt.grain, start, tt.start)
+		}
+		if next := addGrain(start, tt.grain); !next.Equal(at(tt.next)) {
+			t.Errorf("addGrain(%s, %s) = %v, want %s", tt.start, tt.grain, next, tt.next)
+		}
+	}
+}
+
+func TestTemporalBucketsMatchDateTrunc(t *testing.T) {
+	openTestDB(t)
+	// One column per grain, each crossing a month or year boundary
+	if _, err := db.DB.Exec(`CREATE TABLE times AS SELECT
+		DATE '2024-02-20' + CAST(range % 20 AS INTEGER) AS d,
+		TIMESTAMP '2024-12-31 20:00' + range * INTERVAL 17 MINUTE AS h,
+		DATE '2024-11-15' + CAST(range AS INTEGER) AS w,
+		TIMESTAMP '2022-12-15 13:00' + range * INTERVAL 6 DAY AS m
+		FROM range(126)`); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cols, err := describeProfiled(ctx, "times", statsOptions{Histogram: "equal-width", Buckets: defaultBuckets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &profiler{ctx: ctx, table: "times"}
+	if err := p.profile(cols); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, grain := range []string{"day", "hour", "week", "month"} {
+		tp := cols[i].profile.(*temporalProfile)
+		if tp.grain != grain {
+			t.Errorf("%s: grain %s, want %s", cols[i].Name, tp.grain, grain)
+			continue
+		}
+		starts := map[int64]bool{}
+		for _, b := range tp.starts {
+			starts[b.Unix()] = true
+		}
+		for _, r := range tp.buckets.rows {
+			key, ok := r.key.(time.Time)
+			if !ok || !starts[key.Unix()] {
+				t.Errorf("%s: DuckDB bucket %v is not one of the %d Go bucket starts", cols[i].Name, r.key, len(tp.starts))
+			}
+		}
+		_, dist := tp.result()
+		total := 0
+		for _, b := range dist {
+			total += b["count"].(int)
+		}
+		if total != 126 {
+			t.Errorf("%s: buckets hold %d rows, want 126", cols[i].Name, total)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"artemisgo/cache"
+	"artemisgo/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Profiles are computed in the background and stamped with the version of
+// the table they were computed at (see db.TableVersion). Stats with the
+// default options are kept up to date for the uploaded table and every table
+// they have been requested for: after a change, the tables it affects are
+// profiled again, so the sidebar rarely waits for a scan. Stats with other
+// options are computed on request and kept for a limited number of option
+// sets, least recently used dropped first.
+
+// statsDebounce lets a burst of changes (a script, a schedule writing several
+// tables) settle before the tables are profiled again.
+const statsDebounce = 500 * time.Millisecond
+
+// maxOnDemandStats bounds the profiles kept for non-default options.
+const maxOnDemandStats = 32
+
+// Stats status values: fresh stats match the current data; computing stats
+// are from an older version and are being recomputed; stale stats are from
+// an older version and recomputing them failed.
+const (
+	statsFresh     = "fresh"
+	statsComputing = "computing"
+	statsStale     = "stale"
+)
+
+var errStatsBusy = errors.New("timed out waiting for a stats slot")
+
+type statsEntry struct {
+	table    string
+	opts     statsOptions
+	result   fiber.Map // nil until the first profile completes
+	version  int64     // table version the result reflects
+	computed time.Time
+	refresh  bool // profile again whenever the table changes
+
+	running bool
+	done    chan struct{} // closed when the running profile finishes
+	err     error         // of the last profile, at table version errAt
+	errAt   int64
+}
+
+var (
+	statsMu       sync.Mutex
+	statsDefaults = map[string]*statsEntry{}       // by lower-case table name
+	statsOnDemand = cache.New(maxOnDemandStats, 0) // by statsKey, each sized 1
+	statsTimer    *time.Timer
+)
+
+func initStatsCache() error {
+	db.OnVersionChange(func(int64) {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		if statsTimer == nil {
+			statsTimer = time.AfterFunc(statsDebounce, refreshStats)
+		} else {
+			statsTimer.Reset(statsDebounce)
+		}
+	})
+	return nil
+}
+
+func defaultStatsOptions() statsOptions {
+	return statsOptions{Percentiles: defaultPercentiles, Histogram: "equal-width", Buckets: defaultBuckets}
+}
+
+func statsKey(table string, opts statsOptions) string {
+	return fmt.Sprintf("%s|%v|%s|%d", strings.ToLower(table), opts.Percentiles, opts.Histogram, opts.Buckets)
+}
+
+// refreshStats starts profiling, with the default options, the uploaded
+// table and every kept table whose data has changed. Entries for tables that
+// no longer exist are dropped.
+func refreshStats() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if _, ok := db.LookupTable(db.TableName); ok {
+		defaultStatsEntry(db.TableName)
+	}
+	for key, e := range statsDefaults {
+		if _, ok := db.LookupTable(e.table); !ok {
+			delete(statsDefaults, key)
+			continue
+		}
+		if e.result == nil || e.version != db.TableVersion(e.table) {
+			e.start()
+		}
+	}
+}
+
+// defaultStatsEntry returns the kept entry for a table's default stats,
+// adding it if needed. statsMu must be held.
+func defaultStatsEntry(table string) *statsEntry {
+	key := strings.ToLower(table)
+	e := statsDefaults[key]
+	if e == nil {
+		e = &statsEntry{table: table, opts: defaultStatsOptions(), refresh: true}
+		statsDefaults[key] = e
+	}
+	return e
+}
+
+// start profiles the table in the background unless a profile is already
+// running. statsMu must be held.
+func (e *statsEntry) start() {
+	if e.running {
+		return
+	}
+	e.running = true
+	e.done = make(chan struct{})
+	go e.run()
+}
+
+func (e *statsEntry) run() {
+	result, version, err := e.profile()
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	e.running = false
+	close(e.done)
+	if err != nil {
+		log.Printf("Stats: profiling %s failed: %v", e.table, err)
+		e.err, e.errAt = err, version
+		return
+	}
+	e.result, e.version, e.computed, e.err = result, version, time.Now(), nil
+	if e.refresh && version != db.TableVersion(e.table) {
+		// The data changed while the profile ran; the refresh that follows
+		// the change may have found it still running
+		e.start()
+	}
+}
+
+// profile waits for a stats slot and profiles the table, returning the
+// table version the result reflects.
+func (e *statsEntry) profile() (fiber.Map, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.QueryTimeout)
+	defer cancel()
+	release, err := db.Admit(ctx, "", db.ClassStats)
+	if err != nil {
+		return nil, db.TableVersion(e.table), errStatsBusy
+	}
+	defer release()
+	version := db.TableVersion(e.table)
+	result, err := profileTable(ctx, e.table, e.opts)
+	return result, version, err
+}
+
+// cachedStats returns the latest profile of a table and its status. Stats
+// from an older version are returned at once while they are recomputed; only
+// a table that hasn't been profiled with these options waits for it.
+func cachedStats(table string, opts statsOptions) (fiber.Map, error) {
+	statsMu.Lock()
+	var e *statsEntry
+	if key := statsKey(table, opts); key == statsKey(table, defaultStatsOptions()) {
+		e = defaultStatsEntry(table)
+	} else if v, _, ok := statsOnDemand.Get(key); ok {
+		e = v.(*statsEntry)
+	} else {
+		e = &statsEntry{table: table, opts: opts}
+		statsOnDemand.Put(key, e, 1)
+	}
+	version := db.TableVersion(table)
+	if e.result == nil || (e.version != version && e.errAt != version) {
+		e.start()
+	}
+	if e.result == nil {
+		done := e.done
+		statsMu.Unlock()
+		<-done
+		statsMu.Lock()
+		if e.result == nil {
+			err := e.err
+			statsMu.Unlock()
+			return nil, err
+		}
+	}
+	defer statsMu.Unlock()
+
+	status := statsFresh
+	switch {
+	case e.version == db.TableVersion(table):
+	case e.running:
+		status = statsComputing
+	default:
+		status = statsStale
+	}
+	result := fiber.Map{
+		"status":         status,
+		"datasetVersion": e.version,
+		"computedAt":     e.computed,
+	}
+	for k, v := range e.result {
+		result[k] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"artemisgo/cache"
+	"artemisgo/db"
+	"context"
+	"testing"
+)
+
+func resetStatsCache() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statsDefaults = map[string]*statsEntry{}
+	statsOnDemand = cache.New(maxOnDemandStats, 0)
+}
+
+func TestStatsCacheInvalidatesPerTable(t *testing.T) {
+	openTestDB(t)
+	resetStatsCache()
+	ctx := context.Background()
+	for _, name := range []string{"a", "b"} {
+		if _, err := db.CreateDerived(ctx, name, "table", "SELECT range AS x FROM range(100)", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := cachedStats(name, defaultStatsOptions()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.BumpTableVersion("a")
+	refreshStats()
+	statsMu.Lock()
+	a, b := statsDefaults["a"], statsDefaults["b"]
+	aStarted := a.running || a.version == db.TableVersion("a")
+	bRunning := b.running
+	done := a.done
+	statsMu.Unlock()
+	if !aStarted || bRunning {
+		t.Errorf("after a change to a: a started=%v, b running=%v", aStarted, bRunning)
+	}
+	<-done
+
+	result, err := cachedStats("b", defaultStatsOptions())
+	if err != nil || result["status"] != statsFresh {
+		t.Errorf("b: status %v, err %v", result["status"], err)
+	}
+}
+
+func TestStatsCacheBoundsOptionSets(t *testing.T) {
+	openTestDB(t)
+	resetStatsCache()
+	if _, err := db.CreateDerived(context.Background(), "t", "table", "SELECT range AS x FROM range(100)", false); err != nil {
+		t.Fatal(err)
+	}
+	for buckets := 1; buckets <= maxOnDemandStats+8; buckets++ {
+		opts := defaultStatsOptions()
+		opts.Buckets = buckets
+		if buckets == defaultBuckets {
+			continue
+		}
+		if _, err := cachedStats("t", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := statsOnDemand.Stats().Entries; n != maxOnDemandStats {
+		t.Errorf("kept %d option sets, want %d", n, maxOnDemandStats)
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if statsDefaults["t"] != nil {
+		t.Error("non-default options added a default entry")
+	}
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// maxStructuredLimit caps the rows a structured query may ask for.
+const maxStructuredLimit = 10000
+
+// structuredQuery describes a query over one table without SQL. Filters are
+// combined with AND; a filter with "any" matches if any of its filters do.
+type structuredQuery struct {
+	Table      string           `json:"table"`
+	Select     []string         `json:"select"`
+	Filters    []queryFilter    `json:"filters"`
+	GroupBy    []string         `json:"groupBy"`
+	Aggregates []queryAggregate `json:"aggregates"`
+	Sort       []querySort      `json:"sort"`
+	Limit      *int             `json:"limit"`
+	Offset     int              `json:"offset"`
+}
+
+type queryFilter struct {
+	Column string          `json:"column"`
+	Op     string          `json:"op"`
+	Value  json.RawMessage `json:"value"`
+	Type   string          `json:"type"` // optional parameter type, as for query params
+	Any    []queryFilter   `json:"any"`
+}
+
+type queryAggregate struct {
+	Fn     string `json:"fn"`
+	Column string `json:"column"`
+	As     string `json:"as"`
+}
+
+type querySort struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+type structuredRequest struct {
+	structuredQuery
+	QueryID   string `json:"queryId"`
+	TimeoutMs int    `json:"timeoutMs"`
+	NoCache   bool   `json:"noCache"`
+	DryRun    bool   `json:"dryRun"` // compile only
+}
+
+// comparisonOps take one value.
+var comparisonOps = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<=",
+	"like": "LIKE", "ilike": "ILIKE",
+}
+
+// textOps take one string and match part of the column's text.
+var textOps = map[string]string{
+	"contains":    "contains",
+	"starts_with": "starts_with",
+	"ends_with":   "ends_with",
+}
+
+var aggregateFns = map[string]string{
+	"count":          "count(%s)",
+	"count_distinct": "count(DISTINCT %s)",
+	"sum":            "sum(%s)",
+	"avg":            "avg(%s)",
+	"min":            "min(%s)",
+	"max":            "max(%s)",
+	"median":         "median(%s)",
+	"stddev":         "stddev_samp(%s)",
+}
+
+// StructuredQuery compiles a JSON query description to SQL with quoted
+// identifiers and bound parameters, and runs it like an editor query. The
+// response carries the generated SQL and parameters along with the result.
+func StructuredQuery(c *fiber.Ctx) error {
+	var req structuredRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	sqlText, params, err := compileStructured(c.Context(), req.structuredQuery)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.DryRun {
+		return c.JSON(fiber.Map{"sql": sqlText, "params": params})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	result, err := runQuery(c, execSpec{
+		ID:      id,
+		SQL:     sqlText,
+		Params:  params,
+		Source:  "browse",
+		Timeout: requestTimeout(req.TimeoutMs),
+		NoCache: req.NoCache,
+	})
+	if err != nil {
+		return c.Status(errorStatus(err)).JSON(fiber.Map{"queryId": id, "sql": sqlText, "params": params, "error": err.Error()})
+	}
+	resp := fiber.Map{
+		"queryId":     id,
+		"sql":         sqlText,
+		"params":      params,
+		"columns":     result.Columns,
+		"columnTypes": result.Types,
+		"rows":        result.Rows,
+	}
+	if result.Cache != nil {
+		resp["cache"] = result.Cache
+	}
+	return c.JSON(resp)
+}
+
+type structuredCompiler struct {
+	columns map[string]string // lower-case name -> name as declared
+	params  map[string]paramValue
+	// exprs, if set, replaces columns as what filters may refer to
+	exprs map[string]namedExpr // by lower-case name
+}
+
+// namedExpr is a SQL expression filters can refer to by name.
+type namedExpr struct {
+	name string
+	sql  string
+}
+
+// compileStructured validates q against the table's columns and builds the
+// SQL. Every identifier is quoted and every value is a named parameter.
+func compileStructured(ctx context.Context, q structuredQuery) (string, *queryParams, error) {
+	if q.Table == "" {
+		q.Table = db.TableName
+	}
+	entry, ok := db.LookupTable(q.Table)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown table %q", q.Table)
+	}
+	cols, err := db.DescribeTable(ctx, entry.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	qc := &structuredCompiler{columns: map[string]string{}, params: map[string]paramValue{}}
+	for _, col := range cols {
+		qc.columns[strings.ToLower(col.Name)] = col.Name
+	}
+
+	// Output names that ORDER BY may refer to besides table columns
+	outputs := map[string]string{}
+	var selectList []string
+	grouped := len(q.GroupBy) > 0 || len(q.Aggregates) > 0
+	if grouped {
+		if len(q.Select) > 0 {
+			return "", nil, fmt.Errorf("select can't be combined with groupBy or aggregates; grouped columns are selected automatically")
+		}
+		for _, name := range q.GroupBy {
+			col, err := qc.column(name)
+			if err != nil {
+				return "", nil, err
+			}
+			selectList = append(selectList, db.QuoteIdent(col))
+		}
+		for _, agg := range q.Aggregates {
+			expr, alias, err := qc.aggregate(agg)
+			if err != nil {
+				return "", nil, err
+			}
+			if _, dup := outputs[strings.ToLower(alias)]; dup {
+				return "", nil, fmt.Errorf("duplicate aggregate name %q", alias)
+			}
+			outputs[strings.ToLower(alias)] = alias
+			selectList = append(selectList, expr+" AS "+db.QuoteIdent(alias))
+		}
+	} else {
+		for _, name := range q.Select {
+			col, err := qc.column(name)
+			if err != nil {
+				return "", nil, err
+			}
+			selectList = append(selectList, db.QuoteIdent(col))
+		}
+		if len(selectList) == 0 {
+			selectList = []string{"*"}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(selectList, ", "))
+	sb.WriteString("\nFROM " + db.QuoteIdent(entry.Name))
+
+	if len(q.Filters) > 0 {
+		cond, err := qc.conjunction(q.Filters, " AND ")
+		if err != nil {
+			return "", nil, err
+		}
+		sb.WriteString("\nWHERE " + cond)
+	}
+	if len(q.GroupBy) > 0 {
+		groups := make([]string, len(q.GroupBy))
+		for i, name := range q.GroupBy {
+			col, _ := qc.column(name)
+			groups[i] = db.QuoteIdent(col)
+		}
+		sb.WriteString("\nGROUP BY " + strings.Join(groups, ", "))
+	}
+
+	if len(q.Sort) > 0 {
+		keys := make([]string, len(q.Sort))
+		for i, s := range q.Sort {
+			name, ok := outputs[strings.ToLower(s.Column)]
+			if !ok {
+				col, err := qc.column(s.Column)
+				if err != nil {
+					return "", nil, err
+				}
+				if grouped && !containsFold(q.GroupBy, col) {
+					return "", nil, fmt.Errorf("can't sort by %s: it is neither grouped nor an aggregate", col)
+				}
+				name = col
+			}
+			keys[i] = db.QuoteIdent(name)
+			if s.Desc {
+				keys[i] += " DESC"
+			}
+		}
+		sb.WriteString("\nORDER BY " + strings.Join(keys, ", "))
+	}
+
+	limit := maxStructuredLimit
+	if q.Limit != nil {
+		if *q.Limit < 0 || *q.Limit > maxStructuredLimit {
+			return "", nil, fmt.Errorf("limit must be between 0 and %d", maxStructuredLimit)
+		}
+		limit = *q.Limit
+	}
+	if q.Offset < 0 {
+		return "", nil, fmt.Errorf("offset can't be negative")
+	}
+	fmt.Fprintf(&sb, "\nLIMIT %d", limit)
+	if q.Offset > 0 {
+		fmt.Fprintf(&sb, " OFFSET %d", q.Offset)
+	}
+
+	var params *queryParams
+	if len(qc.params) > 0 {
+		params = &queryParams{named: qc.params}
+	}
+	return sb.String(), params, nil
+}
+
+// column resolves a column name case-insensitively, as DuckDB does.
+func (qc *structuredCompiler) column(name string) (string, error) {
+	col, ok := qc.columns[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown column %q", name)
+	}
+	return col, nil
+}
+
+// operand resolves what a filter refers to, returning its SQL and its name.
+func (qc *structuredCompiler) operand(name string) (sqlText, declared string, err error) {
+	if qc.exprs != nil {
+		e, ok := qc.exprs[strings.ToLower(name)]
+		if !ok {
+			return "", "", fmt.Errorf("unknown dimension %q", name)
+		}
+		return "(" + e.sql + ")", e.name, nil
+	}
+	col, err := qc.column(name)
+	if err != nil {
+		return "", "", err
+	}
+	return db.QuoteIdent(col), col, nil
+}
+
+func (qc *structuredCompiler) aggregate(agg queryAggregate) (expr, alias string, err error) {
+	tmpl, ok := aggregateFns[strings.ToLower(agg.Fn)]
+	if !ok {
+		return "", "", fmt.Errorf("unknown aggregate %q", agg.Fn)
+	}
+	fn := strings.ToLower(agg.Fn)
+	arg := "*"
+	if agg.Column != "" {
+		col, err := qc.column(agg.Column)
+		if err != nil {
+			return "", "", err
+		}
+		arg = db.QuoteIdent(col)
+	} else if fn != "count" {
+		return "", "", fmt.Errorf("%s needs a column", fn)
+	}
+
+	alias = agg.As
+	if alias == "" {
+		alias = fn
+		if agg.Column != "" {
+			alias += "_" + agg.Column
+		}
+	}
+	return fmt.Sprintf(tmpl, arg), alias, nil
+}
+
+// bind adds a parameter and returns its placeholder. Typed values keep their
+// type, which queryParams.bind casts the placeholder to.
+func (qc *structuredCompiler) bind(value json.RawMessage, typ string) (string, error) {
+	v := paramValue{Value: value, Type: typ}
+	if _, err := v.coerce(); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("p%d", len(qc.params)+1)
+	qc.params[name] = v
+	return "$" + name, nil
+}
+
+func (qc *structuredCompiler) conjunction(filters []queryFilter, sep string) (string, error) {
+	conds := make([]string, len(filters))
+	for i, f := range filters {
+		cond, err := qc.filter(f)
+		if err != nil {
+			return "", err
+		}
+		conds[i] = cond
+	}
+	return strings.Join(conds, sep), nil
+}
+
+func (qc *structuredCompiler) filter(f queryFilter) (string, error) {
+	if len(f.Any) > 0 {
+		cond, err := qc.conjunction(f.Any, " OR ")
+		if err != nil {
+			return "", err
+		}
+		return "(" + cond + ")", nil
+	}
+
+	ident, col, err := qc.operand(f.Column)
+	if err != nil {
+		return "", err
+	}
+	op := strings.ToLower(f.Op)
+	wrap := func(err error) error {
+		return fmt.Errorf("filter on %s: %v", col, err)
+	}
+
+	switch {
+	case op == "is_null":
+		return ident + " IS NULL", nil
+	case op == "is_not_null":
+		return ident + " IS NOT NULL", nil
+
+	case comparisonOps[op] != "":
+		if isNullJSON(f.Value) {
+			return "", wrap(fmt.Errorf("%s needs a value; use is_null to match missing values", op))
+		}
+		p, err := qc.bind(f.Value, f.Type)
+		if err != nil {
+			return "", wrap(err)
+		}
+		return fmt.Sprintf("%s %s %s", ident, comparisonOps[op], p), nil
+
+	case textOps[op] != "":
+		var s string
+		if err := json.Unmarshal(f.Value, &s); err != nil {
+			return "", wrap(fmt.Errorf("%s needs a string value", op))
+		}
+		p, err := qc.bind(f.Value, "VARCHAR")
+		if err != nil {
+			return "", wrap(err)
+		}
+		return fmt.Sprintf("%s(CAST(%s AS VARCHAR), %s)", textOps[op], ident, p), nil
+
+	case op == "in" || op == "not_in":
+		var values []json.RawMessage
+		if err := json.Unmarshal(f.Value, &values); err != nil || len(values) == 0 {
+			return "", wrap(fmt.Errorf("%s needs a non-empty array value", op))
+		}
+		ps := make([]string, len(values))
+		for i, v := range values {
+			if ps[i], err = qc.bind(v, f.Type); err != nil {
+				return "", wrap(err)
+			}
+		}
+		kw := "IN"
+		if op == "not_in" {
+			kw = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", ident, kw, strings.Join(ps, ", ")), nil
+
+	case op == "between":
+		var bounds []json.RawMessage
+		if err := json.Unmarshal(f.Value, &bounds); err != nil || len(bounds) != 2 {
+			return "", wrap(fmt.Errorf("between needs a [low, high] array value"))
+		}
+		lo, err := qc.bind(bounds[0], f.Type)
+		if err != nil {
+			return "", wrap(err)
+		}
+		hi, err := qc.bind(bounds[1], f.Type)
+		if err != nil {
+			return "", wrap(err)
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", ident, lo, hi), nil
+	}
+	return "", fmt.Errorf("unknown filter operator %q", f.Op)
+}
+
+func isNullJSON(v json.RawMessage) bool {
+	s := strings.TrimSpace(string(v))
+	return s == "" || s == "null"
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
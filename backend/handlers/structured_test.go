@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+}
+
+func TestStructuredTypedFilters(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.CreateDerived(ctx, "sales", "table", `SELECT * FROM (VALUES
+		(DATE '2024-02-28', 9.99::DECIMAL(10,2)),
+		(DATE '2024-03-01', 10.50::DECIMAL(10,2)),
+		(DATE '2024-03-15', 120.00::DECIMAL(10,2))) AS v(day, amount)`, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{`{"column": "day", "op": "gte", "value": "2024-03-01", "type": "DATE"}`, 2},
+		{`{"column": "day", "op": "between", "value": ["2024-02-01", "2024-03-01"], "type": "DATE"}`, 2},
+		{`{"column": "day", "op": "in", "value": ["2024-03-15"], "type": "DATE"}`, 1},
+		{`{"column": "amount", "op": "gt", "value": "10.5", "type": "DECIMAL"}`, 1},
+		{`{"column": "amount", "op": "lte", "value": 10.5, "type": "DOUBLE"}`, 2},
+		{`{"column": "day", "op": "contains", "value": "-03-"}`, 2},
+	}
+	for _, tt := range tests {
+		var f queryFilter
+		if err := json.Unmarshal([]byte(tt.filter), &f); err != nil {
+			t.Fatal(err)
+		}
+		sqlText, params, err := compileStructured(ctx, structuredQuery{Table: "sales", Filters: []queryFilter{f}})
+		if err != nil {
+			t.Errorf("%s: %v", tt.filter, err)
+			continue
+		}
+		sqlText, args, err := params.bind(sqlText)
+		if err != nil {
+			t.Errorf("%s: %v", tt.filter, err)
+			continue
+		}
+		result, err := queryRows(ctx, db.DB, sqlText, args)
+		if err != nil {
+			t.Errorf("%s: %s: %v", tt.filter, sqlText, err)
+			continue
+		}
+		if len(result.Rows) != tt.want {
+			t.Errorf("%s: got %d rows, want %d", tt.filter, len(result.Rows), tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"artemisgo/db"
+	"artemisgo/sqltext"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type createTableRequest struct {
+	Name      string `json:"name"`
+	SQL       string `json:"sql"`
+	Kind      string `json:"kind"` // table (default) or view
+	Replace   bool   `json:"replace"`
+	QueryID   string `json:"queryId"`
+	TimeoutMs int    `json:"timeoutMs"`
+}
+
+// ListTables returns the catalog with each object's columns.
+func ListTables(c *fiber.Ctx) error {
+	tables := []fiber.Map{}
+	for _, e := range db.Tables() {
+		entry := fiber.Map{
+			"name":    e.Name,
+			"kind":    e.Kind,
+			"parents": e.Parents,
+			"created": e.Created,
+		}
+		if e.SQL != "" {
+			entry["sql"] = e.SQL
+			entry["datasetVersion"] = e.DatasetVersion
+		}
+		if cols, err := db.DescribeTable(context.Background(), e.Name); err == nil {
+			entry["columns"] = cols
+		} else {
+			entry["error"] = err.Error()
+		}
+		tables = append(tables, entry)
+	}
+	return c.JSON(fiber.Map{"tables": tables})
+}
+
+// CreateTable saves a SELECT as a new table (materializing its result) or
+// as a view. The SELECT must pass the editor's read-only checks; creating a
+// new object is allowed under any policy since it can't touch existing data.
+// Replacing an existing one discards its data, so it needs the editor's
+// policy to allow writes.
+func CreateTable(c *fiber.Ctx) error {
+	var req createTableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Kind == "" {
+		req.Kind = "table"
+	}
+	if req.Name == "" || strings.TrimSpace(req.SQL) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name and sql are required"})
+	}
+	if n := len(sqltext.Split(req.SQL)); n != 1 {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("expected a single SELECT, got %d statements", n)})
+	}
+
+	if _, exists := db.LookupTable(req.Name); exists && req.Replace && !db.PolicyFor("editor").AllowWrites {
+		return c.Status(403).JSON(fiber.Map{"error": (&db.PolicyError{Reason: "replacing a table is not allowed in read-only mode"}).Error()})
+	}
+
+	id := req.QueryID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	entry, err := createDerived(execSpec{
+		ID:      id,
+		SQL:     req.SQL,
+		Source:  "editor",
+		Timeout: requestTimeout(req.TimeoutMs),
+	}, req.Name, req.Kind, req.Replace)
+	if err != nil {
+		status := errorStatus(err)
+		if status == 200 {
+			status = 400
+		}
+		if errors.Is(err, db.ErrTableExists) {
+			status = 409
+		}
+		return c.Status(status).JSON(fiber.Map{"queryId": id, "error": err.Error()})
+	}
+	return c.JSON(entry)
+}
+
+func createDerived(spec execSpec, name, kind string, replace bool) (entry db.CatalogEntry, err error) {
+	start := time.Now()
+	version := db.DatasetVersion()
+	defer func() { recordHistory(spec, start, version, 0, false, err) }()
+
+	ex, err := startQuery(spec)
+	if err != nil {
+		return db.CatalogEntry{}, err
+	}
+	defer ex.done()
+	if ex.writes {
+		return db.CatalogEntry{}, fmt.Errorf("only a SELECT can be saved as a %s", kind)
+	}
+	if err := ex.admit(); err != nil {
+		return db.CatalogEntry{}, err
+	}
+
+	entry, err = db.CreateDerived(ex.ctx, name, kind, ex.sql, replace)
+	if err != nil {
+		return db.CatalogEntry{}, queryError(ex.ctx, err)
+	}
+	return entry, nil
+}
+
+// DropTable drops a derived table or view. The uploaded table stays until
+// the next upload replaces it.
+func DropTable(c *fiber.Ctx) error {
+	err := db.DropDerived(context.Background(), c.Params("name"))
+	switch {
+	case errors.Is(err, db.ErrTableNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Table not found"})
+	case err != nil:
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"artemisgo/db"
 	"artemisgo/handlers"
+	"artemisgo/pgwire"
+	"artemisgo/store"
 	"bufio"
 	"log"
 	"os"
@@ -36,6 +38,12 @@ func main() {
 	if err := db.Init(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	if err := store.Init(); err != nil {
+		log.Fatalf("Failed to initialize data store: %v", err)
+	}
+	if err := handlers.Init(); err != nil {
+		log.Fatalf("Failed to initialize handlers: %v", err)
+	}
 
 	app := fiber.New(fiber.Config{
 		BodyLimit:    4 * 1024 * 1024 * 1024, // 4 GB
@@ -48,9 +56,10 @@ func main() {
 		allowedOrigins = "http://localhost:3000"
 	}
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: allowedOrigins,
-		AllowMethods: "GET,POST",
-		AllowHeaders: "Content-Type",
+		AllowOrigins:  allowedOrigins,
+		AllowMethods:  "GET,POST,PUT,DELETE",
+		AllowHeaders:  "Content-Type",
+		ExposeHeaders: "X-Query-Id",
 	}))
 
 	app.Get("/api/health", func(c *fiber.Ctx) error {
@@ -59,8 +68,66 @@ func main() {
 
 	app.Post("/api/upload", handlers.Upload)
 	app.Post("/api/query", handlers.Query)
+	app.Post("/api/script", handlers.Script)
+	app.Post("/api/structured-query", handlers.StructuredQuery)
+	app.Post("/api/plan", handlers.Plan)
+	app.Post("/api/complete", handlers.Complete)
+	app.Post("/api/format", handlers.FormatSQL)
+	app.Post("/api/lint", handlers.Lint)
+	app.Get("/api/cache", handlers.CacheStats)
+	app.Post("/api/cache/purge", handlers.PurgeCache)
 	app.Get("/api/stats", handlers.Stats)
+	app.Get("/api/tables", handlers.ListTables)
+	app.Post("/api/tables", handlers.CreateTable)
+	app.Delete("/api/tables/:name", handlers.DropTable)
 	app.Post("/api/chat", handlers.Chat)
+	app.Get("/api/queries", handlers.ListQueries)
+	app.Post("/api/queries/:id/cancel", handlers.CancelQuery)
+	app.Get("/api/history", handlers.History)
+	app.Get("/api/history/:id", handlers.HistoryEntry)
+	app.Post("/api/history/:id/rerun", handlers.RerunHistory)
+	app.Get("/api/saved-queries", handlers.ListSavedQueries)
+	app.Post("/api/saved-queries", handlers.CreateSavedQuery)
+	app.Get("/api/saved-queries/:id", handlers.GetSavedQuery)
+	app.Put("/api/saved-queries/:id", handlers.UpdateSavedQuery)
+	app.Delete("/api/saved-queries/:id", handlers.DeleteSavedQuery)
+	app.Post("/api/saved-queries/:id/run", handlers.RunSavedQuery)
+	app.Get("/api/metrics", handlers.ListMetricModels)
+	app.Post("/api/metrics", handlers.CreateMetricModel)
+	app.Post("/api/metrics/query", handlers.QueryMetrics)
+	app.Get("/api/metrics/:id", handlers.GetMetricModel)
+	app.Put("/api/metrics/:id", handlers.UpdateMetricModel)
+	app.Delete("/api/metrics/:id", handlers.DeleteMetricModel)
+	app.Get("/api/macros", handlers.ListMacros)
+	app.Post("/api/macros", handlers.CreateMacro)
+	app.Get("/api/macros/:id", handlers.GetMacro)
+	app.Put("/api/macros/:id", handlers.UpdateMacro)
+	app.Delete("/api/macros/:id", handlers.DeleteMacro)
+	app.Get("/api/schedules", handlers.ListSchedules)
+	app.Post("/api/schedules", handlers.CreateSchedule)
+	app.Get("/api/schedules/:id", handlers.GetSchedule)
+	app.Put("/api/schedules/:id", handlers.UpdateSchedule)
+	app.Delete("/api/schedules/:id", handlers.DeleteSchedule)
+	app.Get("/api/schedules/:id/runs", handlers.ScheduleRuns)
+	app.Post("/api/schedules/:id/run", handlers.RunScheduleNow)
+
+	// PG_LISTEN (e.g. ":5433") also serves queries to PostgreSQL clients
+	// PG_PASSWORD, if set, is required at login; the connection isn't
+	// encrypted, so it crosses the network in plain text
+	if addr := os.Getenv("PG_LISTEN"); addr != "" {
+		pg := &pgwire.Server{Backend: handlers.WireBackend{}, Password: os.Getenv("PG_PASSWORD")}
+		go func() {
+			log.Fatalf("PostgreSQL listener failed: %v", pg.ListenAndServe(addr))
+		}()
+	}
+
+	// FLIGHT_LISTEN (e.g. ":8815") serves Arrow Flight SQL over gRPC
+	// without TLS; FLIGHT_PASSWORD, if set, is required via basic auth
+	if addr := os.Getenv("FLIGHT_LISTEN"); addr != "" {
+		go func() {
+			log.Fatalf("Flight SQL listener failed: %v", handlers.ServeFlight(addr, os.Getenv("FLIGHT_PASSWORD")))
+		}()
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
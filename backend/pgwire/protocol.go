@@ -0,0 +1,165 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Protocol codes sent in place of a version in the first message.
+const (
+	protocolVersion3 = 196608
+	sslRequestCode   = 80877103
+	gssRequestCode   = 80877104
+	cancelCode       = 80877102
+)
+
+// maxMessageSize bounds what a client may send in one message.
+const maxMessageSize = 64 << 20
+
+var errMalformed = errors.New("malformed message")
+
+// message builds one backend message.
+type message struct {
+	typ  byte
+	data []byte
+}
+
+func newMessage(typ byte) *message {
+	return &message{typ: typ}
+}
+
+func (m *message) byte1(b byte) *message {
+	m.data = append(m.data, b)
+	return m
+}
+
+func (m *message) int16(v int) *message {
+	m.data = binary.BigEndian.AppendUint16(m.data, uint16(v))
+	return m
+}
+
+func (m *message) int32(v int) *message {
+	m.data = binary.BigEndian.AppendUint32(m.data, uint32(v))
+	return m
+}
+
+func (m *message) string(s string) *message {
+	m.data = append(m.data, s...)
+	m.data = append(m.data, 0)
+	return m
+}
+
+// value appends a length-prefixed field, -1 for NULL.
+func (m *message) value(b []byte, null bool) *message {
+	if null {
+		return m.int32(-1)
+	}
+	m.int32(len(b))
+	m.data = append(m.data, b...)
+	return m
+}
+
+func (m *message) writeTo(w *bufio.Writer) error {
+	if err := w.WriteByte(m.typ); err != nil {
+		return err
+	}
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(m.data)+4))
+	if _, err := w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(m.data)
+	return err
+}
+
+// readStartup reads the untyped first message of a connection.
+func readStartup(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(n[:]))
+	if size < 8 || size > 10000 {
+		return nil, fmt.Errorf("invalid startup message length %d", size)
+	}
+	body := make([]byte, size-4)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// readMessage reads one typed frontend message.
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(n[:]))
+	if size < 4 || size > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+// reader decodes the fields of a frontend message. The first decoding error
+// sticks, so callers check err once at the end.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte1() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) int16() int {
+	if b := r.take(2); b != nil {
+		return int(int16(binary.BigEndian.Uint16(b)))
+	}
+	return 0
+}
+
+func (r *reader) int32() int {
+	if b := r.take(4); b != nil {
+		return int(int32(binary.BigEndian.Uint32(b)))
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, b := range r.data {
+		if b == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s
+		}
+	}
+	r.err = errMalformed
+	return ""
+}
@@ -0,0 +1,721 @@
+// Package pgwire serves the PostgreSQL frontend/backend protocol (version 3)
+// so psql, JDBC and other PostgreSQL clients can query the database. It
+// handles the simple and extended query flows, parameters in text or binary
+// format, cancel requests and optional password authentication; statements
+// themselves are run by a Backend.
+//
+// Connections are never encrypted: SSL requests are declined, so queries,
+// results and the password all cross the network in plain text. Listen on a
+// trusted network or tunnel the port.
+//
+// Session commands clients send on connect (SET, SHOW, BEGIN, DISCARD, ...)
+// are answered here without reaching the database. The endpoint is read-only,
+// so transaction commands are acknowledged but have no effect.
+package pgwire
+
+import (
+	"artemisgo/sqltext"
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// serverVersion is reported to clients, which use it to pick their dialect.
+const serverVersion = "14.0 (ArtemisGO)"
+
+// Arg is a bound parameter value, always in text form. Type is the DuckDB
+// type the client declared for it, or "" if it left the type unspecified or
+// declared text, in which case the backend should infer it.
+type Arg struct {
+	Text string
+	Null bool
+	Type string
+}
+
+// Result is the outcome of a statement. Row values are those the backend
+// encodes for JSON: nil, bool, numbers, strings, slices and maps.
+type Result struct {
+	Columns []string
+	Types   []string // DuckDB type names
+	Rows    [][]interface{}
+}
+
+// Backend runs statements for the server.
+type Backend interface {
+	// Query runs one statement under the given ID. conn is the client's
+	// connection, so the backend can stop the query if the client leaves.
+	Query(conn net.Conn, id, sql string, args []Arg) (*Result, error)
+	// Describe returns the columns a statement would produce, without rows,
+	// or nil if it produces none or they can't be known before running it.
+	Describe(sql string, nargs int) (*Result, error)
+	// Cancel interrupts the query with the given ID.
+	Cancel(id string)
+	// SQLState classifies an error from Query for the client.
+	SQLState(err error) string
+}
+
+// Server accepts PostgreSQL connections.
+type Server struct {
+	Backend Backend
+	// Password, if set, must be sent by clients in a cleartext password
+	// message before they can run anything. Without TLS it is readable by
+	// anyone who can see the traffic.
+	Password string
+
+	mu       sync.Mutex
+	sessions map[uint32]*session // by process ID
+	nextPID  uint32
+}
+
+// ListenAndServe accepts connections on addr until the listener fails.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("PostgreSQL protocol listener on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// pgError is an error reported to the client with a SQLSTATE code.
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e *pgError) Error() string { return e.msg }
+
+func errorf(code, format string, args ...interface{}) error {
+	return &pgError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+type prepared struct {
+	sql       string
+	paramOIDs []int
+}
+
+type portal struct {
+	stmt    *prepared
+	args    []Arg
+	formats []int // result format codes as sent in Bind
+	result  *Result
+	tag     string
+	sent    int // rows already returned by Execute
+}
+
+type session struct {
+	server *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	pid    uint32
+	secret uint32
+	params map[string]string
+
+	statements map[string]*prepared
+	portals    map[string]*portal
+	inTx       bool
+	queries    int
+
+	mu      sync.Mutex
+	queryID string // running query, for cancel requests
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	sess := &session{
+		server:     s,
+		conn:       conn,
+		r:          bufio.NewReader(conn),
+		w:          bufio.NewWriter(conn),
+		params:     map[string]string{},
+		statements: map[string]*prepared{},
+		portals:    map[string]*portal{},
+	}
+	ok, err := sess.startup()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Printf("pgwire: %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = map[uint32]*session{}
+	}
+	s.nextPID++
+	sess.pid = s.nextPID
+	s.sessions[sess.pid] = sess
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess.pid)
+		s.mu.Unlock()
+	}()
+
+	if err := sess.run(); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("pgwire: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// startup negotiates the protocol, authenticates the client and sends the
+// session parameters. It reports false for connections that end during
+// startup by design, such as cancel requests.
+func (sess *session) startup() (bool, error) {
+	for {
+		body, err := readStartup(sess.r)
+		if err != nil {
+			return false, err
+		}
+		r := &reader{data: body}
+		switch code := r.int32(); code {
+		case sslRequestCode, gssRequestCode:
+			// No encryption; the client may continue in plain text
+			if _, err := sess.conn.Write([]byte{'N'}); err != nil {
+				return false, err
+			}
+			continue
+		case cancelCode:
+			sess.server.cancel(uint32(r.int32()), uint32(r.int32()))
+			return false, nil
+		case protocolVersion3:
+			for {
+				key := r.string()
+				if key == "" || r.err != nil {
+					break
+				}
+				sess.params[key] = r.string()
+			}
+		default:
+			sess.sendError(errorf("08P01", "unsupported frontend protocol %d.%d", code>>16, code&0xffff))
+			return false, sess.w.Flush()
+		}
+		break
+	}
+
+	if sess.server.Password != "" {
+		if err := newMessage('R').int32(3).writeTo(sess.w); err != nil {
+			return false, err
+		}
+		if err := sess.w.Flush(); err != nil {
+			return false, err
+		}
+		typ, body, err := readMessage(sess.r)
+		if err != nil {
+			return false, err
+		}
+		password := (&reader{data: body}).string()
+		if typ != 'p' || subtle.ConstantTimeCompare([]byte(password), []byte(sess.server.Password)) != 1 {
+			sess.sendError(errorf("28P01", "password authentication failed for user %q", sess.params["user"]))
+			return false, sess.w.Flush()
+		}
+	}
+	newMessage('R').int32(0).writeTo(sess.w)
+
+	var key [4]byte
+	rand.Read(key[:])
+	sess.secret = binary.BigEndian.Uint32(key[:])
+
+	status := map[string]string{
+		"server_version":              serverVersion,
+		"server_encoding":             "UTF8",
+		"client_encoding":             "UTF8",
+		"DateStyle":                   "ISO, MDY",
+		"IntervalStyle":               "iso_8601",
+		"TimeZone":                    "UTC",
+		"integer_datetimes":           "on",
+		"standard_conforming_strings": "on",
+		"is_superuser":                "off",
+		"application_name":            sess.params["application_name"],
+		"session_authorization":       sess.params["user"],
+	}
+	for k, v := range status {
+		newMessage('S').string(k).string(v).writeTo(sess.w)
+		if _, set := sess.params[k]; !set {
+			sess.params[k] = v
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) cancel(pid, secret uint32) {
+	s.mu.Lock()
+	sess := s.sessions[pid]
+	s.mu.Unlock()
+	if sess == nil || sess.secret != secret {
+		return
+	}
+	sess.mu.Lock()
+	id := sess.queryID
+	sess.mu.Unlock()
+	if id != "" {
+		s.Backend.Cancel(id)
+	}
+}
+
+// run processes messages until the client terminates. In the extended flow
+// an error discards everything up to the next Sync.
+func (sess *session) run() error {
+	newMessage('K').int32(int(sess.pid)).int32(int(sess.secret)).writeTo(sess.w)
+	if err := sess.ready(); err != nil {
+		return err
+	}
+
+	skipping := false
+	for {
+		typ, body, err := readMessage(sess.r)
+		if err != nil {
+			return err
+		}
+		if skipping && typ != 'S' && typ != 'X' {
+			continue
+		}
+
+		r := &reader{data: body}
+		switch typ {
+		case 'Q':
+			sess.simpleQuery(r.string())
+			err = sess.ready()
+		case 'P':
+			err = sess.parse(r)
+		case 'B':
+			err = sess.bind(r)
+		case 'D':
+			err = sess.describe(r)
+		case 'E':
+			err = sess.execute(r)
+		case 'C':
+			kind, name := r.byte1(), r.string()
+			if kind == 'S' {
+				delete(sess.statements, name)
+			} else {
+				delete(sess.portals, name)
+			}
+			newMessage('3').writeTo(sess.w)
+		case 'S':
+			skipping = false
+			delete(sess.portals, "")
+			err = sess.ready()
+		case 'H':
+			err = sess.w.Flush()
+		case 'X':
+			return nil
+		default:
+			err = errorf("08P01", "unexpected message type %q", typ)
+		}
+		if r.err != nil && err == nil {
+			err = errorf("08P01", "malformed %q message", typ)
+		}
+
+		var pgErr *pgError
+		switch {
+		case errors.As(err, &pgErr):
+			sess.sendError(err)
+			skipping = typ != 'Q' && typ != 'S'
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func (sess *session) ready() error {
+	status := byte('I')
+	if sess.inTx {
+		status = 'T'
+	}
+	if err := newMessage('Z').byte1(status).writeTo(sess.w); err != nil {
+		return err
+	}
+	return sess.w.Flush()
+}
+
+func (sess *session) sendError(err error) {
+	code, msg := "XX000", err.Error()
+	var pgErr *pgError
+	if errors.As(err, &pgErr) {
+		code = pgErr.code
+	}
+	newMessage('E').
+		byte1('S').string("ERROR").
+		byte1('V').string("ERROR").
+		byte1('C').string(code).
+		byte1('M').string(msg).
+		byte1(0).
+		writeTo(sess.w)
+}
+
+// simpleQuery runs each statement of a Query message in turn, stopping at
+// the first error.
+func (sess *session) simpleQuery(text string) {
+	stmts := sqltext.Split(text)
+	if len(stmts) == 0 {
+		newMessage('I').writeTo(sess.w)
+		return
+	}
+	for _, stmt := range stmts {
+		result, tag, err := sess.runStatement(stmt.SQL, nil)
+		if err != nil {
+			sess.sendError(err)
+			return
+		}
+		if result != nil {
+			sess.sendRowDescription(result, nil)
+			if err := sess.sendRows(result.Rows, result.Types, nil); err != nil {
+				sess.sendError(err)
+				return
+			}
+		}
+		newMessage('C').string(tag).writeTo(sess.w)
+	}
+}
+
+// runStatement answers session commands itself and passes everything else
+// to the backend. It returns the rows, if any, and the command tag.
+func (sess *session) runStatement(sqlText string, args []Arg) (*Result, string, error) {
+	if cmd, ok := sess.sessionCommand(sqlText); ok {
+		if cmd.apply != nil {
+			cmd.apply()
+		}
+		return cmd.result, cmd.tag, nil
+	}
+
+	sess.queries++
+	id := fmt.Sprintf("pg-%d-%d-%x", sess.pid, sess.queries, sess.secret)
+	sess.mu.Lock()
+	sess.queryID = id
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		sess.queryID = ""
+		sess.mu.Unlock()
+	}()
+
+	result, err := sess.server.Backend.Query(sess.conn, id, sqlText, args)
+	if err != nil {
+		return nil, "", &pgError{code: sess.server.Backend.SQLState(err), msg: err.Error()}
+	}
+	return result, fmt.Sprintf("SELECT %d", len(result.Rows)), nil
+}
+
+// sessionCmd is a statement answered by the server itself. Its effect on
+// the session is kept in apply, so describing a prepared statement doesn't
+// run it.
+type sessionCmd struct {
+	result *Result
+	tag    string
+	apply  func() // nil if the command changes nothing
+}
+
+// sessionCommand recognizes the statements clients issue to set up a session.
+func (sess *session) sessionCommand(sqlText string) (sessionCmd, bool) {
+	code := sqltext.Code(sqlText)
+	if len(code) == 0 {
+		return sessionCmd{}, false
+	}
+	words := make([]string, 0, len(code))
+	for _, t := range code {
+		words = append(words, t.Name())
+	}
+
+	switch strings.ToUpper(words[0]) {
+	case "SET":
+		// SET [SESSION | LOCAL] name { TO | = } value
+		cmd := sessionCmd{tag: "SET"}
+		rest := words[1:]
+		if len(rest) > 0 && (strings.EqualFold(rest[0], "SESSION") || strings.EqualFold(rest[0], "LOCAL")) {
+			rest = rest[1:]
+		}
+		if len(rest) >= 3 {
+			name, value := strings.ToLower(rest[0]), strings.Trim(strings.Join(rest[2:], " "), "'")
+			cmd.apply = func() { sess.params[name] = value }
+		}
+		return cmd, true
+	case "RESET":
+		return sessionCmd{tag: "RESET"}, true
+	case "BEGIN", "START":
+		return sessionCmd{tag: "BEGIN", apply: func() { sess.inTx = true }}, true
+	case "COMMIT", "END":
+		return sessionCmd{tag: "COMMIT", apply: func() { sess.inTx = false }}, true
+	case "ROLLBACK", "ABORT":
+		return sessionCmd{tag: "ROLLBACK", apply: func() { sess.inTx = false }}, true
+	case "DISCARD":
+		return sessionCmd{tag: "DISCARD ALL"}, true
+	case "DEALLOCATE":
+		cmd := sessionCmd{tag: "DEALLOCATE"}
+		if name := words[len(words)-1]; len(words) > 1 {
+			cmd.apply = func() { delete(sess.statements, name) }
+		}
+		return cmd, true
+	case "SHOW":
+		name := strings.ToLower(strings.Join(words[1:], " "))
+		value, ok := map[string]string{
+			"transaction isolation level": "read committed",
+			"transaction_isolation":       "read committed",
+			"server_version_num":          "140000",
+			"max_identifier_length":       "63",
+		}[name]
+		if !ok {
+			for k, v := range sess.params {
+				if strings.EqualFold(k, name) {
+					value, ok = v, true
+				}
+			}
+		}
+		if !ok {
+			return sessionCmd{}, false
+		}
+		result := &Result{Columns: []string{name}, Types: []string{"VARCHAR"}, Rows: [][]interface{}{{value}}}
+		return sessionCmd{result: result, tag: "SHOW"}, true
+	}
+	return sessionCmd{}, false
+}
+
+// sendRowDescription describes the result columns. formats holds the
+// result format codes from Bind: none means text, one applies to all.
+func (sess *session) sendRowDescription(result *Result, formats []int) {
+	m := newMessage('T').int16(len(result.Columns))
+	for i, name := range result.Columns {
+		oid, size := pgType(result.Types[i])
+		m.string(name).int32(0).int16(0).int32(oid).int16(size).int32(-1).int16(formatCode(formats, i))
+	}
+	m.writeTo(sess.w)
+}
+
+func (sess *session) sendRows(rows [][]interface{}, types []string, formats []int) error {
+	oids := make([]int, len(types))
+	for i, t := range types {
+		oids[i], _ = pgType(t)
+	}
+	for _, row := range rows {
+		m := newMessage('D').int16(len(row))
+		for i, v := range row {
+			if formatCode(formats, i) == 1 {
+				b, null, err := binaryValue(v, oids[i])
+				if err != nil {
+					return errorf("22P03", "can't encode column %d in binary: %v", i+1, err)
+				}
+				m.value(b, null)
+			} else {
+				m.value(textValue(v, oids[i]))
+			}
+		}
+		if err := m.writeTo(sess.w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatCode(formats []int, i int) int {
+	switch {
+	case len(formats) == 0:
+		return 0
+	case len(formats) == 1:
+		return formats[0]
+	case i < len(formats):
+		return formats[i]
+	}
+	return 0
+}
+
+func (sess *session) parse(r *reader) error {
+	name, query := r.string(), r.string()
+	oids := make([]int, r.int16())
+	for i := range oids {
+		oids[i] = r.int32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(sqltext.Split(query)) > 1 {
+		return errorf("42601", "cannot insert multiple commands into a prepared statement")
+	}
+	sess.statements[name] = &prepared{sql: query, paramOIDs: oids}
+	return newMessage('1').writeTo(sess.w)
+}
+
+func (sess *session) bind(r *reader) error {
+	portalName, stmtName := r.string(), r.string()
+	paramFormats := make([]int, r.int16())
+	for i := range paramFormats {
+		paramFormats[i] = r.int16()
+	}
+	args := make([]Arg, r.int16())
+	raw := make([][]byte, len(args))
+	for i := range args {
+		n := r.int32()
+		if n < 0 {
+			args[i].Null = true
+			continue
+		}
+		raw[i] = r.take(n)
+	}
+	formats := make([]int, r.int16())
+	for i := range formats {
+		formats[i] = r.int16()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	stmt, ok := sess.statements[stmtName]
+	if !ok {
+		return errorf("26000", "prepared statement %q does not exist", stmtName)
+	}
+	for i := range args {
+		oid := 0
+		if i < len(stmt.paramOIDs) {
+			oid = stmt.paramOIDs[i]
+		}
+		args[i].Type = duckType(oid)
+		if args[i].Null {
+			continue
+		}
+		if formatCode(paramFormats, i) == 0 {
+			args[i].Text = string(raw[i])
+			continue
+		}
+		text, err := paramText(raw[i], oid)
+		if err != nil {
+			return errorf("22P03", "parameter $%d: %v", i+1, err)
+		}
+		args[i].Text = text
+	}
+	sess.portals[portalName] = &portal{stmt: stmt, args: args, formats: formats}
+	return newMessage('2').writeTo(sess.w)
+}
+
+func (sess *session) describe(r *reader) error {
+	kind, name := r.byte1(), r.string()
+	if r.err != nil {
+		return r.err
+	}
+
+	if kind == 'S' {
+		stmt, ok := sess.statements[name]
+		if !ok {
+			return errorf("26000", "prepared statement %q does not exist", name)
+		}
+		nargs := len(stmt.paramOIDs)
+		if n := countParams(stmt.sql); n > nargs {
+			nargs = n
+		}
+		m := newMessage('t').int16(nargs)
+		for i := 0; i < nargs; i++ {
+			oid := oidText
+			if i < len(stmt.paramOIDs) && stmt.paramOIDs[i] != 0 {
+				oid = stmt.paramOIDs[i]
+			}
+			m.int32(oid)
+		}
+		m.writeTo(sess.w)
+
+		cmd, isCommand := sess.sessionCommand(stmt.sql)
+		result := cmd.result
+		if !isCommand {
+			var err error
+			if result, err = sess.server.Backend.Describe(stmt.sql, nargs); err != nil {
+				return &pgError{code: sess.server.Backend.SQLState(err), msg: err.Error()}
+			}
+		}
+		if result == nil {
+			return newMessage('n').writeTo(sess.w)
+		}
+		sess.sendRowDescription(result, nil)
+		return nil
+	}
+
+	// Describing a portal runs it, so the description matches the rows
+	// Execute will send
+	p, ok := sess.portals[name]
+	if !ok {
+		return errorf("34000", "portal %q does not exist", name)
+	}
+	if err := sess.runPortal(p); err != nil {
+		return err
+	}
+	if p.result == nil {
+		return newMessage('n').writeTo(sess.w)
+	}
+	sess.sendRowDescription(p.result, p.formats)
+	return nil
+}
+
+func (sess *session) runPortal(p *portal) error {
+	if p.tag != "" {
+		return nil
+	}
+	result, tag, err := sess.runStatement(p.stmt.sql, p.args)
+	if err != nil {
+		return err
+	}
+	p.result, p.tag = result, tag
+	return nil
+}
+
+func (sess *session) execute(r *reader) error {
+	name, maxRows := r.string(), r.int32()
+	if r.err != nil {
+		return r.err
+	}
+	p, ok := sess.portals[name]
+	if !ok {
+		return errorf("34000", "portal %q does not exist", name)
+	}
+	if strings.TrimSpace(p.stmt.sql) == "" {
+		return newMessage('I').writeTo(sess.w)
+	}
+	if err := sess.runPortal(p); err != nil {
+		return err
+	}
+	if p.result == nil {
+		return newMessage('C').string(p.tag).writeTo(sess.w)
+	}
+
+	rows := p.result.Rows[p.sent:]
+	suspended := maxRows > 0 && len(rows) > maxRows
+	if suspended {
+		rows = rows[:maxRows]
+	}
+	if err := sess.sendRows(rows, p.result.Types, p.formats); err != nil {
+		return err
+	}
+	p.sent += len(rows)
+	if suspended {
+		return newMessage('s').writeTo(sess.w)
+	}
+	return newMessage('C').string(p.tag).writeTo(sess.w)
+}
+
+// countParams returns the highest $N placeholder in a statement.
+func countParams(sqlText string) int {
+	n := 0
+	for _, tok := range sqltext.Code(sqlText) {
+		if tok.Kind != sqltext.Param || len(tok.Text) < 2 {
+			continue
+		}
+		var i int
+		if _, err := fmt.Sscanf(tok.Text[1:], "%d", &i); err == nil && i > n {
+			n = i
+		}
+	}
+	return n
+}
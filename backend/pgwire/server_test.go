@@ -0,0 +1,41 @@
+package pgwire
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func TestDescribeSessionCommandHasNoEffect(t *testing.T) {
+	sess := &session{
+		w:      bufio.NewWriter(io.Discard),
+		params: map[string]string{"search_path": "main"},
+		statements: map[string]*prepared{
+			"begin":   {sql: "BEGIN"},
+			"set":     {sql: "SET search_path TO 'other'"},
+			"dealloc": {sql: "DEALLOCATE begin"},
+			"show":    {sql: "SHOW search_path"},
+		},
+	}
+	for _, name := range []string{"begin", "set", "dealloc", "show"} {
+		if err := sess.describe(&reader{data: append([]byte{'S'}, name+"\x00"...)}); err != nil {
+			t.Fatalf("describe %s: %v", name, err)
+		}
+	}
+	if sess.inTx || sess.params["search_path"] != "main" || sess.statements["begin"] == nil {
+		t.Fatalf("describe changed the session: inTx=%v params=%v statements=%d", sess.inTx, sess.params, len(sess.statements))
+	}
+
+	for _, sql := range []string{"BEGIN", "SET search_path TO 'other'", "DEALLOCATE begin"} {
+		if _, _, err := sess.runStatement(sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	if !sess.inTx || sess.params["search_path"] != "other" || sess.statements["begin"] != nil {
+		t.Fatalf("running didn't change the session: inTx=%v params=%v statements=%d", sess.inTx, sess.params, len(sess.statements))
+	}
+	result, tag, err := sess.runStatement("SHOW search_path", nil)
+	if err != nil || tag != "SHOW" || result.Rows[0][0] != "other" {
+		t.Fatalf("SHOW: %v %q %v", result, tag, err)
+	}
+}
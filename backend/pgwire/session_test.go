@@ -0,0 +1,250 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// echoBackend answers "SELECT typed" with a fixed row of typed values and any
+// other statement with the arguments it was given, one VARCHAR column each.
+type echoBackend struct {
+	args [][]Arg
+}
+
+func (b *echoBackend) Query(conn net.Conn, id, sql string, args []Arg) (*Result, error) {
+	b.args = append(b.args, args)
+	switch {
+	case strings.Contains(sql, "fail"):
+		return nil, errors.New("Binder Error: no such column fail")
+	case sql == "SELECT typed":
+		return &Result{
+			Columns: []string{"d", "ts", "amount", "tags", "missing"},
+			Types:   []string{"DATE", "TIMESTAMP", "DECIMAL(10,2)", "INTEGER[]", "VARCHAR"},
+			Rows:    [][]interface{}{{"2024-03-01", "2024-03-01T10:30:00.5", "10.50", []interface{}{int64(1), nil}, nil}},
+		}, nil
+	}
+	result := &Result{Rows: [][]interface{}{{}}}
+	for i, arg := range args {
+		result.Columns = append(result.Columns, "$"+string(rune('1'+i)))
+		result.Types = append(result.Types, "VARCHAR")
+		if arg.Null {
+			result.Rows[0] = append(result.Rows[0], nil)
+		} else {
+			result.Rows[0] = append(result.Rows[0], arg.Text)
+		}
+	}
+	return result, nil
+}
+
+func (b *echoBackend) Describe(sql string, nargs int) (*Result, error) { return nil, nil }
+func (b *echoBackend) Cancel(id string)                                {}
+func (b *echoBackend) SQLState(err error) string                       { return "42703" }
+
+type backendMessage struct {
+	typ  byte
+	body []byte
+}
+
+// testClient drives a session over an in-memory connection.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func startSession(t *testing.T, backend Backend) *testClient {
+	t.Helper()
+	client, server := net.Pipe()
+	sess := &session{
+		server:     &Server{Backend: backend},
+		conn:       server,
+		r:          bufio.NewReader(server),
+		w:          bufio.NewWriter(server),
+		params:     map[string]string{},
+		statements: map[string]*prepared{},
+		portals:    map[string]*portal{},
+	}
+	done := make(chan error, 1)
+	go func() { done <- sess.run() }()
+	c := &testClient{t: t, conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+	t.Cleanup(func() {
+		c.send(newMessage('X'))
+		if err := <-done; err != nil {
+			t.Errorf("session: %v", err)
+		}
+		client.Close()
+	})
+	if got := c.untilReady(); len(got) != 2 || got[0].typ != 'K' {
+		t.Fatalf("session start: %v", got)
+	}
+	return c
+}
+
+func (c *testClient) send(msgs ...*message) {
+	for _, m := range msgs {
+		m.writeTo(c.w)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// untilReady reads backend messages up to and including ReadyForQuery.
+func (c *testClient) untilReady() []backendMessage {
+	var msgs []backendMessage
+	for {
+		typ, body, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		msgs = append(msgs, backendMessage{typ, body})
+		if typ == 'Z' {
+			return msgs
+		}
+	}
+}
+
+func messageTypes(msgs []backendMessage) string {
+	var types []byte
+	for _, m := range msgs {
+		types = append(types, m.typ)
+	}
+	return string(types)
+}
+
+// dataRow decodes the fields of a DataRow; NULL fields are nil.
+func dataRow(m backendMessage) [][]byte {
+	r := &reader{data: m.body}
+	fields := make([][]byte, r.int16())
+	for i := range fields {
+		if n := r.int32(); n >= 0 {
+			fields[i] = append([]byte{}, r.take(n)...)
+		}
+	}
+	return fields
+}
+
+// rowDescription returns the type OID and format code of each column.
+func rowDescription(m backendMessage) (oids, formats []int) {
+	r := &reader{data: m.body}
+	for n := r.int16(); n > 0; n-- {
+		r.string()
+		r.int32()
+		r.int16()
+		oids = append(oids, r.int32())
+		r.int16()
+		r.int32()
+		formats = append(formats, r.int16())
+	}
+	return oids, formats
+}
+
+func TestSimpleQueryRoundTrip(t *testing.T) {
+	c := startSession(t, &echoBackend{})
+
+	c.send(newMessage('Q').string("SET application_name = 'psql'; SELECT typed"))
+	msgs := c.untilReady()
+	if got := messageTypes(msgs); got != "CTDCZ" {
+		t.Fatalf("messages %q, want CTDCZ", got)
+	}
+	if tag := (&reader{data: msgs[0].body}).string(); tag != "SET" {
+		t.Errorf("first tag %q", tag)
+	}
+	oids, formats := rowDescription(msgs[1])
+	if want := []int{oidDate, oidTimestamp, oidNumeric, oidJSON, oidVarchar}; !reflect.DeepEqual(oids, want) {
+		t.Errorf("column types %v, want %v", oids, want)
+	}
+	if !reflect.DeepEqual(formats, []int{0, 0, 0, 0, 0}) {
+		t.Errorf("formats %v", formats)
+	}
+	want := [][]byte{[]byte("2024-03-01"), []byte("2024-03-01 10:30:00.5"), []byte("10.50"), []byte("[1,null]"), nil}
+	if got := dataRow(msgs[2]); !reflect.DeepEqual(got, want) {
+		t.Errorf("row %q, want %q", got, want)
+	}
+	if tag := (&reader{data: msgs[3].body}).string(); tag != "SELECT 1" {
+		t.Errorf("tag %q", tag)
+	}
+
+	// An error ends the query; the session carries on
+	c.send(newMessage('Q').string("SELECT fail; SELECT typed"))
+	if got := messageTypes(c.untilReady()); got != "EZ" {
+		t.Errorf("after an error: %q, want EZ", got)
+	}
+	c.send(newMessage('Q').string(""))
+	if got := messageTypes(c.untilReady()); got != "IZ" {
+		t.Errorf("empty query: %q, want IZ", got)
+	}
+}
+
+func TestExtendedQueryRoundTrip(t *testing.T) {
+	backend := &echoBackend{}
+	c := startSession(t, backend)
+
+	// $1 is a DATE sent in binary, $2 an untyped NULL and $3 a NUMERIC
+	// in text
+	date := binary.BigEndian.AppendUint32(nil, uint32(8826)) // 2024-03-01
+	c.send(
+		newMessage('P').string("s1").string("SELECT $1, $2, $3").int16(3).int32(oidDate).int32(0).int32(oidNumeric),
+		newMessage('B').string("").string("s1").
+			int16(3).int16(1).int16(0).int16(0).
+			int16(3).value(date, false).value(nil, true).value([]byte("10.50"), false).
+			int16(0),
+		newMessage('D').byte1('P').string(""),
+		newMessage('E').string("").int32(0),
+		newMessage('S'),
+	)
+	msgs := c.untilReady()
+	if got := messageTypes(msgs); got != "12TDCZ" {
+		t.Fatalf("messages %q, want 12TDCZ", got)
+	}
+	wantArgs := []Arg{{Text: "2024-03-01", Type: "DATE"}, {Null: true}, {Text: "10.50", Type: "DECIMAL"}}
+	if len(backend.args) != 1 || !reflect.DeepEqual(backend.args[0], wantArgs) {
+		t.Errorf("backend got %+v, want one run with %+v", backend.args, wantArgs)
+	}
+	want := [][]byte{[]byte("2024-03-01"), nil, []byte("10.50")}
+	if got := dataRow(msgs[3]); !reflect.DeepEqual(got, want) {
+		t.Errorf("row %q, want %q", got, want)
+	}
+
+	// Typed values with binary results: a DATE as days since
+	// 2000-01-01, a NULL as length -1
+	c.send(
+		newMessage('P').string("typed").string("SELECT typed").int16(0),
+		newMessage('B').string("p").string("typed").int16(0).int16(0).int16(1).int16(1),
+		newMessage('E').string("p").int32(0),
+		newMessage('S'),
+	)
+	msgs = c.untilReady()
+	if got := messageTypes(msgs); got != "12DCZ" {
+		t.Fatalf("messages %q, want 12DCZ", got)
+	}
+	row := dataRow(msgs[2])
+	if len(row) != 5 || !reflect.DeepEqual(row[0], date) || row[4] != nil {
+		t.Errorf("binary row %v", row)
+	}
+	if us := int64(binary.BigEndian.Uint64(row[1])); us != 8826*86400e6+37800e6+500e3 {
+		t.Errorf("binary timestamp %d", us)
+	}
+
+	// An error skips the rest of the batch up to Sync
+	c.send(
+		newMessage('P').string("").string("SELECT fail").int16(0),
+		newMessage('B').string("").string("").int16(0).int16(0).int16(0),
+		newMessage('E').string("").int32(0),
+		newMessage('B').string("").string("missing").int16(0).int16(0).int16(0),
+		newMessage('S'),
+	)
+	msgs = c.untilReady()
+	if got := messageTypes(msgs); got != "12EZ" {
+		t.Fatalf("messages %q, want 12EZ", got)
+	}
+	if !strings.Contains(string(msgs[2].body), "42703") {
+		t.Errorf("error %q has no SQLSTATE 42703", msgs[2].body)
+	}
+}
@@ -0,0 +1,393 @@
+package pgwire
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostgreSQL type OIDs the server reports.
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidJSON        = 114
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidVarchar     = 1043
+	oidDate        = 1082
+	oidTime        = 1083
+	oidTimestamp   = 1114
+	oidTimestampTZ = 1184
+	oidNumeric     = 1700
+	oidUUID        = 2950
+)
+
+// pgEpoch is where PostgreSQL's binary dates and timestamps count from.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pgType maps a DuckDB type name to the PostgreSQL type reported for it and
+// that type's size (-1 for variable length). Types PostgreSQL clients can't
+// parse in DuckDB's rendering, such as intervals and enums, are reported as
+// text; nested types are reported as json.
+func pgType(duckType string) (oid int, size int) {
+	t := strings.ToUpper(duckType)
+	switch {
+	case strings.HasSuffix(t, "]") || strings.HasPrefix(t, "STRUCT") || strings.HasPrefix(t, "MAP") || strings.HasPrefix(t, "UNION"):
+		return oidJSON, -1
+	case strings.HasPrefix(t, "DECIMAL"):
+		return oidNumeric, -1
+	}
+	switch t {
+	case "BOOLEAN":
+		return oidBool, 1
+	case "TINYINT", "SMALLINT", "UTINYINT":
+		return oidInt2, 2
+	case "INTEGER", "USMALLINT":
+		return oidInt4, 4
+	case "BIGINT", "UINTEGER":
+		return oidInt8, 8
+	case "UBIGINT", "HUGEINT", "UHUGEINT", "VARINT":
+		return oidNumeric, -1
+	case "FLOAT":
+		return oidFloat4, 4
+	case "DOUBLE":
+		return oidFloat8, 8
+	case "DATE":
+		return oidDate, 4
+	case "TIME":
+		return oidTime, 8
+	case "TIMESTAMP", "TIMESTAMP_S", "TIMESTAMP_MS", "TIMESTAMP_NS", "DATETIME":
+		return oidTimestamp, 8
+	case "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE":
+		return oidTimestampTZ, 8
+	case "UUID":
+		return oidUUID, 16
+	case "BLOB":
+		return oidBytea, -1
+	case "VARCHAR":
+		return oidVarchar, -1
+	}
+	return oidText, -1
+}
+
+// duckType is the DuckDB type name for a parameter the client declared with
+// oid. Unspecified and text parameters give "".
+func duckType(oid int) string {
+	switch oid {
+	case oidBool:
+		return "BOOLEAN"
+	case oidInt2, oidInt4, oidInt8:
+		return "BIGINT"
+	case oidFloat4, oidFloat8:
+		return "DOUBLE"
+	case oidNumeric:
+		return "DECIMAL"
+	case oidDate:
+		return "DATE"
+	case oidTime:
+		return "TIME"
+	case oidTimestamp:
+		return "TIMESTAMP"
+	case oidTimestampTZ:
+		return "TIMESTAMPTZ"
+	case oidUUID:
+		return "UUID"
+	}
+	return ""
+}
+
+// textValue renders a result value, as the backend encodes it for JSON, in
+// PostgreSQL's text format.
+func textValue(v interface{}, oid int) ([]byte, bool) {
+	switch x := v.(type) {
+	case nil:
+		return nil, true
+	case bool:
+		if x {
+			return []byte("t"), false
+		}
+		return []byte("f"), false
+	case int64:
+		return strconv.AppendInt(nil, x, 10), false
+	case int:
+		return strconv.AppendInt(nil, int64(x), 10), false
+	case uint64:
+		return strconv.AppendUint(nil, x, 10), false
+	case float64:
+		return strconv.AppendFloat(nil, x, 'g', -1, 64), false
+	case float32:
+		return strconv.AppendFloat(nil, float64(x), 'g', -1, 32), false
+	case string:
+		switch oid {
+		case oidTimestamp, oidTimestampTZ:
+			s := strings.Replace(x, "T", " ", 1)
+			if strings.HasSuffix(s, "Z") {
+				s = strings.TrimSuffix(s, "Z") + "+00"
+			}
+			return []byte(s), false
+		case oidBytea:
+			raw, err := base64.StdEncoding.DecodeString(x)
+			if err != nil {
+				return []byte(x), false
+			}
+			return []byte(`\x` + hex.EncodeToString(raw)), false
+		}
+		return []byte(x), false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []byte(fmt.Sprint(v)), false
+	}
+	return b, false
+}
+
+// binaryValue renders a value in PostgreSQL's binary format for oid. Text-like
+// types are the same in both formats.
+func binaryValue(v interface{}, oid int) ([]byte, bool, error) {
+	text, null := textValue(v, oid)
+	if null {
+		return nil, true, nil
+	}
+	s := string(text)
+	var out []byte
+	switch oid {
+	case oidBool:
+		out = []byte{0}
+		if s == "t" {
+			out[0] = 1
+		}
+	case oidInt2, oidInt4, oidInt8:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		switch oid {
+		case oidInt2:
+			out = binary.BigEndian.AppendUint16(nil, uint16(n))
+		case oidInt4:
+			out = binary.BigEndian.AppendUint32(nil, uint32(n))
+		default:
+			out = binary.BigEndian.AppendUint64(nil, uint64(n))
+		}
+	case oidFloat4, oidFloat8:
+		f, err := parseFloat(s)
+		if err != nil {
+			return nil, false, err
+		}
+		if oid == oidFloat4 {
+			out = binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(f)))
+		} else {
+			out = binary.BigEndian.AppendUint64(nil, math.Float64bits(f))
+		}
+	case oidDate:
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, false, err
+		}
+		out = binary.BigEndian.AppendUint32(nil, uint32(int32((t.Unix()-pgEpoch.Unix())/86400)))
+	case oidTime:
+		t, err := time.Parse("15:04:05.999999", s)
+		if err != nil {
+			return nil, false, err
+		}
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		out = binary.BigEndian.AppendUint64(nil, uint64(t.Sub(midnight).Microseconds()))
+	case oidTimestamp, oidTimestampTZ:
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", strings.TrimSuffix(s, "+00"))
+		if err != nil {
+			return nil, false, err
+		}
+		out = binary.BigEndian.AppendUint64(nil, uint64(t.UnixMicro()-pgEpoch.UnixMicro()))
+	case oidUUID:
+		u, err := uuid.Parse(s)
+		if err != nil {
+			return nil, false, err
+		}
+		out = u[:]
+	case oidBytea:
+		raw, err := hex.DecodeString(strings.TrimPrefix(s, `\x`))
+		if err != nil {
+			return nil, false, err
+		}
+		out = raw
+	case oidNumeric:
+		return binaryNumeric(s), false, nil
+	default:
+		out = text
+	}
+	return out, false, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	switch s {
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// binaryNumeric encodes a decimal string as a PostgreSQL numeric: base-10000
+// digits with a weight, sign and display scale.
+func binaryNumeric(s string) []byte {
+	const (
+		signPos = 0x0000
+		signNeg = 0x4000
+		signNaN = 0xC000
+	)
+	m := newMessage(0)
+	if s == "NaN" {
+		return m.int16(0).int16(0).int16(signNaN).int16(0).data
+	}
+	sign := signPos
+	if strings.HasPrefix(s, "-") {
+		sign, s = signNeg, s[1:]
+	}
+	intPart, frac, _ := strings.Cut(s, ".")
+	scale := len(frac)
+	if pad := len(intPart) % 4; pad != 0 {
+		intPart = strings.Repeat("0", 4-pad) + intPart
+	}
+	if pad := len(frac) % 4; pad != 0 {
+		frac += strings.Repeat("0", 4-pad)
+	}
+
+	var digits []int
+	for i := 0; i < len(intPart); i += 4 {
+		d, _ := strconv.Atoi(intPart[i : i+4])
+		digits = append(digits, d)
+	}
+	weight := len(digits) - 1
+	for i := 0; i < len(frac); i += 4 {
+		d, _ := strconv.Atoi(frac[i : i+4])
+		digits = append(digits, d)
+	}
+	for len(digits) > 0 && digits[0] == 0 {
+		digits = digits[1:]
+		weight--
+	}
+	for len(digits) > 0 && digits[len(digits)-1] == 0 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) == 0 {
+		weight, sign = 0, signPos
+	}
+
+	m.int16(len(digits)).int16(weight).int16(sign).int16(scale)
+	for _, d := range digits {
+		m.int16(d)
+	}
+	return m.data
+}
+
+// paramText converts a parameter sent in binary format to text, which the
+// backend binds and DuckDB casts to the type the statement expects.
+func paramText(b []byte, oid int) (string, error) {
+	switch oid {
+	case oidBool:
+		if len(b) == 1 {
+			return strconv.FormatBool(b[0] != 0), nil
+		}
+	case oidInt2:
+		if len(b) == 2 {
+			return strconv.Itoa(int(int16(binary.BigEndian.Uint16(b)))), nil
+		}
+	case oidInt4:
+		if len(b) == 4 {
+			return strconv.Itoa(int(int32(binary.BigEndian.Uint32(b)))), nil
+		}
+	case oidInt8:
+		if len(b) == 8 {
+			return strconv.FormatInt(int64(binary.BigEndian.Uint64(b)), 10), nil
+		}
+	case oidFloat4:
+		if len(b) == 4 {
+			return strconv.FormatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))), 'g', -1, 32), nil
+		}
+	case oidFloat8:
+		if len(b) == 8 {
+			return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(b)), 'g', -1, 64), nil
+		}
+	case oidDate:
+		if len(b) == 4 {
+			days := int(int32(binary.BigEndian.Uint32(b)))
+			return pgEpoch.AddDate(0, 0, days).Format("2006-01-02"), nil
+		}
+	case oidTimestamp, oidTimestampTZ:
+		if len(b) == 8 {
+			us := int64(binary.BigEndian.Uint64(b))
+			t := time.UnixMicro(pgEpoch.UnixMicro() + us).UTC()
+			if oid == oidTimestampTZ {
+				return t.Format("2006-01-02 15:04:05.999999Z07:00"), nil
+			}
+			return t.Format("2006-01-02 15:04:05.999999"), nil
+		}
+	case oidUUID:
+		if u, err := uuid.FromBytes(b); err == nil {
+			return u.String(), nil
+		}
+	case oidNumeric:
+		return numericText(b)
+	case oidText, oidVarchar, oidJSON, 0:
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("binary parameters of type %d are not supported", oid)
+	}
+	return "", fmt.Errorf("invalid binary value for type %d", oid)
+}
+
+// numericText decodes a binary numeric parameter.
+func numericText(b []byte) (string, error) {
+	r := &reader{data: b}
+	n, weight, sign, scale := r.int16(), r.int16(), r.int16(), r.int16()
+	digits := make([]int, n)
+	for i := range digits {
+		digits[i] = r.int16()
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	if uint16(sign) == 0xC000 {
+		return "NaN", nil
+	}
+
+	// value = sum(digit * 10000^(weight-i))
+	v := new(big.Int)
+	base := big.NewInt(10000)
+	for _, d := range digits {
+		v.Mul(v, base).Add(v, big.NewInt(int64(d)))
+	}
+	// Shift so the result has exactly scale decimal places
+	exp := 4*(weight-n+1) + scale
+	ten := big.NewInt(10)
+	if exp >= 0 {
+		v.Mul(v, new(big.Int).Exp(ten, big.NewInt(int64(exp)), nil))
+	} else {
+		v.Quo(v, new(big.Int).Exp(ten, big.NewInt(int64(-exp)), nil))
+	}
+	s := v.String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if sign == 0x4000 {
+		s = "-" + s
+	}
+	return s, nil
+}
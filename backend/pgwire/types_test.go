@@ -0,0 +1,96 @@
+package pgwire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTextValue(t *testing.T) {
+	tests := []struct {
+		duckType string
+		value    interface{}
+		want     string // "NULL" for a NULL field
+	}{
+		{"DATE", "2024-03-01", "2024-03-01"},
+		{"TIMESTAMP", "2024-03-01T10:30:00", "2024-03-01 10:30:00"},
+		{"TIMESTAMP", "2024-03-01T10:30:00.123456", "2024-03-01 10:30:00.123456"},
+		{"TIMESTAMP_NS", "2024-03-01T10:30:00.123456789", "2024-03-01 10:30:00.123456789"},
+		{"TIMESTAMPTZ", "2024-03-01T10:30:00Z", "2024-03-01 10:30:00+00"},
+		{"DECIMAL(10,2)", "10.50", "10.50"},
+		{"DECIMAL(38,10)", "-0.0000000001", "-0.0000000001"},
+		{"HUGEINT", "170141183460469231731687303715884105727", "170141183460469231731687303715884105727"},
+		{"INTEGER[]", []interface{}{int64(1), int64(2), nil}, "[1,2,null]"},
+		{"VARCHAR[]", []interface{}{"a", `b"c`}, `["a","b\"c"]`},
+		{"DATE[]", []interface{}{"2024-03-01"}, `["2024-03-01"]`},
+		{"INTEGER[][]", []interface{}{[]interface{}{int64(1)}, []interface{}{}}, "[[1],[]]"},
+		{"STRUCT(a INTEGER)", map[string]interface{}{"a": int64(1)}, `{"a":1}`},
+		{"BOOLEAN", true, "t"},
+		{"DOUBLE", 1.5, "1.5"},
+		{"DATE", nil, "NULL"},
+		{"BLOB", "AAH/", `\x0001ff`},
+	}
+	for _, tt := range tests {
+		oid, _ := pgType(tt.duckType)
+		b, null := textValue(tt.value, oid)
+		got := string(b)
+		if null {
+			got = "NULL"
+		}
+		if got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.duckType, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryValueRoundTrip(t *testing.T) {
+	// Values the backend sends, as paramText reads them back from binary
+	tests := []struct {
+		duckType string
+		value    interface{}
+		want     string
+	}{
+		{"DATE", "2024-03-01", "2024-03-01"},
+		{"DATE", "1999-12-31", "1999-12-31"},
+		{"TIMESTAMP", "2024-03-01T10:30:00.5", "2024-03-01 10:30:00.5"},
+		{"TIMESTAMPTZ", "2024-03-01T10:30:00Z", "2024-03-01 10:30:00Z"},
+		{"DECIMAL(10,2)", "10.50", "10.50"},
+		{"DECIMAL(18,4)", "-12345678.0001", "-12345678.0001"},
+		{"DECIMAL(10,3)", "0.001", "0.001"},
+		{"DECIMAL(10,0)", "0", "0"},
+		{"HUGEINT", "100000000", "100000000"},
+		{"BIGINT", int64(-42), "-42"},
+		{"UUID", "0b5a4a4e-5c1f-4b5e-9d2e-6a8c6c8f9a10", "0b5a4a4e-5c1f-4b5e-9d2e-6a8c6c8f9a10"},
+	}
+	for _, tt := range tests {
+		oid, _ := pgType(tt.duckType)
+		b, null, err := binaryValue(tt.value, oid)
+		if err != nil || null {
+			t.Errorf("%s %v: %v, null %v", tt.duckType, tt.value, err, null)
+			continue
+		}
+		got, err := paramText(b, oid)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %v: got %q, %v; want %q", tt.duckType, tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestBinaryNumeric(t *testing.T) {
+	// ndigits, weight, sign, dscale, then base-10000 digits
+	tests := map[string][]int{
+		"10.50":       {2, 0, 0x0000, 2, 10, 5000},
+		"-1234567.89": {3, 1, 0x4000, 2, 123, 4567, 8900},
+		"0.0001":      {1, -1, 0x0000, 4, 1},
+		"0":           {0, 0, 0x0000, 0},
+		"NaN":         {0, 0, 0xC000, 0},
+	}
+	for s, fields := range tests {
+		m := newMessage(0)
+		for _, f := range fields {
+			m.int16(f)
+		}
+		if got := binaryNumeric(s); !bytes.Equal(got, m.data) {
+			t.Errorf("binaryNumeric(%s) = %v, want %v", s, got, m.data)
+		}
+	}
+}
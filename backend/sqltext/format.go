@@ -0,0 +1,266 @@
+package sqltext
+
+import "strings"
+
+// keywords are the words Format upper-cases. Words that are often column or
+// function names (name, first, left, replace, ...) are left as written.
+var keywords = wordSet(`
+	ALL ALTER AND ANTI AS ASC ASOF BETWEEN BY CASE CAST COPY CREATE CROSS
+	DELETE DESC DESCRIBE DISTINCT DROP ELSE END EXCEPT EXCLUDE EXISTS EXPLAIN
+	FALSE FROM FULL GLOB GROUP HAVING IF ILIKE IN INNER INSERT INTERSECT
+	INTERVAL INTO IS JOIN LATERAL LIKE LIMIT MATERIALIZED NATURAL NOT NULL
+	NULLS OFFSET ON OR ORDER OUTER OVER PARTITION PIVOT POSITIONAL QUALIFY
+	RECURSIVE RETURNING SELECT SEMI SET SIMILAR SUMMARIZE TABLE THEN TO TRUE
+	TRY_CAST UNION UNPIVOT UPDATE USING VALUES VIEW WHEN WHERE WINDOW WITH
+`)
+
+// clauses start a new line when they appear at the top of a statement or
+// subquery.
+var clauses = wordSet(`
+	SELECT FROM WHERE GROUP HAVING QUALIFY WINDOW ORDER LIMIT OFFSET UNION
+	EXCEPT INTERSECT WITH VALUES SET RETURNING INSERT UPDATE DELETE USING
+`)
+
+// joinWords start a join, which also goes on a new line.
+var joinWords = wordSet(`JOIN INNER LEFT RIGHT FULL CROSS NATURAL ASOF POSITIONAL ANTI SEMI`)
+
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+const indentUnit = "  "
+
+type formatFrame struct {
+	subquery bool
+	base     int
+	clause   string
+	indent   int // of the line the parenthesis opened on
+}
+
+// Format pretty-prints SQL: keywords upper-cased, each clause on its own
+// line, one select item per line when there are several, AND and OR
+// conditions on their own lines, and subqueries indented. Comments are kept
+// and the text of identifiers, literals and function names is not changed.
+func Format(src string) string {
+	toks := Tokenize(src)
+	var sb strings.Builder
+	base := 0
+	clause := ""
+	var stack []formatFrame
+	var prev *Token
+	prevIndex := -1
+	lineStart := true
+	lineIndent := 0
+	afterBetween := false
+	listPending := false // a SELECT, GROUP BY or ORDER BY list starts next
+
+	newline := func(indent int) {
+		if sb.Len() > 0 && !lineStart {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(strings.Repeat(indentUnit, indent))
+		lineStart = true
+		lineIndent = indent
+	}
+	inSubquery := func() bool {
+		return len(stack) == 0 || stack[len(stack)-1].subquery
+	}
+
+	for i, tok := range toks {
+		next := nextCode(toks, i)
+		word := ""
+		if tok.Kind == Ident {
+			word = tok.Upper()
+		}
+		text := tok.Text
+		isKeyword := (keywords[word] || joinWords[word] && next != nil && next.Text != "(") && !(prev != nil && (prev.Text == "." || prev.IsKeyword("AS"))) &&
+			!(next != nil && next.Text == ".")
+		if isKeyword {
+			text = word
+		}
+
+		switch {
+		case tok.Kind == Comment:
+			if !lineStart {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(text)
+			lineStart = false
+			if strings.HasPrefix(text, "--") {
+				newline(base + clauseIndent(clause))
+			}
+			continue
+
+		case tok.Kind == Punct && tok.Text == ";":
+			sb.WriteString(";")
+			base, clause, stack, listPending = 0, "", nil, false
+			if next != nil {
+				sb.WriteString("\n")
+				newline(0)
+			}
+			prev, prevIndex = &toks[i], i
+			continue
+
+		case listPending && !(isKeyword && (word == "BY" || word == "DISTINCT" || word == "ALL")):
+			listPending = false
+			if multiItem(toks, i) {
+				newline(base + 1)
+			}
+
+		case isKeyword && inSubquery() && (clauses[word] || joinWords[word] && !(prev != nil && (joinWords[prev.Upper()] || prev.IsKeyword("OUTER")))):
+			// DELETE FROM and JOIN ... USING continue the clause they belong to
+			continuation := prev != nil && (word == "FROM" && prev.IsKeyword("DELETE") || word == "USING" && clause == "JOIN")
+			if !continuation {
+				newline(base)
+				clause = word
+				if joinWords[word] {
+					clause = "JOIN"
+				}
+			}
+			listPending = word == "SELECT" || word == "GROUP" || word == "ORDER"
+
+		case isKeyword && (word == "AND" || word == "OR") && inSubquery() && !afterBetween &&
+			(clause == "WHERE" || clause == "HAVING" || clause == "QUALIFY" || clause == "JOIN"):
+			newline(base + 1)
+
+		case tok.Kind == Punct && tok.Text == ")" && len(stack) > 0:
+			frame := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if frame.subquery {
+				newline(frame.indent)
+			}
+			base, clause = frame.base, frame.clause
+		}
+		switch word {
+		case "BETWEEN":
+			afterBetween = true
+		case "AND":
+			afterBetween = false
+		}
+
+		if !lineStart && prev != nil && spaceBetween(toks, prevIndex, i) {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(text)
+		lineStart = false
+
+		switch {
+		case tok.Kind == Punct && tok.Text == "(":
+			sub := next != nil && (next.IsKeyword("SELECT") || next.IsKeyword("WITH") || next.IsKeyword("FROM"))
+			stack = append(stack, formatFrame{subquery: sub, base: base, clause: clause, indent: lineIndent})
+			if sub {
+				base = lineIndent + 1
+				clause = ""
+			}
+		case tok.Kind == Punct && tok.Text == "," && inSubquery():
+			switch {
+			case clause == "WITH":
+				newline(base)
+			case clause == "SELECT" || clause == "GROUP" || clause == "ORDER":
+				newline(base + 1)
+			}
+		}
+		prev, prevIndex = &toks[i], i
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// clauseIndent is how far lines continuing a clause are indented.
+func clauseIndent(clause string) int {
+	if clause == "" {
+		return 0
+	}
+	return 1
+}
+
+func nextCode(toks []Token, i int) *Token {
+	for j := i + 1; j < len(toks); j++ {
+		if toks[j].Kind != Comment {
+			return &toks[j]
+		}
+	}
+	return nil
+}
+
+// multiItem reports whether the list around toks[i] has more than one item,
+// i.e. a comma at the list's own nesting level before the clause ends.
+func multiItem(toks []Token, i int) bool {
+	if toks[i].Kind == Punct && toks[i].Text == "," {
+		return true
+	}
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		t := toks[j]
+		switch {
+		case t.Kind == Punct && (t.Text == "(" || t.Text == "[" || t.Text == "{"):
+			depth++
+		case t.Kind == Punct && (t.Text == ")" || t.Text == "]" || t.Text == "}"):
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case depth > 0:
+		case t.Kind == Punct && t.Text == ",":
+			return true
+		case t.Kind == Punct && t.Text == ";":
+			return false
+		case t.Kind == Ident && (clauses[t.Upper()] || joinWords[t.Upper()]):
+			return false
+		}
+	}
+	return false
+}
+
+// spaceBetween decides whether toks[i] is separated from toks[p], the code
+// token before it.
+func spaceBetween(toks []Token, p, i int) bool {
+	prev, tok := toks[p], toks[i]
+	switch {
+	case tok.Kind == Punct && strings.Contains(",;).]}", tok.Text):
+		return false
+	case prev.Kind == Punct && strings.Contains("(.[{", prev.Text):
+		return false
+	case tok.Text == "." || tok.Text == ":" || tok.Text == "::" || prev.Text == "::":
+		return false
+	case tok.Kind == Punct && tok.Text == "(":
+		// Function calls hug their parenthesis; keywords and table names
+		// (INSERT INTO t (a, b)) don't
+		if prev.IsKeyword("CAST") || prev.IsKeyword("TRY_CAST") {
+			return false
+		}
+		if prev.Kind != Ident || keywords[prev.Upper()] {
+			return true
+		}
+		j := prevCode(toks, p)
+		return j >= 0 && (toks[j].IsKeyword("INTO") || toks[j].IsKeyword("TABLE") || toks[j].IsKeyword("AS"))
+	case tok.Kind == Punct && tok.Text == "[":
+		return !(prev.Kind == Ident || prev.Kind == QuotedIdent || prev.Text == ")" || prev.Text == "]")
+	case prev.Kind == Operator && (prev.Text == "-" || prev.Text == "+") && unary(toks, p):
+		return false
+	}
+	return true
+}
+
+func prevCode(toks []Token, i int) int {
+	j := i - 1
+	for j >= 0 && toks[j].Kind == Comment {
+		j--
+	}
+	return j
+}
+
+// unary reports whether the + or - operator at toks[i] is a sign rather
+// than arithmetic.
+func unary(toks []Token, i int) bool {
+	j := prevCode(toks, i)
+	if j < 0 {
+		return true
+	}
+	before := toks[j]
+	return before.Kind == Operator || before.Kind == Punct && before.Text != ")" && before.Text != "]" ||
+		before.Kind == Ident && keywords[before.Upper()]
+}
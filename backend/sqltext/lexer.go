@@ -0,0 +1,249 @@
+// Package sqltext tokenizes DuckDB SQL text. It understands quoting and
+// comments well enough to split scripts and inspect statements without
+// executing them; it does not build a syntax tree.
+package sqltext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Kind int
+
+const (
+	Ident       Kind = iota // bare identifier or keyword
+	QuotedIdent             // "double quoted"
+	String                  // 'single quoted', E'...', $$dollar quoted$$
+	Number
+	Param    // ?, $1, $name
+	Operator // +, ::, <=, ...
+	Punct    // ( ) , ; . [ ] { }
+	Comment
+)
+
+type Token struct {
+	Kind Kind
+	Text string
+	Pos  int // byte offset into the source
+}
+
+// Upper returns the token text upper-cased, for keyword comparisons.
+func (t Token) Upper() string {
+	return strings.ToUpper(t.Text)
+}
+
+// IsKeyword reports whether t is the bare word kw (case-insensitive).
+func (t Token) IsKeyword(kw string) bool {
+	return t.Kind == Ident && strings.EqualFold(t.Text, kw)
+}
+
+// Name returns the identifier a token refers to, with quotes removed.
+func (t Token) Name() string {
+	if t.Kind == QuotedIdent && len(t.Text) >= 2 {
+		return strings.ReplaceAll(t.Text[1:len(t.Text)-1], `""`, `"`)
+	}
+	return t.Text
+}
+
+const operatorChars = "+-*/<>=~!@#%^&|`:"
+
+// Tokenize splits src into tokens, skipping whitespace. Unterminated strings
+// and comments run to the end of the input.
+func Tokenize(src string) []Token {
+	var toks []Token
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+
+		case strings.HasPrefix(src[i:], "--"):
+			i = indexFrom(src, i, "\n")
+			toks = append(toks, Token{Comment, src[start:i], start})
+
+		case strings.HasPrefix(src[i:], "/*"):
+			i = skipBlockComment(src, i)
+			toks = append(toks, Token{Comment, src[start:i], start})
+
+		case r == '\'':
+			i = skipQuoted(src, i, '\'', false)
+			toks = append(toks, Token{String, src[start:i], start})
+
+		case (r == 'e' || r == 'E') && i+1 < len(src) && src[i+1] == '\'':
+			i = skipQuoted(src, i+1, '\'', true)
+			toks = append(toks, Token{String, src[start:i], start})
+
+		case r == '"':
+			i = skipQuoted(src, i, '"', false)
+			toks = append(toks, Token{QuotedIdent, src[start:i], start})
+
+		case r == '$':
+			if tag, ok := dollarTag(src[i:]); ok {
+				end := strings.Index(src[i+len(tag):], tag)
+				if end < 0 {
+					i = len(src)
+				} else {
+					i += len(tag) + end + len(tag)
+				}
+				toks = append(toks, Token{String, src[start:i], start})
+			} else {
+				i++
+				for i < len(src) && isIdentChar(rune(src[i])) {
+					i++
+				}
+				toks = append(toks, Token{Param, src[start:i], start})
+			}
+
+		case r == '?':
+			i++
+			toks = append(toks, Token{Param, src[start:i], start})
+
+		case r >= '0' && r <= '9', r == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			i = skipNumber(src, i)
+			toks = append(toks, Token{Number, src[start:i], start})
+
+		case isIdentStart(r):
+			i += size
+			for i < len(src) {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if !isIdentChar(r) {
+					break
+				}
+				i += size
+			}
+			toks = append(toks, Token{Ident, src[start:i], start})
+
+		case strings.ContainsRune("(),;.[]{}", r):
+			i++
+			toks = append(toks, Token{Punct, src[start:i], start})
+
+		case strings.ContainsRune(operatorChars, r):
+			for i < len(src) && strings.IndexByte(operatorChars, src[i]) >= 0 {
+				// Don't swallow the start of a comment into an operator
+				if strings.HasPrefix(src[i:], "--") || strings.HasPrefix(src[i:], "/*") {
+					break
+				}
+				i++
+			}
+			if i == start {
+				i++
+			}
+			toks = append(toks, Token{Operator, src[start:i], start})
+
+		default:
+			i += size
+			toks = append(toks, Token{Operator, src[start:i], start})
+		}
+	}
+	return toks
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func indexFrom(src string, i int, sub string) int {
+	if j := strings.Index(src[i:], sub); j >= 0 {
+		return i + j
+	}
+	return len(src)
+}
+
+// skipBlockComment handles nested /* */ comments as DuckDB does.
+func skipBlockComment(src string, i int) int {
+	depth := 0
+	for i < len(src) {
+		switch {
+		case strings.HasPrefix(src[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(src[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted returns the offset just past the quoted run starting at i. A
+// doubled quote is an escaped quote; backslash escapes apply to E-prefixed strings.
+func skipQuoted(src string, i int, q byte, backslash bool) int {
+	i++
+	for i < len(src) {
+		switch {
+		case backslash && src[i] == '\\':
+			i += 2
+		case src[i] == q:
+			if i+1 < len(src) && src[i+1] == q {
+				i += 2
+				continue
+			}
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(src)
+}
+
+func skipNumber(src string, i int) int {
+	if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+		i += 2
+		for i < len(src) && (isHex(src[i]) || src[i] == '_') {
+			i++
+		}
+		return i
+	}
+	for i < len(src) && (isDigit(src[i]) || src[i] == '_' || src[i] == '.') {
+		i++
+	}
+	if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+		j := i + 1
+		if j < len(src) && (src[j] == '+' || src[j] == '-') {
+			j++
+		}
+		if j < len(src) && isDigit(src[j]) {
+			i = j
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// dollarTag recognizes the opening delimiter of a dollar-quoted string, such
+// as $$ or $body$.
+func dollarTag(s string) (string, bool) {
+	if strings.HasPrefix(s, "$$") {
+		return "$$", true
+	}
+	i := 1
+	for i < len(s) && (s[i] == '_' || unicode.IsLetter(rune(s[i]))) {
+		i++
+	}
+	if i > 1 && i < len(s) && s[i] == '$' {
+		return s[:i+1], true
+	}
+	return "", false
+}
@@ -0,0 +1,62 @@
+package sqltext
+
+import "strings"
+
+// Statement is one statement of a script, with its byte range in the source.
+type Statement struct {
+	SQL   string
+	Start int
+	End   int
+}
+
+// Split breaks a script into statements at top-level semicolons. Semicolons
+// inside strings, quoted identifiers and comments are ignored, and statements
+// that are empty or contain only comments are dropped.
+func Split(src string) []Statement {
+	var stmts []Statement
+	start := 0
+	hasCode := false
+
+	flush := func(end int) {
+		if hasCode {
+			text := strings.TrimSpace(src[start:end])
+			offset := start + strings.Index(src[start:end], text)
+			stmts = append(stmts, Statement{SQL: text, Start: offset, End: offset + len(text)})
+		}
+		hasCode = false
+	}
+
+	for _, tok := range Tokenize(src) {
+		switch {
+		case tok.Kind == Punct && tok.Text == ";":
+			flush(tok.Pos)
+			start = tok.Pos + 1
+		case tok.Kind != Comment:
+			hasCode = true
+		}
+	}
+	flush(len(src))
+	return stmts
+}
+
+// Code returns the tokens of src with comments removed.
+func Code(src string) []Token {
+	toks := Tokenize(src)
+	code := toks[:0]
+	for _, t := range toks {
+		if t.Kind != Comment {
+			code = append(code, t)
+		}
+	}
+	return code
+}
+
+// FirstKeyword returns the first bare word of a statement, upper-cased, or ""
+// if it starts with something else.
+func FirstKeyword(stmt string) string {
+	code := Code(stmt)
+	if len(code) == 0 || code[0].Kind != Ident {
+		return ""
+	}
+	return code[0].Upper()
+}
@@ -0,0 +1,127 @@
+package sqltext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	type tok struct {
+		Kind Kind
+		Text string
+	}
+	tests := []struct {
+		src  string
+		want []tok
+	}{
+		{"SELECT a.b, 1.5e3 FROM t", []tok{
+			{Ident, "SELECT"}, {Ident, "a"}, {Punct, "."}, {Ident, "b"}, {Punct, ","},
+			{Number, "1.5e3"}, {Ident, "FROM"}, {Ident, "t"},
+		}},
+		{`"c""d" 'it''s' E'it\'s' $tag$x;y$tag$ $$z$$`, []tok{
+			{QuotedIdent, `"c""d"`}, {String, `'it''s'`}, {String, `E'it\'s'`},
+			{String, "$tag$x;y$tag$"}, {String, "$$z$$"},
+		}},
+		{"a >= $1 AND b = ? AND c = $name AND d::INT <> 0x1F", []tok{
+			{Ident, "a"}, {Operator, ">="}, {Param, "$1"}, {Ident, "AND"},
+			{Ident, "b"}, {Operator, "="}, {Param, "?"}, {Ident, "AND"},
+			{Ident, "c"}, {Operator, "="}, {Param, "$name"}, {Ident, "AND"},
+			{Ident, "d"}, {Operator, "::"}, {Ident, "INT"}, {Operator, "<>"}, {Number, "0x1F"},
+		}},
+		{"x /* a; */ -- b;\ny", []tok{
+			{Ident, "x"}, {Comment, "/* a; */"}, {Comment, "-- b;"}, {Ident, "y"},
+		}},
+		{"SELECT 'open", []tok{{Ident, "SELECT"}, {String, "'open"}}},
+	}
+	for _, tt := range tests {
+		var got []tok
+		for _, t := range Tokenize(tt.src) {
+			got = append(got, tok{t.Kind, t.Text})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q)\n got %v\nwant %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	src := "SELECT  \"Na\"\"me\" -- c\nFROM t"
+	for _, tok := range Tokenize(src) {
+		if src[tok.Pos:tok.Pos+len(tok.Text)] != tok.Text {
+			t.Errorf("token %q at %d doesn't match the source", tok.Text, tok.Pos)
+		}
+	}
+	if name := Tokenize(`"Na""me"`)[0].Name(); name != `Na"me` {
+		t.Errorf("Name() = %q", name)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"SELECT 1; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"SELECT ';' ; SELECT \";\"", []string{"SELECT ';'", `SELECT ";"`}},
+		{"SELECT $$a;b$$;", []string{"SELECT $$a;b$$"}},
+		{"-- only a comment;\n; /* another; */ ;; SELECT 1 -- trailing", []string{"SELECT 1 -- trailing"}},
+		{" ;\n ; ", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range Split(tt.src) {
+			got = append(got, s.SQL)
+			if tt.src[s.Start:s.End] != s.SQL {
+				t.Errorf("Split(%q): range %d-%d doesn't match %q", tt.src, s.Start, s.End, s.SQL)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestFirstKeyword(t *testing.T) {
+	tests := map[string]string{
+		"select 1":                "SELECT",
+		"/* hint */ with c as ()": "WITH",
+		"-- c\n  From t":          "FROM",
+		"(SELECT 1)":              "",
+		"":                        "",
+	}
+	for stmt, want := range tests {
+		if got := FirstKeyword(stmt); got != want {
+			t.Errorf("FirstKeyword(%q) = %q, want %q", stmt, got, want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"select a, b from t where x = 1 and y > 2 order by a",
+			"SELECT\n  a,\n  b\nFROM t\nWHERE x = 1\n  AND y > 2\nORDER BY a"},
+		{"select count(*) from t join u on t.id = u.id group by 1",
+			"SELECT count(*)\nFROM t\nJOIN u ON t.id = u.id\nGROUP BY 1"},
+		{"select * from (select a from t) as s limit 5",
+			"SELECT *\nFROM (\n  SELECT a\n  FROM t\n) AS s\nLIMIT 5"},
+		{"SELECT id FROM orders WHERE amount BETWEEN 1 AND 10 OR status = 'open' -- recent",
+			"SELECT id\nFROM orders\nWHERE amount BETWEEN 1 AND 10\n  OR status = 'open' -- recent"},
+		{"with c as (select 1 as x) select x, -x from c",
+			"WITH c AS (\n  SELECT 1 AS x\n)\nSELECT\n  x,\n  -x\nFROM c"},
+		{"select a::int, 'it''s' from t where name like 'a%'",
+			"SELECT\n  a::int,\n  'it''s'\nFROM t\nWHERE name LIKE 'a%'"},
+		// Keywords that are often column names keep their case
+		{"select name, first from t", "SELECT\n  name,\n  first\nFROM t"},
+	}
+	for _, tt := range tests {
+		got := Format(tt.src)
+		if got != tt.want {
+			t.Errorf("Format(%q)\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+		if again := Format(got); again != got {
+			t.Errorf("Format isn't stable on %q: %q", got, again)
+		}
+	}
+}
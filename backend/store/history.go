@@ -0,0 +1,179 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const historyFile = "history.jsonl"
+
+// HistoryEntry records one query execution.
+type HistoryEntry struct {
+	ID             string          `json:"id"`
+	QueryID        string          `json:"queryId"`
+	SQL            string          `json:"sql"`
+	Params         json.RawMessage `json:"params,omitempty"`
+	Source         string          `json:"source"`
+	Started        time.Time       `json:"started"`
+	DurationMs     float64         `json:"durationMs"`
+	RowCount       int             `json:"rowCount"`
+	Error          string          `json:"error,omitempty"`
+	DatasetVersion int64           `json:"datasetVersion"`
+	RerunOf        string          `json:"rerunOf,omitempty"`
+	Cached         bool            `json:"cached,omitempty"`
+	Script         bool            `json:"script,omitempty"`
+	Transaction    bool            `json:"transaction,omitempty"`
+}
+
+// HistoryFilter selects entries for SearchHistory. Zero fields match
+// everything.
+type HistoryFilter struct {
+	Text   string // case-insensitive substring of the SQL
+	Source string
+	Status string // "ok" or "error"
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+var (
+	historyMu    sync.Mutex
+	history      []HistoryEntry // oldest first
+	historyLimit = 10000
+)
+
+// loadHistory reads the history log, keeping the newest HISTORY_LIMIT
+// entries and compacting the file if it has grown past that.
+func loadHistory() error {
+	if v := os.Getenv("HISTORY_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			historyLimit = n
+		}
+	}
+
+	f, err := os.Open(path(historyFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("History: skipping unreadable entry: %v", err)
+			continue
+		}
+		history = append(history, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(history) > historyLimit {
+		history = append([]HistoryEntry(nil), history[len(history)-historyLimit:]...)
+		return rewriteHistory()
+	}
+	return nil
+}
+
+func rewriteHistory() error {
+	tmp := path(historyFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range history {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path(historyFile))
+}
+
+// RecordQuery appends an entry to the history.
+func RecordQuery(e HistoryEntry) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history = append(history, e)
+	if len(history) > historyLimit*2 {
+		history = append([]HistoryEntry(nil), history[len(history)-historyLimit:]...)
+		if err := rewriteHistory(); err != nil {
+			log.Printf("History: failed to compact log: %v", err)
+		}
+		return
+	}
+
+	f, err := os.OpenFile(path(historyFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("History: failed to open log: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		log.Printf("History: failed to write entry: %v", err)
+	}
+}
+
+// SearchHistory returns matching entries, newest first, along with the total
+// number of matches before Limit and Offset are applied.
+func SearchHistory(f HistoryFilter) ([]HistoryEntry, int) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	text := strings.ToLower(f.Text)
+	var matches []HistoryEntry
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i]
+		switch {
+		case text != "" && !strings.Contains(strings.ToLower(e.SQL), text):
+		case f.Source != "" && e.Source != f.Source:
+		case f.Status == "ok" && e.Error != "":
+		case f.Status == "error" && e.Error == "":
+		case !f.Since.IsZero() && e.Started.Before(f.Since):
+		case !f.Until.IsZero() && e.Started.After(f.Until):
+		default:
+			matches = append(matches, e)
+		}
+	}
+
+	total := len(matches)
+	if f.Offset >= total {
+		return []HistoryEntry{}, total
+	}
+	matches = matches[f.Offset:]
+	if f.Limit > 0 && len(matches) > f.Limit {
+		matches = matches[:f.Limit]
+	}
+	return matches, total
+}
+
+// HistoryEntryByID looks up a single entry.
+func HistoryEntryByID(id string) (HistoryEntry, bool) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == id {
+			return history[i], true
+		}
+	}
+	return HistoryEntry{}, false
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const macrosFile = "macros.json"
+
+// Macro is a user-defined DuckDB macro. A scalar macro's body is an
+// expression; a table macro's body is a query.
+type Macro struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"` // "scalar" or "table"
+	Params      []string  `json:"params"`
+	Body        string    `json:"body"`
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+var (
+	macrosMu sync.Mutex
+	macros   = map[string]Macro{}
+)
+
+func loadMacros() error {
+	var list []Macro
+	if err := readJSON(macrosFile, &list); err != nil {
+		return err
+	}
+	for _, m := range list {
+		macros[m.ID] = m
+	}
+	return nil
+}
+
+// persistMacros writes the store; macrosMu must be held. Macros are kept in
+// creation order so they can be re-created in an order where a macro's
+// dependencies already exist.
+func persistMacros() error {
+	return writeJSON(macrosFile, sortedMacros(func(a, b Macro) bool { return a.Created.Before(b.Created) }))
+}
+
+func sortedMacros(less func(a, b Macro) bool) []Macro {
+	list := make([]Macro, 0, len(macros))
+	for _, m := range macros {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return less(list[i], list[j]) })
+	return list
+}
+
+// ListMacros returns the macros sorted by name.
+func ListMacros() []Macro {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	return sortedMacros(func(a, b Macro) bool { return a.Name < b.Name })
+}
+
+// MacrosByCreation returns the macros oldest first.
+func MacrosByCreation() []Macro {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	return sortedMacros(func(a, b Macro) bool { return a.Created.Before(b.Created) })
+}
+
+// GetMacro looks up a macro by ID.
+func GetMacro(id string) (Macro, bool) {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	m, ok := macros[id]
+	return m, ok
+}
+
+// FindMacro looks up a macro by name, ignoring case as DuckDB does.
+func FindMacro(name string) (Macro, bool) {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	for _, m := range macros {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return Macro{}, false
+}
+
+// PutMacro creates or replaces a macro.
+func PutMacro(m Macro) error {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	prev, existed := macros[m.ID]
+	macros[m.ID] = m
+	if err := persistMacros(); err != nil {
+		if existed {
+			macros[m.ID] = prev
+		} else {
+			delete(macros, m.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteMacro removes a macro and returns it, reporting whether it existed.
+func DeleteMacro(id string) (Macro, bool, error) {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	m, ok := macros[id]
+	if !ok {
+		return Macro{}, false, nil
+	}
+	delete(macros, id)
+	if err := persistMacros(); err != nil {
+		macros[id] = m
+		return Macro{}, false, err
+	}
+	return m, true, nil
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricModelsFile = "metrics.json"
+
+// MetricModel holds the canonical definitions of business metrics over one
+// table. Expressions are DuckDB SQL evaluated against the table's columns.
+type MetricModel struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Table       string         `json:"table"`
+	Description string         `json:"description,omitempty"`
+	Measures    []Measure      `json:"measures"`
+	Dimensions  []Dimension    `json:"dimensions"`
+	Filters     []MetricFilter `json:"filters"`
+	Created     time.Time      `json:"created"`
+	Updated     time.Time      `json:"updated"`
+}
+
+// Measure is an aggregate, such as sum(amount).
+type Measure struct {
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	Description string `json:"description,omitempty"`
+}
+
+// Dimension is a row-level expression results can be grouped by. Time
+// dimensions can be truncated to the listed grains (all grains if empty).
+type Dimension struct {
+	Name        string   `json:"name"`
+	Expr        string   `json:"expr"`
+	Type        string   `json:"type,omitempty"` // "time" or empty
+	Grains      []string `json:"grains,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// MetricFilter is a named boolean condition, such as completed orders.
+type MetricFilter struct {
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	metricsMu    sync.Mutex
+	metricModels = map[string]MetricModel{}
+)
+
+func loadMetricModels() error {
+	var list []MetricModel
+	if err := readJSON(metricModelsFile, &list); err != nil {
+		return err
+	}
+	for _, m := range list {
+		metricModels[m.ID] = m
+	}
+	return nil
+}
+
+// persistMetricModels writes the store; metricsMu must be held.
+func persistMetricModels() error {
+	list := make([]MetricModel, 0, len(metricModels))
+	for _, m := range metricModels {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSON(metricModelsFile, list)
+}
+
+// ListMetricModels returns the metric models sorted by name.
+func ListMetricModels() []MetricModel {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	list := make([]MetricModel, 0, len(metricModels))
+	for _, m := range metricModels {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetMetricModel looks up a metric model by ID.
+func GetMetricModel(id string) (MetricModel, bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m, ok := metricModels[id]
+	return m, ok
+}
+
+// FindMetricModel looks up a metric model by name, ignoring case.
+func FindMetricModel(name string) (MetricModel, bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metricModels {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return MetricModel{}, false
+}
+
+// PutMetricModel creates or replaces a metric model.
+func PutMetricModel(m MetricModel) error {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	prev, existed := metricModels[m.ID]
+	metricModels[m.ID] = m
+	if err := persistMetricModels(); err != nil {
+		if existed {
+			metricModels[m.ID] = prev
+		} else {
+			delete(metricModels, m.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteMetricModel removes a metric model, reporting whether it existed.
+func DeleteMetricModel(id string) (bool, error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m, ok := metricModels[id]
+	if !ok {
+		return false, nil
+	}
+	delete(metricModels, id)
+	if err := persistMetricModels(); err != nil {
+		metricModels[id] = m
+		return false, err
+	}
+	return true, nil
+}
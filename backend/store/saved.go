@@ -0,0 +1,125 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const savedQueriesFile = "saved_queries.json"
+
+// Variable is a named input of a saved query.
+type Variable struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Description string          `json:"description,omitempty"`
+}
+
+// SavedQuery is a reusable query whose SQL may reference variables as
+// :name, {{name}} or $name.
+type SavedQuery struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	SQL         string     `json:"sql"`
+	Variables   []Variable `json:"variables"`
+	Tags        []string   `json:"tags"`
+	Owner       string     `json:"owner,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
+
+// HasTag reports whether the query carries tag.
+func (q SavedQuery) HasTag(tag string) bool {
+	for _, t := range q.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	savedMu      sync.Mutex
+	savedQueries = map[string]SavedQuery{}
+)
+
+func loadSavedQueries() error {
+	var list []SavedQuery
+	if err := readJSON(savedQueriesFile, &list); err != nil {
+		return err
+	}
+	for _, q := range list {
+		savedQueries[q.ID] = q
+	}
+	return nil
+}
+
+// persistSavedQueries writes the store; savedMu must be held.
+func persistSavedQueries() error {
+	list := make([]SavedQuery, 0, len(savedQueries))
+	for _, q := range savedQueries {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSON(savedQueriesFile, list)
+}
+
+// ListSavedQueries returns saved queries sorted by name, optionally only those
+// with the given tag.
+func ListSavedQueries(tag string) []SavedQuery {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+
+	list := []SavedQuery{}
+	for _, q := range savedQueries {
+		if tag == "" || q.HasTag(tag) {
+			list = append(list, q)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetSavedQuery looks up a saved query by ID.
+func GetSavedQuery(id string) (SavedQuery, bool) {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+	q, ok := savedQueries[id]
+	return q, ok
+}
+
+// PutSavedQuery creates or replaces a saved query.
+func PutSavedQuery(q SavedQuery) error {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+	prev, existed := savedQueries[q.ID]
+	savedQueries[q.ID] = q
+	if err := persistSavedQueries(); err != nil {
+		if existed {
+			savedQueries[q.ID] = prev
+		} else {
+			delete(savedQueries, q.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteSavedQuery removes a saved query, reporting whether it existed.
+func DeleteSavedQuery(id string) (bool, error) {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+	q, ok := savedQueries[id]
+	if !ok {
+		return false, nil
+	}
+	delete(savedQueries, id)
+	if err := persistSavedQueries(); err != nil {
+		savedQueries[id] = q
+		return false, err
+	}
+	return true, nil
+}
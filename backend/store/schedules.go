@@ -0,0 +1,194 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	schedulesFile    = "schedules.json"
+	scheduleRunsFile = "schedule_runs.json"
+
+	// runsPerSchedule caps the run history kept for each schedule.
+	runsPerSchedule = 100
+)
+
+// ScheduleOutput says where a scheduled run writes its result: a catalog
+// table (appending rows stamped with the run time, or replacing it) or a new
+// export file per run.
+type ScheduleOutput struct {
+	Kind     string `json:"kind"`               // table or file
+	Table    string `json:"table,omitempty"`    // kind table
+	Mode     string `json:"mode,omitempty"`     // append or replace
+	Format   string `json:"format,omitempty"`   // kind file: csv, parquet or json
+	FileName string `json:"fileName,omitempty"` // base name; the run time is appended
+}
+
+// Schedule runs a saved query on a cron expression.
+type Schedule struct {
+	ID           string                     `json:"id"`
+	Name         string                     `json:"name"`
+	SavedQueryID string                     `json:"savedQueryId"`
+	Cron         string                     `json:"cron"`
+	Timezone     string                     `json:"timezone"`
+	Variables    map[string]json.RawMessage `json:"variables"`
+	Output       ScheduleOutput             `json:"output"`
+	Enabled      bool                       `json:"enabled"`
+	Created      time.Time                  `json:"created"`
+	Updated      time.Time                  `json:"updated"`
+	LastRun      *time.Time                 `json:"lastRun,omitempty"`
+	LastStatus   string                     `json:"lastStatus,omitempty"`
+	LastError    string                     `json:"lastError,omitempty"`
+}
+
+// ScheduleRun records one execution of a schedule.
+type ScheduleRun struct {
+	ID         string    `json:"id"`
+	ScheduleID string    `json:"scheduleId"`
+	QueryID    string    `json:"queryId"`
+	Started    time.Time `json:"started"`
+	DurationMs float64   `json:"durationMs"`
+	Status     string    `json:"status"` // ok or error
+	Error      string    `json:"error,omitempty"`
+	RowCount   int64     `json:"rowCount"`
+	Output     string    `json:"output,omitempty"` // table name or file path
+	Manual     bool      `json:"manual,omitempty"`
+}
+
+var (
+	schedulesMu  sync.Mutex
+	schedules    = map[string]Schedule{}
+	scheduleRuns []ScheduleRun // oldest first
+)
+
+func loadSchedules() error {
+	var list []Schedule
+	if err := readJSON(schedulesFile, &list); err != nil {
+		return err
+	}
+	for _, s := range list {
+		schedules[s.ID] = s
+	}
+	return readJSON(scheduleRunsFile, &scheduleRuns)
+}
+
+// persistSchedules writes the schedules; schedulesMu must be held.
+func persistSchedules() error {
+	list := make([]Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSON(schedulesFile, list)
+}
+
+// ListSchedules returns all schedules sorted by name.
+func ListSchedules() []Schedule {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	list := make([]Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetSchedule looks up a schedule by ID.
+func GetSchedule(id string) (Schedule, bool) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s, ok := schedules[id]
+	return s, ok
+}
+
+// PutSchedule creates or replaces a schedule.
+func PutSchedule(s Schedule) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	prev, existed := schedules[s.ID]
+	schedules[s.ID] = s
+	if err := persistSchedules(); err != nil {
+		if existed {
+			schedules[s.ID] = prev
+		} else {
+			delete(schedules, s.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteSchedule removes a schedule and its run history, reporting whether
+// it existed.
+func DeleteSchedule(id string) (bool, error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s, ok := schedules[id]
+	if !ok {
+		return false, nil
+	}
+	delete(schedules, id)
+	if err := persistSchedules(); err != nil {
+		schedules[id] = s
+		return false, err
+	}
+
+	kept := scheduleRuns[:0]
+	for _, r := range scheduleRuns {
+		if r.ScheduleID != id {
+			kept = append(kept, r)
+		}
+	}
+	scheduleRuns = kept
+	return true, writeJSON(scheduleRunsFile, scheduleRuns)
+}
+
+// RecordScheduleRun appends a run and updates the schedule's last-run
+// fields. Only the newest runsPerSchedule runs of each schedule are kept.
+func RecordScheduleRun(run ScheduleRun) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+
+	if s, ok := schedules[run.ScheduleID]; ok {
+		started := run.Started
+		s.LastRun, s.LastStatus, s.LastError = &started, run.Status, run.Error
+		schedules[s.ID] = s
+		if err := persistSchedules(); err != nil {
+			return err
+		}
+	}
+
+	scheduleRuns = append(scheduleRuns, run)
+	count := 0
+	kept := make([]ScheduleRun, 0, len(scheduleRuns))
+	for i := len(scheduleRuns) - 1; i >= 0; i-- {
+		r := scheduleRuns[i]
+		if r.ScheduleID == run.ScheduleID {
+			if count++; count > runsPerSchedule {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	scheduleRuns = kept
+	return writeJSON(scheduleRunsFile, scheduleRuns)
+}
+
+// ScheduleRuns returns a schedule's runs, newest first.
+func ScheduleRuns(id string) []ScheduleRun {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	runs := []ScheduleRun{}
+	for i := len(scheduleRuns) - 1; i >= 0; i-- {
+		if scheduleRuns[i].ScheduleID == id {
+			runs = append(runs, scheduleRuns[i])
+		}
+	}
+	return runs
+}
@@ -0,0 +1,75 @@
+// Package store persists application metadata (query history, saved queries
+// and the like) as JSON files in a data directory, so it survives restarts of
+// the in-memory DuckDB instance.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var dir = "data"
+
+// Init prepares the data directory (DATA_DIR, default ./data) and loads
+// persisted state.
+func Init() error {
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		dir = v
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := loadHistory(); err != nil {
+		return err
+	}
+	if err := loadSavedQueries(); err != nil {
+		return err
+	}
+	if err := loadMetricModels(); err != nil {
+		return err
+	}
+	if err := loadMacros(); err != nil {
+		return err
+	}
+	return loadSchedules()
+}
+
+// Dir returns the data directory.
+func Dir() string {
+	return dir
+}
+
+func path(name string) string {
+	return filepath.Join(dir, name)
+}
+
+// readJSON decodes a data file into v. A missing file leaves v untouched.
+func readJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeJSON replaces a data file atomically.
+func writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path(name + ".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path(name))
+}
@@ -6,6 +6,8 @@ import {
   ColumnInfo,
   NumericStats,
   TextStats,
+  TemporalStats,
+  BooleanStats,
   HistogramBucket,
   ValueCount,
 } from "@/lib/api";
@@ -18,6 +20,10 @@ function isNumeric(col: ColumnInfo): boolean {
   return col.type === "INTEGER" || col.type === "REAL";
 }
 
+function isTemporal(col: ColumnInfo): boolean {
+  return ["DATE", "TIMESTAMP", "TIME", "INTERVAL"].includes(col.type);
+}
+
 function Bar({ ratio, label, count }: { ratio: number; label: string; count: number }) {
   return (
     <div className="flex items-center gap-1.5 text-[11px] leading-tight">
@@ -71,6 +77,71 @@ function NumericDetail({ col }: { col: ColumnInfo }) {
   );
 }
 
+function TemporalDetail({ col }: { col: ColumnInfo }) {
+  const s = col.stats as TemporalStats | undefined;
+  const dist = (col.distribution ?? []) as (HistogramBucket | ValueCount)[];
+
+  if (!s) return null;
+
+  const maxCount = Math.max(...dist.map((b) => b.count), 1);
+  const weekdays = s.dayOfWeek ?? [];
+  const maxWeekday = Math.max(...weekdays.map((v) => v.count), 1);
+
+  return (
+    <div className="space-y-2 mt-2">
+      <div className="grid grid-cols-2 gap-x-3 gap-y-0.5 text-[11px]">
+        <span className="text-gray-500">Min</span>
+        <span className="text-gray-700 text-right font-mono">{s.min ?? "—"}</span>
+        <span className="text-gray-500">Max</span>
+        <span className="text-gray-700 text-right font-mono">{s.max ?? "—"}</span>
+        <span className="text-gray-500">Unique</span>
+        <span className="text-gray-700 text-right font-mono">{s.distinctCount}</span>
+        <span className="text-gray-500">Nulls</span>
+        <span className="text-gray-700 text-right font-mono">{s.nullCount}</span>
+      </div>
+      {dist.length > 0 && (
+        <div className="space-y-0.5">
+          {s.granularity && (
+            <div className="text-[10px] text-gray-400 uppercase tracking-wider">By {s.granularity}</div>
+          )}
+          {dist.map((b, i) => (
+            <Bar
+              key={i}
+              ratio={b.count / maxCount}
+              label={`${"bucketMin" in b ? b.bucketMin : b.value}`}
+              count={b.count}
+            />
+          ))}
+        </div>
+      )}
+      {weekdays.length > 0 && (
+        <div className="space-y-0.5">
+          <div className="text-[10px] text-gray-400 uppercase tracking-wider">By weekday</div>
+          {weekdays.map((v, i) => (
+            <Bar key={i} ratio={v.count / maxWeekday} label={`${v.value}`} count={v.count} />
+          ))}
+        </div>
+      )}
+    </div>
+  );
+}
+
+function BooleanDetail({ col }: { col: ColumnInfo }) {
+  const s = col.stats as BooleanStats | undefined;
+
+  if (!s) return null;
+
+  const maxCount = Math.max(s.trueCount, s.falseCount, s.nullCount, 1);
+
+  return (
+    <div className="space-y-0.5 mt-2">
+      <Bar ratio={s.trueCount / maxCount} label="true" count={s.trueCount} />
+      <Bar ratio={s.falseCount / maxCount} label="false" count={s.falseCount} />
+      <Bar ratio={s.nullCount / maxCount} label="null" count={s.nullCount} />
+    </div>
+  );
+}
+
 function TextDetail({ col }: { col: ColumnInfo }) {
   const s = col.stats as TextStats | undefined;
   const dist = (col.distribution ?? []) as ValueCount[];
@@ -93,7 +164,7 @@ function TextDetail({ col }: { col: ColumnInfo }) {
             <Bar
               key={i}
               ratio={v.count / maxCount}
-              label={v.value}
+              label={`${v.value}`}
               count={v.count}
             />
           ))}
@@ -138,6 +209,14 @@ export default function StatsSidebar({ stats }: StatsSidebarProps) {
         </div>
       </div>
 
+      {stats.status && stats.status !== "fresh" && (
+        <div className="text-xs text-amber-600">
+          {stats.status === "computing"
+            ? "Data changed; updating stats…"
+            : "Stats may be out of date"}
+        </div>
+      )}
+
       <div className="space-y-1">
         {stats.columns.map((col) => {
           const open = !!expanded[col.name];
@@ -169,6 +248,10 @@ export default function StatsSidebar({ stats }: StatsSidebarProps) {
                 <div className="px-2.5 pb-2.5 border-t border-gray-100">
                   {isNumeric(col) ? (
                     <NumericDetail col={col} />
+                  ) : isTemporal(col) ? (
+                    <TemporalDetail col={col} />
+                  ) : col.type === "BOOLEAN" ? (
+                    <BooleanDetail col={col} />
                   ) : (
                     <TextDetail col={col} />
                   )}
@@ -1,11 +1,12 @@
 export interface HistogramBucket {
-  bucketMin: number;
-  bucketMax: number;
+  // Dates and timestamps are strings
+  bucketMin: number | string;
+  bucketMax: number | string;
   count: number;
 }
 
 export interface ValueCount {
-  value: string;
+  value: string | number;
   count: number;
 }
 
@@ -22,14 +23,37 @@ export interface TextStats {
   topValues: ValueCount[];
 }
 
+// DATE, TIMESTAMP, TIME and INTERVAL columns
+export interface TemporalStats {
+  min: string | null;
+  max: string | null;
+  nullCount: number;
+  distinctCount: number;
+  rangeDays?: number | null;
+  granularity?: string | null;
+  dayOfWeek?: ValueCount[];
+  hourOfDay?: ValueCount[];
+}
+
+export interface BooleanStats {
+  trueCount: number;
+  falseCount: number;
+  nullCount: number;
+}
+
 export interface ColumnInfo {
   name: string;
   type: string;
-  stats?: NumericStats | TextStats;
+  stats?: NumericStats | TextStats | TemporalStats | BooleanStats;
   distribution?: HistogramBucket[] | ValueCount[];
 }
 
 export interface StatsResponse {
+  // fresh: matches the current data; computing: from an older version,
+  // being recomputed; stale: from an older version, recomputing failed
+  status?: "fresh" | "computing" | "stale";
+  datasetVersion?: number;
+  computedAt?: string;
   rowCount: number;
   columnCount: number;
   columns: ColumnInfo[];